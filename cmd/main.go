@@ -5,15 +5,25 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"rsclabs-test/internal/repository/inmemorystorage"
+	"rsclabs-test/internal/repository/snapshotlog"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
+	// The zones of the statistics requests do not depend on the host
+	_ "time/tzdata"
 
+	"github.com/gofiber/fiber/v2"
 	"rsclabs-test/config"
 	"rsclabs-test/internal/controller/http"
+	"rsclabs-test/internal/metrics"
+	"rsclabs-test/internal/model"
 	"rsclabs-test/internal/repository"
 	"rsclabs-test/internal/service"
 	"rsclabs-test/internal/worker"
+	"rsclabs-test/pkg/geoip"
 	"rsclabs-test/pkg/httpserver"
 	"rsclabs-test/pkg/observe"
 )
@@ -25,32 +35,74 @@ func main() {
 
 	l := observe.NewZapLogger(cnf.AppName, os.Stdout)
 
-	server := httpserver.InitFiberServer(cnf.AppName)
+	var sentryHook *observe.SentryHook
+	if cnf.SentryDSN != "" {
+		var err error
+		sentryHook, err = observe.NewSentryHook(observe.SentryOptions{
+			DSN:         cnf.SentryDSN,
+			Environment: cnf.SentryEnvironment,
+			Release:     cnf.AppName + "@" + cnf.AppVersion,
+			ServerName:  cnf.AppName,
+			SampleRate:  cnf.SentrySampleRate,
+			Debug:       cnf.SentryDebug,
+		})
+		if err != nil {
+			l.Fatal("failed to set up sentry", map[string]any{"err": err})
+		}
 
-	inMemoryStorage := inmemorystorage.NewInMemoryStorage(cnf.MaxBanners, l)
+		l = l.WithSentry(sentryHook)
+	}
 
-	bannerRepository, err := repository.NewBannerRepository(inMemoryStorage)
+	shutdownTracing, err := observe.InitTracing(ctx, cnf.AppName, cnf.AppVersion, cnf.TracingExporter, cnf.TracingFile)
 	if err != nil {
-		l.Fatal("failed to create banner repository", map[string]any{"err": err})
+		l.Fatal("failed to set up tracing", map[string]any{"err": err, "exporter": cnf.TracingExporter})
 	}
 
-	statisticsService := service.NewStatisticsService(
-		bannerRepository,
-		server,
-		l,
-	)
+	health := httpserver.NewHealth()
+	server := httpserver.InitFiberServer(cnf.AppName, sentryHook, health)
 
-	statisticsWorker := worker.NewStatisticsWorker(
-		bannerRepository,
-		statisticsService,
-		l,
+	var postgresRepository *repository.BannerRepositoryPostgres
+	if cnf.PostgresDSN != "" {
+		postgresRepository, err = repository.NewPostgresBannerRepository(ctx, cnf.PostgresDSN)
+		if err != nil {
+			l.Fatal("failed to create postgres banner repository", map[string]any{"err": err})
+		}
+		defer postgresRepository.Close()
+
+		health.AddReadinessCheck("postgres", postgresRepository.Ping)
+	}
+
+	var (
+		tenants     []*service.Tenant
+		stopTenants []func(context.Context)
 	)
+	for _, id := range tenantIDs(cnf) {
+		tenant, stopTenant := newTenant(ctx, cnf, id, postgresRepository, server, health, l)
 
-	go statisticsWorker.Run(ctx)
+		tenants = append(tenants, tenant)
+		stopTenants = append(stopTenants, stopTenant)
+	}
+
+	tenantService, err := service.NewTenantService(tenants, cnf.TenantAPIKeys)
+	if err != nil {
+		l.Fatal("failed to configure tenants", map[string]any{"err": err})
+	}
+
+	var countries http.CountryResolver
+	if cnf.GeoIPDatabase != "" {
+		geoDB, err := geoip.Open(cnf.GeoIPDatabase)
+		if err != nil {
+			l.Fatal("failed to open geoip database", map[string]any{"err": err, "path": cnf.GeoIPDatabase})
+		}
+		defer geoDB.Close()
+
+		countries = geoDB
+	}
 
 	http.NewRouter(
-		bannerRepository,
-		statisticsService,
+		tenantService,
+		countries,
+		cnf.RedirectAllowedDomains,
 		server,
 		l,
 	)
@@ -70,12 +122,38 @@ func main() {
 		signal.Stop(sigCh)
 		close(sigCh)
 
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// Every step below shares the deadline; a step that runs out of
+		// time is cut short and the next ones still run
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cnf.ShutdownTimeout)
 		defer shutdownCancel()
 
-		_ = server.ShutdownWithContext(shutdownCtx)
-		_ = l.Stop()
+		// Fail readiness first, so that load balancers stop sending clicks
+		// before the listener closes
+		health.ShutDown()
+		select {
+		case <-time.After(cnf.ShutdownDelay):
+		case <-shutdownCtx.Done():
+		}
+
+		// Stop taking clicks and wait for the requests in flight
+		if err := server.ShutdownWithContext(shutdownCtx); err != nil {
+			l.Error(fmt.Errorf("failed to drain requests: %w", err))
+		}
+
+		// Stop the periodic flushes, then store the clicks counted since the
+		// last one before the history is closed
 		cancel()
+		for _, stopTenant := range stopTenants {
+			stopTenant(shutdownCtx)
+		}
+
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			l.Error(err)
+		}
+		if err := shutdownCtx.Err(); err != nil {
+			l.Error(fmt.Errorf("shutdown did not complete within %s: %w", cnf.ShutdownTimeout, err))
+		}
+		_ = l.Stop()
 	}()
 
 	select {
@@ -85,3 +163,239 @@ func main() {
 		fmt.Println("context cancelled")
 	}
 }
+
+// tenantIDs returns the configured tenants, or the default tenant alone.
+func tenantIDs(cnf *config.Config) []string {
+	if len(cnf.TenantMaxBanners) == 0 {
+		return []string{model.DefaultTenant}
+	}
+
+	ids := make([]string, 0, len(cnf.TenantMaxBanners))
+	for id := range cnf.TenantMaxBanners {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// newTenant creates the click counters, catalog and statistics history of the
+// tenant and starts its workers. The default tenant keeps the paths of a
+// single-tenant deployment; the others store their catalog and snapshot logs
+// next to them, under their ID. The returned function stores the clicks
+// counted since the last flush and closes the history; call it once the
+// tenant takes no more clicks and ctx is cancelled.
+func newTenant(
+	ctx context.Context,
+	cnf *config.Config,
+	id string,
+	postgresRepository *repository.BannerRepositoryPostgres,
+	server *fiber.App,
+	health *httpserver.Health,
+	l *observe.Logger,
+) (*service.Tenant, func(context.Context)) {
+	if err := model.ValidateTenantID(id); err != nil {
+		l.Fatal("invalid tenant", map[string]any{"err": err, "tenant": id})
+	}
+
+	var closers []func()
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+
+	bannerRepository := newClickCounter(cnf, cnf.TenantMaxBanners[id], l)
+
+	snapshotLogDir := cnf.SnapshotLogDir
+	if snapshotLogDir != "" && id != model.DefaultTenant {
+		snapshotLogDir = filepath.Join(snapshotLogDir, "tenants", id)
+	}
+
+	snapshotRepository, closeSnapshotLog := newSnapshotRepository(ctx, cnf, snapshotLogDir, false, health, l)
+	closers = append(closers, closeSnapshotLog)
+
+	rollups := make(map[model.Granularity]repository.SnapshotStore)
+	for _, granularity := range []model.Granularity{model.GranularityHour, model.GranularityDay} {
+		var dir string
+		if snapshotLogDir != "" {
+			dir = filepath.Join(snapshotLogDir, string(granularity))
+		}
+
+		rollup, closeRollupLog := newSnapshotRepository(ctx, cnf, dir, true, health, l)
+		closers = append(closers, closeRollupLog)
+
+		rollups[granularity] = rollup
+	}
+
+	catalogFile := cnf.CatalogFile
+	if catalogFile != "" && id != model.DefaultTenant {
+		ext := filepath.Ext(catalogFile)
+		catalogFile = strings.TrimSuffix(catalogFile, ext) + "." + id + ext
+	}
+
+	catalogRepository, err := repository.NewCatalogRepository(catalogFile)
+	if err != nil {
+		l.Fatal("failed to create banner catalog repository", map[string]any{"err": err, "tenant": id})
+	}
+
+	catalogService, err := service.NewCatalogService(ctx, catalogRepository, bannerRepository.ValidateID, l)
+	if err != nil {
+		l.Fatal("failed to load banner catalog", map[string]any{"err": err, "tenant": id})
+	}
+
+	statisticsService := service.NewStatisticsService(
+		bannerRepository,
+		snapshotRepository,
+		rollups,
+		catalogService,
+		server,
+		l,
+	)
+	metrics.WatchSnapshots(id, statisticsService.SnapshotSizes)
+
+	if err := statisticsService.RestoreRollups(ctx); err != nil {
+		l.Fatal("failed to restore statistics rollups", map[string]any{"err": err, "tenant": id})
+	}
+
+	statisticsWorker := worker.NewStatisticsWorker(
+		bannerRepository,
+		statisticsService,
+		map[model.Granularity]service.Retention{
+			model.GranularityMinute: {
+				MaxAge:       cnf.RetentionMaxAge,
+				MaxSnapshots: cnf.RetentionMaxSnapshots,
+			},
+			model.GranularityHour: {MaxAge: cnf.RetentionHourMaxAge},
+			model.GranularityDay:  {MaxAge: cnf.RetentionDayMaxAge},
+		},
+		l,
+	)
+
+	var postgresWorker *worker.PostgresWorker
+	if postgresRepository != nil {
+		tenantRepository := postgresRepository.ForTenant(id)
+
+		var since time.Time
+		if cnf.RetentionMaxAge > 0 {
+			since = time.Now().Add(-cnf.RetentionMaxAge)
+		}
+
+		history, err := tenantRepository.GetSnapshots(ctx, since, time.Now())
+		if err != nil {
+			l.Fatal("failed to restore statistics from postgres", map[string]any{"err": err, "tenant": id})
+		}
+		if err := statisticsService.RestoreSnapshots(ctx, history); err != nil {
+			l.Fatal("failed to restore statistics from postgres", map[string]any{"err": err, "tenant": id})
+		}
+
+		l.Info("statistics restored from postgres", map[string]any{"snapshots": len(history), "tenant": id})
+
+		postgresWorker = worker.NewPostgresWorker(
+			tenantRepository,
+			statisticsService,
+			l,
+		)
+
+		postgresWorker.Run(ctx)
+	}
+
+	// Start flushing only once the history is restored: a flush merges into
+	// the same buckets as the restore
+	statisticsWorker.Run(ctx)
+	health.AddLivenessCheck("statistics_worker:"+id, statisticsWorker.Check)
+
+	stop := func(ctx context.Context) {
+		statisticsWorker.Flush(ctx)
+		if postgresWorker != nil {
+			postgresWorker.Flush(ctx)
+		}
+
+		closeAll()
+	}
+
+	return &service.Tenant{
+		ID:         id,
+		Banners:    bannerRepository,
+		Catalog:    catalogService,
+		Statistics: statisticsService,
+	}, stop
+}
+
+// newClickCounter returns the click counters of the configured banner ID mode.
+// A positive maxBanners overrides the configured capacity of the mode.
+func newClickCounter(cnf *config.Config, maxBanners int, l *observe.Logger) repository.ClickCounter {
+	switch cnf.BannerIDMode {
+	case "int":
+		if maxBanners <= 0 {
+			maxBanners = cnf.MaxBanners
+		}
+
+		inMemoryStorage := inmemorystorage.NewInMemoryStorage(maxBanners, cnf.DimensionMaxValues, l)
+
+		bannerRepository, err := repository.NewBannerRepository(inMemoryStorage)
+		if err != nil {
+			l.Fatal("failed to create banner repository", map[string]any{"err": err})
+		}
+
+		return bannerRepository
+	case "string":
+		if maxBanners <= 0 {
+			maxBanners = cnf.SparseMaxBanners
+		}
+
+		sparseStorage := inmemorystorage.NewSparseStorage(maxBanners, cnf.BannerIdleTimeout, cnf.DimensionMaxValues, l)
+
+		return repository.NewSparseBannerRepository(sparseStorage)
+	default:
+		l.Fatal("unknown banner ID mode", map[string]any{"mode": cnf.BannerIDMode})
+		return nil
+	}
+}
+
+// newSnapshotRepository returns an in-memory snapshot store. When dir is set,
+// the store is backed by a snapshot log in dir and replayed from it, and the
+// log must stay writable for the service to be ready. A rollup store logs its
+// buckets only once they are closed.
+func newSnapshotRepository(
+	ctx context.Context,
+	cnf *config.Config,
+	dir string,
+	rollup bool,
+	health *httpserver.Health,
+	l *observe.Logger,
+) (repository.SnapshotStore, func()) {
+	store := repository.NewInMemorySnapshotRepository()
+	if dir == "" {
+		return store, func() {}
+	}
+
+	snapshotLog, err := snapshotlog.Open(dir, snapshotlog.Options{
+		SegmentSize:  cnf.SnapshotLogSegmentSize,
+		SyncPolicy:   snapshotlog.SyncPolicy(cnf.SnapshotLogSync),
+		SyncInterval: cnf.SnapshotLogSyncInterval,
+	})
+	if err != nil {
+		l.Fatal("failed to open snapshot log", map[string]any{"err": err, "dir": dir})
+	}
+
+	if offset := snapshotLog.TruncatedAt(); offset > 0 {
+		l.Warning("corrupt snapshot log tail truncated", map[string]any{"offset": offset, "dir": dir})
+	}
+
+	newDurable := repository.NewDurableSnapshotRepository
+	if rollup {
+		newDurable = repository.NewDurableRollupRepository
+	}
+
+	durable, err := newDurable(ctx, snapshotLog, store)
+	if err != nil {
+		l.Fatal("failed to replay snapshot log", map[string]any{"err": err, "dir": dir})
+	}
+
+	health.AddReadinessCheck("snapshot_log:"+dir, func(context.Context) error {
+		return snapshotLog.Writable()
+	})
+
+	return durable, func() { _ = snapshotLog.Close() }
+}
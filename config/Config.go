@@ -2,15 +2,51 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
 type Config struct {
-	AppName    string `envconfig:"APP_NAME" default:"rsclabs-pavel"`
-	AppVersion string `envconfig:"APP_VERSION" default:"1.0.0"`
-	MaxBanners int    `envconfig:"MAX_BANNERS" default:"100"`
-	Port       string `envconfig:"PORT" default:"8080"`
+	AppName     string `envconfig:"APP_NAME" default:"rsclabs-pavel"`
+	AppVersion  string `envconfig:"APP_VERSION" default:"1.0.0"`
+	MaxBanners  int    `envconfig:"MAX_BANNERS" default:"100"`
+	Port        string `envconfig:"PORT" default:"8080"`
+	PostgresDSN string `envconfig:"POSTGRES_DSN"`
+
+	BannerIDMode      string        `envconfig:"BANNER_ID_MODE" default:"int"`
+	SparseMaxBanners  int           `envconfig:"SPARSE_MAX_BANNERS" default:"1000000"`
+	BannerIdleTimeout time.Duration `envconfig:"BANNER_IDLE_TIMEOUT" default:"1h"`
+
+	TenantMaxBanners map[string]int    `envconfig:"TENANT_MAX_BANNERS"`
+	TenantAPIKeys    map[string]string `envconfig:"TENANT_API_KEYS"`
+
+	CatalogFile            string   `envconfig:"CATALOG_FILE"`
+	RedirectAllowedDomains []string `envconfig:"REDIRECT_ALLOWED_DOMAINS"`
+
+	GeoIPDatabase      string `envconfig:"GEOIP_DATABASE"`
+	DimensionMaxValues int    `envconfig:"DIMENSION_MAX_VALUES" default:"1000"`
+
+	RetentionMaxAge       time.Duration `envconfig:"RETENTION_MAX_AGE" default:"720h"`
+	RetentionMaxSnapshots int           `envconfig:"RETENTION_MAX_SNAPSHOTS" default:"43200"`
+	RetentionHourMaxAge   time.Duration `envconfig:"RETENTION_HOUR_MAX_AGE" default:"2160h"`
+	RetentionDayMaxAge    time.Duration `envconfig:"RETENTION_DAY_MAX_AGE" default:"8760h"`
+
+	SnapshotLogDir          string        `envconfig:"SNAPSHOT_LOG_DIR"`
+	SnapshotLogSync         string        `envconfig:"SNAPSHOT_LOG_SYNC" default:"always"`
+	SnapshotLogSyncInterval time.Duration `envconfig:"SNAPSHOT_LOG_SYNC_INTERVAL" default:"1s"`
+	SnapshotLogSegmentSize  int64         `envconfig:"SNAPSHOT_LOG_SEGMENT_SIZE" default:"67108864"`
+
+	TracingExporter string `envconfig:"TRACING_EXPORTER" default:"none"`
+	TracingFile     string `envconfig:"TRACING_FILE"`
+
+	SentryDSN         string  `envconfig:"SENTRY_DSN"`
+	SentryEnvironment string  `envconfig:"SENTRY_ENVIRONMENT" default:"production"`
+	SentrySampleRate  float64 `envconfig:"SENTRY_SAMPLE_RATE" default:"1"`
+	SentryDebug       bool    `envconfig:"SENTRY_DEBUG"`
+
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"30s"`
+	ShutdownDelay   time.Duration `envconfig:"SHUTDOWN_DELAY" default:"0s"`
 }
 
 func NewConfig() *Config {
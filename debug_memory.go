@@ -43,7 +43,7 @@ func TestDebugMemoryLeak(t *testing.T) {
 func testRepositoryMemory(t *testing.T) {
 	l := observe.NewZapLogger("test")
 
-	inMemoryStorage := inmemorystorage.NewInMemoryStorage(10, l)
+	inMemoryStorage := inmemorystorage.NewInMemoryStorage(10, 100, l)
 	repo, err := repository.NewBannerRepository(inMemoryStorage)
 	if err != nil {
 		t.Fatalf("Failed to create repository: %v", err)
@@ -54,7 +54,7 @@ func testRepositoryMemory(t *testing.T) {
 	runtime.ReadMemStats(&m1)
 
 	for i := 0; i < 100; i++ {
-		err := repo.RegisterClick(i % 10)
+		err := repo.RegisterClick(model.BannerIDFromInt(i%10 + 1))
 		if err != nil {
 			t.Logf("Repository error: %v", err)
 		}
@@ -74,15 +74,15 @@ func testRepositoryMemory(t *testing.T) {
 func testServiceMemory(t *testing.T) {
 	l := observe.NewZapLogger("test")
 	cnf := config.NewConfig()
-	server := httpserver.InitFiberServer(cnf.AppName)
+	server := httpserver.InitFiberServer(cnf.AppName, nil, nil)
 
-	inMemoryStorage := inmemorystorage.NewInMemoryStorage(10, l)
+	inMemoryStorage := inmemorystorage.NewInMemoryStorage(10, 100, l)
 	repo, err := repository.NewBannerRepository(inMemoryStorage)
 	if err != nil {
 		t.Fatalf("Failed to create repository: %v", err)
 	}
 
-	statsService := service.NewStatisticsService(repo, server, l)
+	statsService := service.NewStatisticsService(repo, repository.NewInMemorySnapshotRepository(), nil, nil, server, l)
 
 	var m1, m2 runtime.MemStats
 	runtime.GC()
@@ -90,7 +90,7 @@ func testServiceMemory(t *testing.T) {
 
 	for i := 0; i < 100; i++ {
 		req := model.StatisticsRequest{
-			BannerID: 1,
+			BannerID: "1",
 			From:     "2024-01-01",
 			To:       "2024-01-31",
 		}
@@ -177,11 +177,11 @@ func setupSimpleApp() *fiber.App {
 	app := fiber.New()
 	l := observe.NewZapLogger("test")
 
-	inMemoryStorage := inmemorystorage.NewInMemoryStorage(10, l)
+	inMemoryStorage := inmemorystorage.NewInMemoryStorage(10, 100, l)
 	repo, _ := repository.NewBannerRepository(inMemoryStorage)
 
 	app.Post("/click/:bannerID", func(c *fiber.Ctx) error {
-		repo.RegisterClick(0)
+		repo.RegisterClick("1")
 		return c.SendString("ok")
 	})
 
@@ -192,9 +192,9 @@ func setupTestApp() *fiber.App {
 	app := fiber.New()
 	l := observe.NewZapLogger("test-app")
 	cnf := config.NewConfig()
-	server := httpserver.InitFiberServer(cnf.AppName)
+	server := httpserver.InitFiberServer(cnf.AppName, nil, nil)
 
-	inMemoryStorage := inmemorystorage.NewInMemoryStorage(cnf.MaxBanners, l)
+	inMemoryStorage := inmemorystorage.NewInMemoryStorage(cnf.MaxBanners, cnf.DimensionMaxValues, l)
 
 	bannerRepository, err := repository.NewBannerRepository(inMemoryStorage)
 	if err != nil {
@@ -203,13 +203,26 @@ func setupTestApp() *fiber.App {
 
 	statisticsService := service.NewStatisticsService(
 		bannerRepository,
+		repository.NewInMemorySnapshotRepository(),
+		nil,
+		nil,
 		server,
 		l,
 	)
 
+	tenants, err := service.NewTenantService([]*service.Tenant{{
+		ID:         model.DefaultTenant,
+		Banners:    bannerRepository,
+		Statistics: statisticsService,
+	}}, nil)
+	if err != nil {
+		l.Fatal("failed to configure tenants", map[string]any{"err": err})
+	}
+
 	http.NewRouter(
-		bannerRepository,
-		statisticsService,
+		tenants,
+		nil,
+		nil,
 		server,
 		l,
 	)
@@ -221,7 +234,7 @@ func TestRepositoryImplementation(t *testing.T) {
 	fmt.Println("=== TESTING REPOSITORY DETAILS ===")
 
 	l := observe.NewZapLogger("test")
-	inMemoryStorage := inmemorystorage.NewInMemoryStorage(5, l)
+	inMemoryStorage := inmemorystorage.NewInMemoryStorage(5, 100, l)
 	repo, err := repository.NewBannerRepository(inMemoryStorage)
 	if err != nil {
 		t.Fatalf("Failed to create repository: %v", err)
@@ -233,8 +246,8 @@ func TestRepositoryImplementation(t *testing.T) {
 
 	// Test multiple clicks
 	for i := 0; i < 20; i++ {
-		err := repo.RegisterClick(i % 5)
-		fmt.Printf("Click %d (banner %d): error=%v\n", i, i%5, err)
+		err := repo.RegisterClick(model.BannerIDFromInt(i%5 + 1))
+		fmt.Printf("Click %d (banner %d): error=%v\n", i, i%5+1, err)
 
 		if i%5 == 0 {
 			snapshot := repo.GetCountSnapshot()
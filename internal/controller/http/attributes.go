@@ -0,0 +1,121 @@
+package http
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"rsclabs-test/internal/model"
+)
+
+// maxAttributeLength caps the length of a recorded attribute value.
+const maxAttributeLength = 128
+
+// CountryResolver resolves client addresses to ISO country codes.
+type CountryResolver interface {
+	Country(ip net.IP) (string, error)
+}
+
+// clickAttributes collects the optional click attributes: the referrer host
+// from the ref query parameter or the Referer header, the user agent class,
+// the placement from the placement or slot query parameter and the country of
+// the client address.
+func (r *routes) clickAttributes(c *fiber.Ctx) model.ClickAttributes {
+	referrer := c.Query("ref")
+	if referrer == "" {
+		referrer = c.Get(fiber.HeaderReferer)
+	}
+
+	placement := c.Query("placement")
+	if placement == "" {
+		placement = c.Query("slot")
+	}
+
+	return model.ClickAttributes{
+		Referrer:  truncate(referrerHost(referrer)),
+		UserAgent: userAgentClass(c.Get(fiber.HeaderUserAgent)),
+		Placement: truncate(placement),
+		Country:   r.country(c.IP()),
+	}
+}
+
+func (r *routes) country(addr string) string {
+	if r.countries == nil {
+		return ""
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+
+	country, err := r.countries.Country(ip)
+	if err != nil {
+		r.l.Debug("failed to resolve client country", map[string]any{"ip": addr, "err": err})
+		return ""
+	}
+
+	return country
+}
+
+// referrerHost reduces a referrer URL to its host name, so that the dimension
+// is not exploded by paths and query strings.
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+
+	if !strings.Contains(referrer, "://") {
+		referrer = "//" + referrer
+	}
+
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// userAgentClass classifies a User-Agent header as bot, tablet, mobile,
+// desktop or other.
+func userAgentClass(userAgent string) string {
+	if userAgent == "" {
+		return ""
+	}
+
+	ua := strings.ToLower(userAgent)
+	containsAny := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(ua, w) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case containsAny("bot", "crawl", "spider", "slurp", "curl", "wget"):
+		return "bot"
+	case containsAny("ipad", "tablet") || (strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		return "tablet"
+	case containsAny("mobi", "iphone", "android"):
+		return "mobile"
+	case containsAny("windows", "macintosh", "x11", "linux", "cros"):
+		return "desktop"
+	default:
+		return "other"
+	}
+}
+
+// truncate caps the value and copies it out of the request buffer, which
+// fiber reuses once the handler returns.
+func truncate(value string) string {
+	if len(value) > maxAttributeLength {
+		value = strings.ToValidUTF8(value[:maxAttributeLength], "")
+	}
+
+	return strings.Clone(value)
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/service"
+)
+
+// bannerBody is the request body of the catalog endpoints. Fields left out of
+// an update are kept.
+type bannerBody struct {
+	ID         model.BannerID `json:"id"`
+	Name       *string        `json:"name"`
+	LandingURL *string        `json:"landingURL"`
+	Status     *string        `json:"status"`
+	Campaign   *string        `json:"campaign"`
+}
+
+func (r *routes) handleListBanners(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"banners": r.catalog.List()})
+}
+
+func (r *routes) handleGetBanner(c *fiber.Ctx) error {
+	bid, err := r.bannerID(c)
+	if err != nil {
+		return respond(c, errInvalidBannerID)
+	}
+
+	banner, err := r.catalog.Get(bid)
+	if err != nil {
+		return r.fail(c, err, "Failed to read banner catalog")
+	}
+
+	return c.JSON(banner)
+}
+
+func (r *routes) handleCreateBanner(c *fiber.Ctx) error {
+	var body bannerBody
+	if err := c.BodyParser(&body); err != nil {
+		return respond(c, errInvalidJSON)
+	}
+
+	update, err := body.update()
+	if err != nil {
+		return respond(c, errInvalidBannerStatus)
+	}
+
+	banner := model.CatalogBanner{ID: body.ID}
+	if update.Name != nil {
+		banner.Name = *update.Name
+	}
+	if update.LandingURL != nil {
+		banner.LandingURL = *update.LandingURL
+	}
+	if update.Status != nil {
+		banner.Status = *update.Status
+	}
+	if update.Campaign != nil {
+		banner.Campaign = *update.Campaign
+	}
+
+	created, err := r.catalog.Create(c.UserContext(), banner)
+	if err != nil {
+		return r.fail(c, err, "Failed to update banner catalog")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+func (r *routes) handleUpdateBanner(c *fiber.Ctx) error {
+	bid, err := r.bannerID(c)
+	if err != nil {
+		return respond(c, errInvalidBannerID)
+	}
+
+	var body bannerBody
+	if err := c.BodyParser(&body); err != nil {
+		return respond(c, errInvalidJSON)
+	}
+
+	update, err := body.update()
+	if err != nil {
+		return respond(c, errInvalidBannerStatus)
+	}
+
+	banner, err := r.catalog.Update(c.UserContext(), bid, update)
+	if err != nil {
+		return r.fail(c, err, "Failed to update banner catalog")
+	}
+
+	return c.JSON(banner)
+}
+
+// handleDeleteBanner retires the banner: it stops counting, but stays in the
+// catalog so that its statistics remain available.
+func (r *routes) handleDeleteBanner(c *fiber.Ctx) error {
+	bid, err := r.bannerID(c)
+	if err != nil {
+		return respond(c, errInvalidBannerID)
+	}
+
+	banner, err := r.catalog.Retire(c.UserContext(), bid)
+	if err != nil {
+		return r.fail(c, err, "Failed to update banner catalog")
+	}
+
+	return c.JSON(banner)
+}
+
+func (b bannerBody) update() (service.BannerUpdate, error) {
+	update := service.BannerUpdate{
+		Name:       b.Name,
+		LandingURL: b.LandingURL,
+		Campaign:   b.Campaign,
+	}
+
+	if b.Status != nil {
+		status, err := model.ParseBannerStatus(*b.Status)
+		if err != nil {
+			return service.BannerUpdate{}, err
+		}
+		update.Status = &status
+	}
+
+	return update, nil
+}
@@ -0,0 +1,147 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+
+	"rsclabs-test/internal/repository"
+	"rsclabs-test/internal/service"
+)
+
+// apiError is the answer to a failed request: an HTTP status, a code clients
+// can branch on, which never changes once published, and a message for
+// humans, which may.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{status: status, code: code, message: message}
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+var (
+	errInvalidJSON         = newAPIError(fiber.StatusBadRequest, "invalid_json", "Invalid JSON body")
+	errInvalidBannerID     = newAPIError(fiber.StatusBadRequest, "invalid_banner_id", "Invalid banner ID format")
+	errBannerIDRequired    = newAPIError(fiber.StatusBadRequest, "banner_id_required", "Banner ID is required")
+	errInvalidBannerStatus = newAPIError(fiber.StatusBadRequest, "invalid_banner_status", "Invalid banner status")
+	errInvalidTime         = newAPIError(fiber.StatusBadRequest, "invalid_time", "Invalid time")
+	errInvalidTimeZone     = newAPIError(fiber.StatusBadRequest, "invalid_tz", "Invalid tz")
+	errInvalidTimeRange    = newAPIError(fiber.StatusUnprocessableEntity, "invalid_time_range", "Invalid time range")
+	errRangeTooLong        = newAPIError(fiber.StatusUnprocessableEntity, "range_too_long", "Range is too long to fill its gaps, use a coarser granularity")
+	errBannerNotFound      = newAPIError(fiber.StatusNotFound, "banner_not_found", "Banner not found")
+	errBannerInactive      = newAPIError(fiber.StatusConflict, "banner_inactive", "Banner is not active")
+	errBannerExists        = newAPIError(fiber.StatusConflict, "banner_exists", "Banner already exists")
+	errCatalogFull         = newAPIError(fiber.StatusConflict, "catalog_full", "Banner catalog is full")
+	errClickOutside        = newAPIError(fiber.StatusUnprocessableEntity, "click_outside_window", "Timestamp is outside the accepted window")
+	errTooManyBanners      = newAPIError(fiber.StatusServiceUnavailable, "too_many_banners", "Too many active banners")
+	errUnavailable         = newAPIError(fiber.StatusServiceUnavailable, "unavailable", "Statistics are temporarily unavailable")
+	errInvalidAPIKey       = newAPIError(fiber.StatusUnauthorized, "invalid_api_key", "Invalid API key")
+	errAPIKeyRequired      = newAPIError(fiber.StatusUnauthorized, "api_key_required", "API key is required")
+	errTenantMismatch      = newAPIError(fiber.StatusForbidden, "tenant_mismatch", "API key does not belong to the tenant")
+	errTenantNotFound      = newAPIError(fiber.StatusNotFound, "tenant_not_found", "Tenant not found")
+	errUnsupportedWindow   = newAPIError(fiber.StatusBadRequest, "invalid_window", "Invalid window")
+)
+
+// errorFor maps an error of the service or repository layers to its answer.
+// It returns nil for an error no request is expected to cause.
+func errorFor(err error) *apiError {
+	var apiErr *apiError
+
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, repository.ErrInvalidBannerID):
+		return errInvalidBannerID
+	case errors.Is(err, service.ErrBannerNotFound):
+		return errBannerNotFound
+	case errors.Is(err, service.ErrBannerInactive):
+		return errBannerInactive
+	case errors.Is(err, service.ErrBannerExists):
+		return errBannerExists
+	case errors.Is(err, service.ErrCatalogFull):
+		return errCatalogFull
+	case errors.Is(err, service.ErrInvalidBanner):
+		return newAPIError(fiber.StatusUnprocessableEntity, "invalid_banner",
+			"Invalid banner: "+strings.TrimPrefix(err.Error(), service.ErrInvalidBanner.Error()+": "))
+	case errors.Is(err, service.ErrInvalidTimeZone):
+		return errInvalidTimeZone
+	case errors.Is(err, service.ErrInvalidTime):
+		return errInvalidTime
+	case errors.Is(err, service.ErrInvalidTimeRange):
+		return errInvalidTimeRange
+	case errors.Is(err, service.ErrTooManyBuckets):
+		return errRangeTooLong
+	case errors.Is(err, service.ErrUnsupportedWindow):
+		return errUnsupportedWindow
+	case errors.Is(err, service.ErrInvalidAPIKey):
+		return errInvalidAPIKey
+	case errors.Is(err, service.ErrTenantNotFound):
+		return errTenantNotFound
+	case errors.Is(err, repository.ErrClickOutsideWindow):
+		return errClickOutside
+	case errors.Is(err, repository.ErrTooManyBanners):
+		return errTooManyBanners
+	case errors.Is(err, repository.ErrStoreUnavailable), errors.Is(err, context.DeadlineExceeded):
+		return errUnavailable
+	default:
+		return nil
+	}
+}
+
+// errorResponse is the body of every error answer.
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// respond answers the request with the error.
+func respond(c *fiber.Ctx, e *apiError) error {
+	return c.Status(e.status).JSON(errorResponse{
+		Error: errorBody{
+			Code:      e.code,
+			Message:   e.message,
+			RequestID: requestID(c),
+		},
+	})
+}
+
+// fail answers the request with the error. An unexpected one is logged and
+// answered as an internal error with the message.
+func (r *routes) fail(c *fiber.Ctx, err error, message string) error {
+	if e := errorFor(err); e != nil {
+		return respond(c, e)
+	}
+
+	r.l.WithContext(c.UserContext()).Error(fmt.Errorf("%s: %w", strings.ToLower(message), err), map[string]any{
+		"tenant":     r.tenant,
+		"request_id": requestID(c),
+	})
+
+	return respond(c, newAPIError(fiber.StatusInternalServerError, "internal", message))
+}
+
+// requestID returns the ID the requestid middleware gave the request, or the
+// one the client sent without the middleware.
+func requestID(c *fiber.Ctx) string {
+	if id, ok := c.Locals(requestid.ConfigDefault.ContextKey).(string); ok {
+		return id
+	}
+
+	return c.Get(fiber.HeaderXRequestID)
+}
@@ -1,50 +1,52 @@
 package http
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"rsclabs-test/internal/model"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"rsclabs-test/internal/metrics"
 	"rsclabs-test/internal/repository"
 	"rsclabs-test/internal/service"
 	"rsclabs-test/pkg/observe"
 )
 
+// routes serves the endpoints of one tenant.
 type routes struct {
-	banners    *repository.BannerRepositoryInMemory
+	tenant     string
+	banners    repository.ClickCounter
 	statistics *service.StatisticsService
+	catalog    *service.CatalogService
+	countries  CountryResolver
+	redirects  redirectPolicy
 	l          *observe.Logger
 }
 
-type BannerStorage interface {
-	RegisterClick(bannerID int) bool
-	GetCountSnapshot() model.Snapshot
-}
-
 func (r *routes) handleClick(c *fiber.Ctx) error {
-	bannerID := c.Params("bannerID")
-
-	bid, err := strconv.Atoi(bannerID)
+	bid, err := r.bannerID(c)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid banner ID format",
-		})
+		r.countClick(bid, errInvalidBannerID)
+		return respond(c, errInvalidBannerID)
 	}
 
-	if bid < 1 || bid > r.banners.MaxBanners {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": fmt.Sprintf("Banner ID must be between 1 and %d", r.banners.MaxBanners),
-		})
+	if _, err := r.activeBanner(bid); err != nil {
+		r.countClick(bid, err)
+		return r.fail(c, err, "Failed to look up banner")
 	}
 
-	err = r.banners.RegisterClick(bid - 1)
+	err = r.banners.RegisterClickWithAttributes(bid, r.clickAttributes(c))
+	r.countClick(bid, err)
 	if err != nil {
-		r.l.Error(fmt.Errorf("failed to register click: %w", err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to register click",
-		})
+		return r.fail(c, err, "Failed to register click")
 	}
 
 	return c.JSON(fiber.Map{
@@ -53,42 +55,227 @@ func (r *routes) handleClick(c *fiber.Ctx) error {
 	})
 }
 
-func (r *routes) handleStatsRequest(c *fiber.Ctx) error {
-	bannerID := c.Params("bannerID")
-	if bannerID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Banner ID is required"})
+func (r *routes) handleImpression(c *fiber.Ctx) error {
+	bid, err := r.bannerID(c)
+	if err != nil {
+		return respond(c, errInvalidBannerID)
 	}
 
-	bid, err := strconv.Atoi(bannerID)
+	if _, err := r.activeBanner(bid); err != nil {
+		return r.fail(c, err, "Failed to look up banner")
+	}
+
+	if err := r.banners.RegisterImpression(bid); err != nil {
+		return r.fail(c, err, "Failed to register impression")
+	}
+
+	return c.JSON(fiber.Map{
+		"bannerID": bid,
+		"success":  true,
+	})
+}
+
+// handleRedirect counts a click and redirects the client to the banner's
+// landing URL, passing the utm_* query parameters through.
+func (r *routes) handleRedirect(c *fiber.Ctx) error {
+	bid, err := r.bannerID(c)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid banner ID format"})
+		r.countClick(bid, errInvalidBannerID)
+		return respond(c, errInvalidBannerID)
 	}
 
-	var requestBody struct {
-		From string `json:"from"`
-		To   string `json:"to"`
+	banner, err := r.activeBanner(bid)
+	if err != nil {
+		r.countClick(bid, err)
+		return r.fail(c, err, "Failed to look up banner")
 	}
 
-	if err := c.BodyParser(&requestBody); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON body"})
+	if banner.LandingURL == "" {
+		e := newAPIError(fiber.StatusNotFound, "no_landing_url", "Banner has no landing URL")
+		r.countClick(bid, e)
+		return respond(c, e)
+	}
+
+	query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+	if err != nil {
+		e := newAPIError(fiber.StatusBadRequest, "invalid_query", "Invalid query string")
+		r.countClick(bid, e)
+		return respond(c, e)
+	}
+
+	target, err := r.redirects.redirectTarget(banner.LandingURL, query)
+	if err != nil {
+		r.l.WithContext(c.UserContext()).Error(fmt.Errorf("failed to redirect banner %s: %w", bid, err))
+		e := newAPIError(fiber.StatusForbidden, "landing_url_not_allowed", "Landing URL is not allowed")
+		r.countClick(bid, e)
+		return respond(c, e)
+	}
+
+	// A failed count must not break the user's navigation
+	err = r.banners.RegisterClickWithAttributes(bid, r.clickAttributes(c))
+	r.countClick(bid, err)
+	if err != nil {
+		r.l.WithContext(c.UserContext()).Error(fmt.Errorf("failed to register click: %w", err))
 	}
 
-	request := model.StatisticsRequest{
-		BannerID: bid,
-		From:     requestBody.From,
-		To:       requestBody.To,
+	return c.Redirect(target, fiber.StatusFound)
+}
+
+// maxBatchSize caps the number of entries in one batch request.
+const maxBatchSize = 10000
+
+type batchEntry struct {
+	BannerID model.BannerID `json:"bannerID"`
+	TS       time.Time      `json:"ts"`
+	Count    *int           `json:"count"`
+}
+
+type batchResult struct {
+	BannerID model.BannerID `json:"bannerID"`
+	Success  bool           `json:"success"`
+	Code     string         `json:"code,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// fail records the error of the entry, as an error answer would report it.
+func (b *batchResult) fail(err error, message string) {
+	e := errorFor(err)
+	if e == nil {
+		e = newAPIError(fiber.StatusInternalServerError, "internal", message)
 	}
 
-	stats, err := r.statistics.GetStatistics(c.Context(), request)
+	b.Code, b.Error = e.code, e.message
+}
+
+// handleBatchClick registers a batch of buffered clicks. The body is a JSON
+// array or, with an NDJSON content type, one entry per line. Every entry is
+// validated on its own and the valid ones are applied in one call; the
+// response reports a result per entry, in request order.
+func (r *routes) handleBatchClick(c *fiber.Ctx) error {
+	entries, err := parseBatch(c)
 	if err != nil {
-		if strings.Contains(err.Error(), "no statistics data available") {
-			return c.JSON(fiber.Map{
-				"stats": []interface{}{}, // Empty array
-			})
+		return respond(c, newAPIError(fiber.StatusBadRequest, "invalid_batch", "Invalid batch body"))
+	}
+
+	if len(entries) == 0 {
+		return respond(c, newAPIError(fiber.StatusBadRequest, "empty_batch", "Batch is empty"))
+	}
+
+	if len(entries) > maxBatchSize {
+		return respond(c, newAPIError(fiber.StatusRequestEntityTooLarge, "batch_too_large",
+			fmt.Sprintf("Batch must not exceed %d entries", maxBatchSize)))
+	}
+
+	results := make([]batchResult, len(entries))
+	clicks := make([]model.Click, 0, len(entries))
+	positions := make([]int, 0, len(entries))
+
+	for i, entry := range entries {
+		results[i].BannerID = entry.BannerID
+
+		if _, err := r.activeBanner(entry.BannerID); err != nil {
+			results[i].fail(err, "Failed to look up banner")
+			continue
+		}
+
+		count := 1
+		if entry.Count != nil {
+			count = *entry.Count
+		}
+		if count < 1 {
+			results[i].Code, results[i].Error = "invalid_count", "Count must be positive"
+			continue
+		}
+
+		clicks = append(clicks, model.Click{
+			BannerID:  entry.BannerID,
+			TimeStamp: entry.TS,
+			Count:     count,
+		})
+		positions = append(positions, i)
+	}
+
+	accepted := 0
+	for j, err := range r.banners.RegisterClicks(clicks) {
+		result := &results[positions[j]]
+
+		if err != nil {
+			if errorFor(err) == nil {
+				r.l.WithContext(c.UserContext()).Error(fmt.Errorf("failed to register click: %w", err))
+			}
+			result.fail(err, "Failed to register click")
+			continue
+		}
+
+		result.Success = true
+		accepted++
+	}
+
+	for i, result := range results {
+		n, code := 1, metrics.ClickAccepted
+		if count := entries[i].Count; count != nil && *count > 1 {
+			n = *count
+		}
+		if !result.Success {
+			code = result.Code
+		}
+
+		metrics.CountClicks(r.tenant, result.BannerID, code, n)
+	}
+
+	return c.JSON(fiber.Map{
+		"accepted": accepted,
+		"rejected": len(entries) - accepted,
+		"results":  results,
+	})
+}
+
+func parseBatch(c *fiber.Ctx) ([]batchEntry, error) {
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(c.Get(fiber.HeaderContentType), ";")[0]))
+
+	if contentType != "application/x-ndjson" && contentType != "application/ndjson" {
+		var entries []batchEntry
+		if err := json.Unmarshal(c.Body(), &entries); err != nil {
+			return nil, fmt.Errorf("failed to decode batch: %w", err)
+		}
+
+		return entries, nil
+	}
+
+	var entries []batchEntry
+	decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+	for {
+		var entry batchEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode batch line %d: %w", len(entries)+1, err)
 		}
 
-		r.l.Error(fmt.Errorf("failed to get statistics: %w", err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve statistics"})
+		entries = append(entries, entry)
+	}
+}
+
+func (r *routes) handleStatsRequest(c *fiber.Ctx) error {
+	bannerID := c.Params("bannerID")
+	if bannerID == "" {
+		return respond(c, errBannerIDRequired)
+	}
+
+	bid, err := r.bannerID(c)
+	if err != nil {
+		return respond(c, errInvalidBannerID)
+	}
+
+	request, apiErr := statisticsRequest(c)
+	if apiErr != nil {
+		return respond(c, apiErr)
+	}
+	request.BannerID = bid
+
+	stats, err := r.statistics.GetStatistics(c.UserContext(), request)
+	if err != nil {
+		return r.fail(c, err, "Failed to retrieve statistics")
 	}
 
 	if stats.IsEmpty() {
@@ -104,6 +291,210 @@ func (r *routes) handleStatsRequest(c *fiber.Ctx) error {
 	return c.JSON(stats)
 }
 
+// maxStatsBanners caps the number of banners listed in one statistics query.
+const maxStatsBanners = 1000
+
+// handleStatsQuery returns the statistics of the listed banners, or of every
+// banner with "bannerIDs": "all", together with their aggregate.
+func (r *routes) handleStatsQuery(c *fiber.Ctx) error {
+	request, apiErr := statisticsRequest(c)
+	if apiErr != nil {
+		return respond(c, apiErr)
+	}
+
+	var requestBody struct {
+		BannerIDs json.RawMessage `json:"bannerIDs"`
+	}
+
+	if err := c.BodyParser(&requestBody); err != nil {
+		return respond(c, errInvalidJSON)
+	}
+
+	query := model.StatisticsQuery{StatisticsRequest: request}
+	if string(bytes.TrimSpace(requestBody.BannerIDs)) == `"all"` {
+		query.All = true
+	} else if err := json.Unmarshal(requestBody.BannerIDs, &query.BannerIDs); err != nil || len(query.BannerIDs) == 0 {
+		return respond(c, newAPIError(fiber.StatusBadRequest, "invalid_banner_ids", `Banner IDs must be a non-empty list or "all"`))
+	}
+
+	if len(query.BannerIDs) > maxStatsBanners {
+		return respond(c, newAPIError(fiber.StatusBadRequest, "too_many_banner_ids",
+			fmt.Sprintf("Banner IDs must not exceed %d entries", maxStatsBanners)))
+	}
+
+	seen := make(map[model.BannerID]struct{}, len(query.BannerIDs))
+	ids := query.BannerIDs[:0]
+	for _, id := range query.BannerIDs {
+		if err := r.banners.ValidateID(id); errors.Is(err, repository.ErrInvalidBannerID) {
+			return respond(c, errInvalidBannerID)
+		}
+
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	query.BannerIDs = ids
+
+	stats, err := r.statistics.QueryStatistics(c.UserContext(), query)
+	if err != nil {
+		return r.fail(c, err, "Failed to retrieve statistics")
+	}
+
+	return c.JSON(stats)
+}
+
+// maxTopBanners caps the size of a leaderboard.
+const maxTopBanners = 100
+
+// handleTopBanners ranks the banners of the tenant over a window, by clicks
+// or, with by=ctr, by click-through rate.
+func (r *routes) handleTopBanners(c *fiber.Ctx) error {
+	n, err := strconv.Atoi(c.Query("n", "10"))
+	if err != nil || n < 1 || n > maxTopBanners {
+		return respond(c, newAPIError(fiber.StatusBadRequest, "invalid_n",
+			fmt.Sprintf("n must be between 1 and %d", maxTopBanners)))
+	}
+
+	window, err := time.ParseDuration(c.Query("window", "1h"))
+	if err != nil {
+		return respond(c, errUnsupportedWindow)
+	}
+
+	by, err := model.ParseRanking(c.Query("by"))
+	if err != nil {
+		return respond(c, newAPIError(fiber.StatusBadRequest, "invalid_ranking", "Invalid ranking"))
+	}
+
+	top, err := r.statistics.TopBanners(n, window, by)
+	if errors.Is(err, service.ErrUnsupportedWindow) {
+		windows := make([]string, 0)
+		for _, w := range service.LeaderboardWindows() {
+			windows = append(windows, formatWindow(w))
+		}
+
+		return respond(c, newAPIError(errUnsupportedWindow.status, errUnsupportedWindow.code,
+			"Window must be one of "+strings.Join(windows, ", ")))
+	}
+	if err != nil {
+		return r.fail(c, err, "Failed to retrieve statistics")
+	}
+
+	return c.JSON(fiber.Map{
+		"window": formatWindow(window),
+		"by":     by,
+		"top":    top,
+	})
+}
+
+// formatWindow formats a window without its zero minutes and seconds, e.g.
+// 1h rather than 1h0m0s.
+func formatWindow(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+
+	return s
+}
+
+// handleTotalsRequest returns the statistics of all banners of the tenant
+// summed up, for the same request body as the banner statistics.
+func (r *routes) handleTotalsRequest(c *fiber.Ctx) error {
+	request, apiErr := statisticsRequest(c)
+	if apiErr != nil {
+		return respond(c, apiErr)
+	}
+
+	totals, err := r.statistics.GetTotals(c.UserContext(), request)
+	if err != nil {
+		return r.fail(c, err, "Failed to retrieve statistics")
+	}
+	totals.Tenant = r.tenant
+
+	return c.JSON(totals)
+}
+
+// statisticsRequest parses the body of a statistics request. It returns the
+// answer to an invalid body.
+func statisticsRequest(c *fiber.Ctx) (model.StatisticsRequest, *apiError) {
+	var requestBody struct {
+		From        string `json:"from"`
+		To          string `json:"to"`
+		Granularity string `json:"granularity"`
+		GroupBy     string `json:"groupBy"`
+		FillGaps    bool   `json:"fillGaps"`
+		TZ          string `json:"tz"`
+	}
+
+	if err := c.BodyParser(&requestBody); err != nil {
+		return model.StatisticsRequest{}, errInvalidJSON
+	}
+
+	granularity, err := model.ParseGranularity(requestBody.Granularity)
+	if err != nil {
+		return model.StatisticsRequest{}, newAPIError(fiber.StatusBadRequest, "invalid_granularity", "Invalid granularity")
+	}
+
+	dimension, err := model.ParseDimension(requestBody.GroupBy)
+	if err != nil {
+		return model.StatisticsRequest{}, newAPIError(fiber.StatusBadRequest, "invalid_group_by", "Invalid groupBy")
+	}
+
+	return model.StatisticsRequest{
+		From:        requestBody.From,
+		To:          requestBody.To,
+		Granularity: granularity,
+		GroupBy:     dimension,
+		FillGaps:    requestBody.FillGaps,
+		TZ:          requestBody.TZ,
+	}, nil
+}
+
+// countClick counts a click of the banner in the metrics, as accepted
+// without an error and under the code of its error answer otherwise.
+func (r *routes) countClick(id model.BannerID, err error) {
+	result := metrics.ClickAccepted
+	if err != nil {
+		result = "internal"
+		if e := errorFor(err); e != nil {
+			result = e.code
+		}
+	}
+
+	metrics.CountClicks(r.tenant, id, result, 1)
+}
+
+// bannerID parses the banner ID path parameter. It fails for an ID the click
+// counters never take, such as a non-numeric one in the integer mode; an ID
+// out of their range is left to the lookup, which does not find it. The ID is
+// copied out of the request buffer, which fiber reuses, as it may be stored.
+func (r *routes) bannerID(c *fiber.Ctx) (model.BannerID, error) {
+	id := model.BannerID(utils.CopyString(c.Params("bannerID")))
+	if err := r.banners.ValidateID(id); errors.Is(err, repository.ErrInvalidBannerID) {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// activeBanner returns the catalog banner if it counts clicks. Without a
+// catalog, every banner the counters accept is active.
+func (r *routes) activeBanner(bid model.BannerID) (model.CatalogBanner, error) {
+	if r.catalog == nil {
+		if err := r.banners.ValidateID(bid); err != nil {
+			return model.CatalogBanner{}, fmt.Errorf("%w: %w", service.ErrBannerNotFound, err)
+		}
+
+		return model.CatalogBanner{ID: bid, Status: model.BannerStatusActive}, nil
+	}
+
+	return r.catalog.Active(bid)
+}
+
 func getBannerID(c *fiber.Ctx) (int, error) {
 	bannerID := c.Params("bannerID")
 	if bannerID == "" {
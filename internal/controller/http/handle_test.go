@@ -2,32 +2,72 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"rsclabs-test/internal/model"
 	"rsclabs-test/internal/repository"
 	"rsclabs-test/internal/repository/inmemorystorage"
 	"rsclabs-test/internal/service"
+	"rsclabs-test/pkg/httpserver"
 	"rsclabs-test/pkg/observe"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/getsentry/sentry-go"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func setupTestRoutes() *routes {
-	storage := inmemorystorage.NewInMemoryStorage(100, nil)
+	storage := inmemorystorage.NewInMemoryStorage(100, 100, nil)
 	bannerRepo, _ := repository.NewBannerRepository(storage)
 	app := fiber.New()
 	logger := observe.NewZapLogger("test-app")
-	statsService := service.NewStatisticsService(bannerRepo, app, logger)
+	ctx := context.Background()
+	catalogRepo, _ := repository.NewCatalogRepository("")
+	catalog, _ := service.NewCatalogService(ctx, catalogRepo, bannerRepo.ValidateID, logger)
+	for id := 1; id <= 100; id++ {
+		catalog.Create(ctx, model.CatalogBanner{ID: model.BannerIDFromInt(id), Name: fmt.Sprintf("Banner %d", id)})
+	}
+
+	statsService := service.NewStatisticsService(bannerRepo, repository.NewInMemorySnapshotRepository(), nil, catalog, app, logger)
 
 	return &routes{
 		banners:    bannerRepo,
 		statistics: statsService,
+		catalog:    catalog,
 		l:          logger,
 	}
 }
 
+// errorJSON is the error of an answer without a request ID.
+func errorJSON(code, message string) map[string]interface{} {
+	return map[string]interface{}{"code": code, "message": message, "request_id": ""}
+}
+
+// errorMessage returns the message of an error answer.
+func errorMessage(body map[string]interface{}) string {
+	e, _ := body["error"].(map[string]interface{})
+	message, _ := e["message"].(string)
+
+	return message
+}
+
 func TestHandleClick(t *testing.T) {
 	app := fiber.New()
 	routes := setupTestRoutes()
@@ -54,15 +94,15 @@ func TestHandleClick(t *testing.T) {
 			bannerID:       "abc",
 			expectedStatus: 400,
 			expectedBody: map[string]interface{}{
-				"error": "Invalid banner ID format",
+				"error": errorJSON("invalid_banner_id", "Invalid banner ID format"),
 			},
 		},
 		{
-			name:           "Banner ID out of range",
+			name:           "Banner not in catalog",
 			bannerID:       "101",
-			expectedStatus: 400,
+			expectedStatus: 404,
 			expectedBody: map[string]interface{}{
-				"error": "Banner ID must be between 1 and 100",
+				"error": errorJSON("banner_not_found", "Banner not found"),
 			},
 		},
 	}
@@ -122,7 +162,29 @@ func TestHandleStatsRequest(t *testing.T) {
 			},
 			expectedStatus: 400,
 			expectedBody: map[string]interface{}{
-				"error": "Invalid banner ID format",
+				"error": errorJSON("invalid_banner_id", "Invalid banner ID format"),
+			},
+		},
+		{
+			name:     "Invalid granularity",
+			bannerID: "1",
+			requestBody: map[string]interface{}{
+				"granularity": "fortnight",
+			},
+			expectedStatus: 400,
+			expectedBody: map[string]interface{}{
+				"error": errorJSON("invalid_granularity", "Invalid granularity"),
+			},
+		},
+		{
+			name:     "Invalid groupBy",
+			bannerID: "1",
+			requestBody: map[string]interface{}{
+				"groupBy": "browser",
+			},
+			expectedStatus: 400,
+			expectedBody: map[string]interface{}{
+				"error": errorJSON("invalid_group_by", "Invalid groupBy"),
 			},
 		},
 		{
@@ -217,3 +279,1015 @@ func TestGetBannerID(t *testing.T) {
 		})
 	}
 }
+
+func TestClickCountsSurviveRotation(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+
+	app.Get("/counter/:bannerID", routes.handleClick)
+
+	goroutines := 8
+	iterations := 250
+
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				req := httptest.NewRequest("GET", fmt.Sprintf("/counter/%d", (g+j)%10+1), nil)
+				resp, err := app.Test(req)
+				if err != nil {
+					continue
+				}
+				if resp.StatusCode == fiber.StatusOK {
+					accepted.Add(1)
+				}
+				resp.Body.Close()
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Rotate the counters continuously while clicks are coming in
+	ctx := context.Background()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			routes.statistics.RegisterStatistics(ctx)
+		}
+	}
+	routes.statistics.RegisterStatistics(ctx)
+
+	total := 0
+	for _, snapshot := range routes.statistics.GetSnapshots() {
+		for _, banner := range snapshot.Banners {
+			total += banner.Count
+		}
+	}
+
+	assert.Equal(t, int(accepted.Load()), total, "clicks in snapshots must match clicks accepted by /counter")
+	assert.Equal(t, goroutines*iterations, total)
+}
+
+func TestHandleBatchClick(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+
+	app.Post("/counter/batch", routes.handleBatchClick)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "JSON array",
+			contentType:    "application/json",
+			body:           `[{"bannerID": 1, "ts": "` + now + `", "count": 3}, {"bannerID": 2}, {"bannerID": 101}, {"bannerID": 1, "count": 0}, {"bannerID": 1, "ts": "2024-01-01T00:00:00Z"}]`,
+			expectedStatus: 200,
+			expectedBody: map[string]interface{}{
+				"accepted": float64(2),
+				"rejected": float64(3),
+				"results": []interface{}{
+					map[string]interface{}{"bannerID": float64(1), "success": true},
+					map[string]interface{}{"bannerID": float64(2), "success": true},
+					map[string]interface{}{"bannerID": float64(101), "success": false, "code": "banner_not_found", "error": "Banner not found"},
+					map[string]interface{}{"bannerID": float64(1), "success": false, "code": "invalid_count", "error": "Count must be positive"},
+					map[string]interface{}{"bannerID": float64(1), "success": false, "code": "click_outside_window", "error": "Timestamp is outside the accepted window"},
+				},
+			},
+		},
+		{
+			name:           "NDJSON",
+			contentType:    "application/x-ndjson",
+			body:           "{\"bannerID\": 3, \"count\": 2}\n{\"bannerID\": 4}\n",
+			expectedStatus: 200,
+			expectedBody: map[string]interface{}{
+				"accepted": float64(2),
+				"rejected": float64(0),
+				"results": []interface{}{
+					map[string]interface{}{"bannerID": float64(3), "success": true},
+					map[string]interface{}{"bannerID": float64(4), "success": true},
+				},
+			},
+		},
+		{
+			name:           "Empty batch",
+			contentType:    "application/json",
+			body:           `[]`,
+			expectedStatus: 400,
+			expectedBody: map[string]interface{}{
+				"error": errorJSON("empty_batch", "Batch is empty"),
+			},
+		},
+		{
+			name:           "Invalid body",
+			contentType:    "application/json",
+			body:           `{"bannerID": 1}`,
+			expectedStatus: 400,
+			expectedBody: map[string]interface{}{
+				"error": errorJSON("invalid_batch", "Invalid batch body"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/counter/batch", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			resp, _ := app.Test(req)
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			var response map[string]interface{}
+			json.NewDecoder(resp.Body).Decode(&response)
+			assert.Equal(t, tt.expectedBody, response)
+		})
+	}
+
+	counts := make(map[model.BannerID]int)
+	for _, banner := range routes.banners.GetValues() {
+		counts[banner.BannerID] = banner.Count
+	}
+	assert.Equal(t, map[model.BannerID]int{"1": 3, "2": 1, "3": 2, "4": 1}, counts)
+}
+
+type fakeCountryResolver map[string]string
+
+func (r fakeCountryResolver) Country(ip net.IP) (string, error) {
+	return r[ip.String()], nil
+}
+
+func TestHandleClickRecordsAttributes(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+	routes.countries = fakeCountryResolver{"0.0.0.0": "DE"}
+
+	app.Get("/counter/:bannerID", routes.handleClick)
+
+	req := httptest.NewRequest("GET", "/counter/1?placement=sidebar", nil)
+	req.Header.Set("Referer", "https://www.example.com/articles/42?utm=x")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148")
+	resp, _ := app.Test(req)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	snapshots := routes.banners.RotateCounts()
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, model.DimensionCounts{
+		model.DimensionReferrer:  {"example.com": 1},
+		model.DimensionUserAgent: {"mobile": 1},
+		model.DimensionPlacement: {"sidebar": 1},
+		model.DimensionCountry:   {"DE": 1},
+	}, snapshots[0].Banners["1"].Dimensions)
+}
+
+func TestUserAgentClass(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"", ""},
+		{"Googlebot/2.1 (+http://www.google.com/bot.html)", "bot"},
+		{"curl/8.4.0", "bot"},
+		{"Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)", "tablet"},
+		{"Mozilla/5.0 (Linux; Android 14; SM-X700)", "tablet"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36", "mobile"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64)", "desktop"},
+		{"SomethingElse/1.0", "other"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, userAgentClass(tt.userAgent), tt.userAgent)
+	}
+}
+
+func TestHandleImpression(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+
+	app.Get("/impression/:bannerID", routes.handleImpression)
+
+	tests := []struct {
+		name           string
+		bannerID       string
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "Valid banner ID",
+			bannerID:       "7",
+			expectedStatus: 200,
+			expectedBody: map[string]interface{}{
+				"bannerID": float64(7),
+				"success":  true,
+			},
+		},
+		{
+			name:           "Invalid banner ID format",
+			bannerID:       "abc",
+			expectedStatus: 400,
+			expectedBody: map[string]interface{}{
+				"error": errorJSON("invalid_banner_id", "Invalid banner ID format"),
+			},
+		},
+		{
+			name:           "Banner not in catalog",
+			bannerID:       "0",
+			expectedStatus: 404,
+			expectedBody: map[string]interface{}{
+				"error": errorJSON("banner_not_found", "Banner not found"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/impression/"+tt.bannerID, nil)
+			resp, _ := app.Test(req)
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			var response map[string]interface{}
+			json.NewDecoder(resp.Body).Decode(&response)
+			assert.Equal(t, tt.expectedBody, response)
+		})
+	}
+
+	values := routes.banners.GetValues()
+	require.Len(t, values, 1)
+	assert.Equal(t, 1, values[0].Impressions)
+	assert.Equal(t, 0, values[0].Count)
+}
+
+func TestHandleRedirect(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+	ctx := context.Background()
+
+	for id, landing := range map[model.BannerID]string{
+		"1": "https://shop.example.com/promo?utm_source=banner&id=7",
+		"2": "https://evil.example.org/phish",
+	} {
+		_, err := routes.catalog.Update(ctx, id, service.BannerUpdate{LandingURL: &landing})
+		require.NoError(t, err)
+	}
+	routes.redirects = newRedirectPolicy([]string{"example.com"})
+
+	app.Get("/r/:bannerID", routes.handleRedirect)
+
+	tests := []struct {
+		name             string
+		path             string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{
+			name:             "Redirect with UTM pass-through",
+			path:             "/r/1?utm_source=newsletter&utm_campaign=june&placement=top",
+			expectedStatus:   302,
+			expectedLocation: "https://shop.example.com/promo?id=7&utm_campaign=june&utm_source=newsletter",
+		},
+		{
+			name:             "Redirect without query",
+			path:             "/r/1",
+			expectedStatus:   302,
+			expectedLocation: "https://shop.example.com/promo?utm_source=banner&id=7",
+		},
+		{
+			name:           "Domain not allowed",
+			path:           "/r/2",
+			expectedStatus: 403,
+		},
+		{
+			name:           "No landing URL",
+			path:           "/r/4",
+			expectedStatus: 404,
+		},
+		{
+			name:           "Banner not in catalog",
+			path:           "/r/101",
+			expectedStatus: 404,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			resp, _ := app.Test(req)
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			assert.Equal(t, tt.expectedLocation, resp.Header.Get("Location"))
+		})
+	}
+
+	// Only the two redirects are counted
+	values := routes.banners.GetValues()
+	require.Len(t, values, 1)
+	assert.Equal(t, 2, values[0].Count)
+}
+
+func TestRedirectPolicyAllows(t *testing.T) {
+	policy := newRedirectPolicy([]string{"Example.com", " shop.test "})
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"https://example.com/a", true},
+		{"http://www.example.com/a", true},
+		{"https://shop.test./a", true},
+		{"https://notexample.com/a", false},
+		{"https://example.com.evil.org/a", false},
+		{"ftp://example.com/a", false},
+		{"//example.com/a", false},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.target)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, policy.allows(u), tt.target)
+	}
+
+	assert.False(t, newRedirectPolicy(nil).allows(&url.URL{Scheme: "https", Host: "example.com"}))
+}
+
+func TestBannerCatalogEndpoints(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+
+	app.Get("/banners", routes.handleListBanners)
+	app.Post("/banners", routes.handleCreateBanner)
+	app.Get("/banners/:bannerID", routes.handleGetBanner)
+	app.Put("/banners/:bannerID", routes.handleUpdateBanner)
+	app.Delete("/banners/:bannerID", routes.handleDeleteBanner)
+	app.Get("/counter/:bannerID", routes.handleClick)
+
+	// setupTestRoutes fills the catalog; free an ID by starting over
+	catalogRepo, _ := repository.NewCatalogRepository("")
+	routes.catalog, _ = service.NewCatalogService(context.Background(), catalogRepo, routes.banners.ValidateID, routes.l)
+
+	do := func(method, path, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req)
+
+		var response map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&response)
+
+		return resp.StatusCode, response
+	}
+
+	status, body := do("POST", "/banners", `{"name": "Summer sale", "landingURL": "https://shop.example.com", "campaign": "summer"}`)
+	assert.Equal(t, 201, status)
+	assert.Equal(t, float64(1), body["id"])
+	assert.Equal(t, "active", body["status"])
+
+	status, body = do("POST", "/banners", `{"id": 1, "name": "Duplicate"}`)
+	assert.Equal(t, 409, status)
+	assert.Equal(t, "Banner already exists", errorMessage(body))
+
+	status, body = do("POST", "/banners", `{"name": ""}`)
+	assert.Equal(t, 422, status)
+	assert.Equal(t, "Invalid banner: name is required", errorMessage(body))
+
+	status, body = do("POST", "/banners", `{"name": "Paused", "status": "sleeping"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "Invalid banner status", errorMessage(body))
+
+	status, body = do("PUT", "/banners/1", `{"name": "Summer sale 2"}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "Summer sale 2", body["name"])
+	assert.Equal(t, "summer", body["campaign"])
+
+	status, body = do("GET", "/banners/1", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "Summer sale 2", body["name"])
+
+	status, _ = do("GET", "/banners/2", "")
+	assert.Equal(t, 404, status)
+
+	status, body = do("GET", "/banners", "")
+	assert.Equal(t, 200, status)
+	assert.Len(t, body["banners"], 1)
+
+	status, _ = do("GET", "/counter/1", "")
+	assert.Equal(t, 200, status)
+
+	status, body = do("DELETE", "/banners/1", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "retired", body["status"])
+
+	status, body = do("GET", "/counter/1", "")
+	assert.Equal(t, 409, status)
+	assert.Equal(t, "Banner is not active", errorMessage(body))
+}
+
+func TestHandleClickStringIDs(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+	routes.banners = repository.NewSparseBannerRepository(inmemorystorage.NewSparseStorage(2, time.Hour, 100, nil))
+	routes.catalog = nil
+
+	app.Get("/counter/:bannerID", routes.handleClick)
+	app.Post("/counter/batch", routes.handleBatchClick)
+
+	get := func(path string) (int, map[string]interface{}) {
+		resp, _ := app.Test(httptest.NewRequest("GET", path, nil))
+
+		var response map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&response)
+
+		return resp.StatusCode, response
+	}
+
+	status, body := get("/counter/3f2a9c1e-7b4d-4e0a-9d51-0c8e2b7f6a13")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "3f2a9c1e-7b4d-4e0a-9d51-0c8e2b7f6a13", body["bannerID"])
+
+	// Numeric IDs keep their JSON number shape
+	status, body = get("/counter/12")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, float64(12), body["bannerID"])
+
+	status, body = get("/counter/summer%20sale")
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "Invalid banner ID format", errorMessage(body))
+
+	status, body = get("/counter/one-too-many")
+	assert.Equal(t, 503, status)
+	assert.Equal(t, "Too many active banners", errorMessage(body))
+
+	req := httptest.NewRequest("POST", "/counter/batch", strings.NewReader(`[{"bannerID": "3f2a9c1e-7b4d-4e0a-9d51-0c8e2b7f6a13", "count": 2}, {"bannerID": 12}]`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	counts := make(map[model.BannerID]int)
+	for _, banner := range routes.banners.GetValues() {
+		counts[banner.BannerID] = banner.Count
+	}
+	assert.Equal(t, map[model.BannerID]int{"3f2a9c1e-7b4d-4e0a-9d51-0c8e2b7f6a13": 3, "12": 2}, counts)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	logger := observe.NewZapLogger("test-app")
+	app := fiber.New()
+
+	newTenant := func(id string, maxBanners int) *service.Tenant {
+		bannerRepo, _ := repository.NewBannerRepository(inmemorystorage.NewInMemoryStorage(maxBanners, 100, nil))
+		return &service.Tenant{
+			ID:         id,
+			Banners:    bannerRepo,
+			Statistics: service.NewStatisticsService(bannerRepo, repository.NewInMemorySnapshotRepository(), nil, nil, app, logger),
+		}
+	}
+
+	acme, globex := newTenant("acme", 5), newTenant("globex", 2)
+	tenants, err := service.NewTenantService([]*service.Tenant{acme, globex}, map[string]string{"acme-key": "acme"})
+	require.NoError(t, err)
+
+	NewRouter(tenants, nil, nil, app, logger)
+
+	do := func(method, path, key string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		resp, _ := app.Test(req)
+
+		var response map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&response)
+
+		return resp.StatusCode, response
+	}
+
+	status, _ := do("GET", "/t/acme/counter/1", "")
+	assert.Equal(t, 200, status)
+	status, _ = do("GET", "/counter/1", "acme-key")
+	assert.Equal(t, 200, status)
+	status, _ = do("GET", "/t/globex/counter/1", "")
+	assert.Equal(t, 200, status)
+
+	// The banner limits are per tenant
+	status, _ = do("GET", "/t/acme/counter/5", "")
+	assert.Equal(t, 200, status)
+	status, _ = do("GET", "/t/globex/counter/5", "")
+	assert.Equal(t, 404, status)
+
+	status, body := do("GET", "/counter/1", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "Tenant not found", errorMessage(body))
+
+	status, body = do("GET", "/t/globex/counter/1", "acme-key")
+	assert.Equal(t, 403, status)
+	assert.Equal(t, "API key does not belong to the tenant", errorMessage(body))
+
+	status, body = do("GET", "/counter/1", "wrong-key")
+	assert.Equal(t, 401, status)
+	assert.Equal(t, "Invalid API key", errorMessage(body))
+
+	// Reading statistics requires an API key once keys are configured
+	status, body = do("POST", "/t/acme/totals", "")
+	assert.Equal(t, 401, status)
+	assert.Equal(t, "API key is required", errorMessage(body))
+
+	ctx := context.Background()
+	acme.Statistics.RegisterStatistics(ctx)
+	globex.Statistics.RegisterStatistics(ctx)
+
+	status, body = do("POST", "/totals", "acme-key")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "acme", body["tenant"])
+	assert.Equal(t, float64(3), body["v"])
+	assert.Equal(t, float64(2), body["banners"])
+
+	assert.Empty(t, globex.Banners.GetValues())
+	globexTotals, err := globex.Statistics.GetTotals(ctx, model.StatisticsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, globexTotals.Count)
+}
+
+func TestHandleStatsQuery(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+
+	app.Post("/stats", routes.handleStatsQuery)
+
+	routes.banners.RegisterClick("1")
+	routes.banners.RegisterClick("2")
+	routes.banners.RegisterClick("2")
+	routes.statistics.RegisterStatistics(context.Background())
+
+	post := func(body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/stats", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req)
+
+		var response map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&response)
+
+		return resp.StatusCode, response
+	}
+
+	status, body := post(`{"bannerIDs": [2, 1, 2]}`)
+	require.Equal(t, 200, status)
+	banners := body["banners"].([]interface{})
+	require.Len(t, banners, 2)
+	assert.Equal(t, float64(1), banners[0].(map[string]interface{})["id"])
+	assert.Equal(t, "Banner 1", banners[0].(map[string]interface{})["name"])
+
+	total := 0
+	for _, bucket := range body["aggregate"].([]interface{}) {
+		total += int(bucket.(map[string]interface{})["v"].(float64))
+	}
+	assert.Equal(t, 3, total)
+
+	status, body = post(`{"bannerIDs": "all"}`)
+	assert.Equal(t, 200, status)
+	assert.Len(t, body["banners"], 2)
+
+	status, body = post(`{"bannerIDs": []}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, `Banner IDs must be a non-empty list or "all"`, errorMessage(body))
+
+	status, body = post(`{"bannerIDs": ["summer-sale"]}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "Invalid banner ID format", errorMessage(body))
+
+	status, body = post(`{"bannerIDs": [101]}`)
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "Banner not found", errorMessage(body))
+}
+
+func TestHandleTopBanners(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+
+	app.Get("/stats/top", routes.handleTopBanners)
+
+	for _, id := range []model.BannerID{"3", "7", "7", "7", "5", "5"} {
+		routes.banners.RegisterClick(id)
+	}
+	routes.banners.RegisterImpression("3")
+	routes.statistics.RegisterStatistics(context.Background())
+
+	get := func(path string) (int, map[string]interface{}) {
+		resp, _ := app.Test(httptest.NewRequest("GET", path, nil))
+
+		var response map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&response)
+
+		return resp.StatusCode, response
+	}
+
+	status, body := get("/stats/top?n=2&window=1h")
+	require.Equal(t, 200, status)
+	assert.Equal(t, "1h", body["window"])
+	top := body["top"].([]interface{})
+	require.Len(t, top, 2)
+	assert.Equal(t, float64(7), top[0].(map[string]interface{})["id"])
+	assert.Equal(t, float64(3), top[0].(map[string]interface{})["v"])
+	assert.Equal(t, "Banner 7", top[0].(map[string]interface{})["name"])
+
+	status, body = get("/stats/top?by=ctr")
+	require.Equal(t, 200, status)
+	assert.Len(t, body["top"], 1)
+
+	status, body = get("/stats/top?window=2h")
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "Window must be one of 5m, 15m, 1h, 6h, 24h", errorMessage(body))
+
+	status, _ = get("/stats/top?n=0")
+	assert.Equal(t, 400, status)
+
+	status, _ = get("/stats/top?by=views")
+	assert.Equal(t, 400, status)
+}
+
+func TestHandleStatsRequestFillGaps(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+
+	app.Post("/stats/:bannerID", routes.handleStatsRequest)
+
+	routes.banners.RegisterClick("1")
+	routes.statistics.RegisterStatistics(context.Background())
+
+	post := func(body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/stats/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req)
+
+		var response map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&response)
+
+		return resp.StatusCode, response
+	}
+
+	from := time.Now().Add(-10 * time.Minute).Format("2006-01-02T15:04:05")
+	status, body := post(`{"from": "` + from + `", "fillGaps": true}`)
+	require.Equal(t, 200, status)
+	assert.GreaterOrEqual(t, len(body["stats"].([]interface{})), 10)
+
+	status, body = post(`{"from": "2020-01-01T00:00:00", "fillGaps": true}`)
+	assert.Equal(t, 422, status)
+	assert.Equal(t, "Range is too long to fill its gaps, use a coarser granularity", errorMessage(body))
+}
+
+func TestHandleStatsRequestTimeZone(t *testing.T) {
+	app := fiber.New()
+	routes := setupTestRoutes()
+
+	app.Post("/stats/:bannerID", routes.handleStatsRequest)
+
+	routes.banners.RegisterClick("1")
+	routes.statistics.RegisterStatistics(context.Background())
+
+	post := func(body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/stats/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req)
+
+		var response map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&response)
+
+		return resp.StatusCode, response
+	}
+
+	status, body := post(`{"from": "now-1h", "to": "now+1m", "tz": "Asia/Kolkata"}`)
+	require.Equal(t, 200, status)
+	stats := body["stats"].([]interface{})
+	require.Len(t, stats, 1)
+	assert.True(t, strings.HasSuffix(stats[0].(map[string]interface{})["ts"].(string), "+05:30"))
+
+	from := time.Now().Add(-time.Hour).Unix()
+	status, body = post(fmt.Sprintf(`{"from": "%d"}`, from))
+	require.Equal(t, 200, status)
+	assert.Len(t, body["stats"], 1)
+
+	status, body = post(`{"from": "now-1h", "tz": "Mars/Olympus"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "Invalid tz", errorMessage(body))
+
+	status, body = post(`{"from": "invalid-date"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "Invalid time", errorMessage(body))
+
+	status, body = post(`{"from": "now", "to": "now-1h"}`)
+	assert.Equal(t, 422, status)
+	assert.Equal(t, "Invalid time range", errorMessage(body))
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	app := fiber.New()
+	app.Use(requestid.New())
+	routes := setupTestRoutes()
+
+	app.Post("/stats/:bannerID", routes.handleStatsRequest)
+
+	routes.banners.RegisterClick("1")
+	routes.statistics.RegisterStatistics(context.Background())
+
+	req := httptest.NewRequest("POST", "/stats/abc", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req)
+	require.Equal(t, 400, resp.StatusCode)
+
+	var body struct {
+		Error struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "invalid_banner_id", body.Error.Code)
+	assert.Equal(t, "Invalid banner ID format", body.Error.Message)
+	assert.NotEmpty(t, body.Error.RequestID)
+	assert.Equal(t, resp.Header.Get(fiber.HeaderXRequestID), body.Error.RequestID)
+
+	// A request ID sent by the client is kept
+	req = httptest.NewRequest("POST", "/stats/1", strings.NewReader(`{"from": "now", "to": "now-1h"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(fiber.HeaderXRequestID, "req-42")
+	resp, _ = app.Test(req)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, 422, resp.StatusCode)
+	assert.Equal(t, "invalid_time_range", body.Error.Code)
+	assert.Equal(t, "req-42", body.Error.RequestID)
+}
+
+func TestErrorFor(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("lookup: %w", service.ErrBannerNotFound), 404, "banner_not_found"},
+		{fmt.Errorf("parse: %w", service.ErrInvalidTime), 400, "invalid_time"},
+		{service.ErrInvalidTimeRange, 422, "invalid_time_range"},
+		{service.ErrTooManyBuckets, 422, "range_too_long"},
+		{repository.ErrTooManyBanners, 503, "too_many_banners"},
+		{fmt.Errorf("read: %w", repository.ErrStoreUnavailable), 503, "unavailable"},
+		{context.DeadlineExceeded, 503, "unavailable"},
+		{errTenantMismatch, 403, "tenant_mismatch"},
+	}
+
+	for _, tt := range tests {
+		e := errorFor(tt.err)
+		require.NotNil(t, e, tt.err)
+		assert.Equal(t, tt.status, e.status, tt.err)
+		assert.Equal(t, tt.code, e.code, tt.err)
+	}
+
+	assert.Nil(t, errorFor(errors.New("disk on fire")))
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	app := httpserver.InitFiberServer("test-app", nil, nil)
+	routes := setupTestRoutes()
+	routes.tenant = t.Name()
+
+	app.Get("/counter/:bannerID", routes.handleClick)
+
+	for _, path := range []string{"/counter/7", "/counter/7", "/counter/101", "/no-such-path"} {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", httpserver.MetricsEndpoint, nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	metrics := string(body)
+
+	assert.Contains(t, metrics, fmt.Sprintf(`banner_clicks_total{banner="7",result="accepted",tenant=%q} 2`, t.Name()))
+	assert.Contains(t, metrics, fmt.Sprintf(`banner_clicks_total{banner="other",result="banner_not_found",tenant=%q} 1`, t.Name()))
+	assert.Contains(t, metrics, `http_request_duration_seconds_count{method="GET",route="/counter/:bannerID",status="200"}`)
+	assert.Contains(t, metrics, `http_request_duration_seconds_count{method="GET",route="unmatched",status="404"}`)
+}
+
+func TestTracing(t *testing.T) {
+	spanFile := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := observe.InitTracing(context.Background(), "test-app", "test", observe.TracingFile, spanFile)
+	require.NoError(t, err)
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	app := httpserver.InitFiberServer("test-app", nil, nil)
+	routes := setupTestRoutes()
+
+	app.Post("/stats/:bannerID", routes.handleStatsRequest)
+
+	routes.banners.RegisterClick("1")
+	routes.statistics.RegisterStatistics(context.Background())
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req := httptest.NewRequest("POST", "/stats/1", strings.NewReader(`{"from": "now-1h"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	require.NoError(t, shutdown(context.Background()))
+
+	type spanContext struct {
+		TraceID string
+		SpanID  string
+	}
+	type span struct {
+		Name        string
+		SpanContext spanContext
+		Parent      spanContext
+	}
+
+	f, err := os.Open(spanFile)
+	require.NoError(t, err)
+	defer f.Close()
+
+	spans := make(map[string]span)
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		var s span
+		require.NoError(t, decoder.Decode(&s))
+		if s.SpanContext.TraceID == traceID {
+			spans[s.Name] = s
+		}
+	}
+
+	server, ok := spans["POST /stats/:bannerID"]
+	require.True(t, ok, "no server span in %v", spans)
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID)
+
+	statistics, ok := spans["StatisticsService.GetStatistics"]
+	require.True(t, ok, "no service span in %v", spans)
+	assert.Equal(t, server.SpanContext.SpanID, statistics.Parent.SpanID)
+
+	storage, ok := spans["SnapshotRepositoryInMemory.GetSnapshots"]
+	require.True(t, ok, "no storage span in %v", spans)
+	assert.Equal(t, statistics.SpanContext.SpanID, storage.Parent.SpanID)
+}
+
+// fakeSentryTransport keeps the events instead of sending them to Sentry.
+type fakeSentryTransport struct {
+	mux    sync.Mutex
+	events []*sentry.Event
+}
+
+func (t *fakeSentryTransport) Configure(sentry.ClientOptions) {}
+func (t *fakeSentryTransport) Flush(time.Duration) bool       { return true }
+func (t *fakeSentryTransport) Close()                         {}
+
+func (t *fakeSentryTransport) SendEvent(event *sentry.Event) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.events = append(t.events, event)
+}
+
+func (t *fakeSentryTransport) lastEvent() *sentry.Event {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if len(t.events) == 0 {
+		return nil
+	}
+
+	return t.events[len(t.events)-1]
+}
+
+func TestSentryReporting(t *testing.T) {
+	transport := &fakeSentryTransport{}
+	hook, err := observe.NewSentryHook(observe.SentryOptions{
+		Environment: "test",
+		Release:     "test-app@1.0.0",
+		Transport:   transport,
+	})
+	require.NoError(t, err)
+
+	app := httpserver.InitFiberServer("test-app", hook, nil)
+	routes := setupTestRoutes()
+	routes.tenant = "acme"
+	routes.l = routes.l.WithSentry(hook)
+
+	app.Get("/fail", func(c *fiber.Ctx) error {
+		return routes.fail(c, errors.New("disk on fire"), "Failed to read statistics")
+	})
+	app.Get("/panic", func(c *fiber.Ctx) error {
+		panic("boom")
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	t.Run("Logged error", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/fail", nil)
+		req.Header.Set(fiber.HeaderXRequestID, "req-1")
+		req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, 500, resp.StatusCode)
+
+		event := transport.lastEvent()
+		require.NotNil(t, event)
+		assert.Equal(t, sentry.LevelError, event.Level)
+		assert.Equal(t, "failed to read statistics: disk on fire", event.Message)
+		assert.Equal(t, "test", event.Environment)
+		assert.Equal(t, "test-app@1.0.0", event.Release)
+		assert.Equal(t, "acme", event.Tags["tenant"])
+		assert.Equal(t, "req-1", event.Tags["request_id"])
+		assert.Equal(t, traceID, event.Tags["trace_id"])
+	})
+
+	t.Run("Debug log", func(t *testing.T) {
+		before := transport.lastEvent()
+		routes.l.Debug("not an error")
+		assert.Same(t, before, transport.lastEvent())
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/panic", nil)
+		req.Header.Set(fiber.HeaderXRequestID, "req-2")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, 500, resp.StatusCode)
+
+		event := transport.lastEvent()
+		require.NotNil(t, event)
+		assert.Equal(t, sentry.LevelFatal, event.Level)
+		assert.Equal(t, "boom", event.Message)
+		assert.Equal(t, "/panic", event.Tags["route"])
+		assert.Equal(t, "req-2", event.Tags["request_id"])
+		require.NotNil(t, event.Request)
+		assert.Contains(t, event.Request.URL, "/panic")
+	})
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	var storageDown atomic.Bool
+
+	health := httpserver.NewHealth()
+	health.AddLivenessCheck("worker", func(context.Context) error { return nil })
+	health.AddReadinessCheck("storage", func(context.Context) error {
+		if storageDown.Load() {
+			return errors.New("storage unreachable")
+		}
+		return nil
+	})
+
+	app := httpserver.InitFiberServer("test-app", nil, health)
+
+	probe := func(path string) (int, map[string]interface{}) {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+		return resp.StatusCode, body
+	}
+
+	status, body := probe(httpserver.ReadinessEndpoint)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, map[string]interface{}{
+		"status": "ok",
+		"checks": map[string]interface{}{"worker": "ok", "storage": "ok"},
+	}, body)
+
+	storageDown.Store(true)
+
+	status, body = probe(httpserver.ReadinessEndpoint)
+	assert.Equal(t, 503, status)
+	assert.Equal(t, "unavailable", body["status"])
+	assert.Equal(t, "storage unreachable", body["checks"].(map[string]interface{})["storage"])
+
+	status, _ = probe(httpserver.LivenessEndpoint)
+	assert.Equal(t, 200, status, "readiness checks do not fail liveness")
+
+	storageDown.Store(false)
+	health.ShutDown()
+
+	status, body = probe(httpserver.ReadinessEndpoint)
+	assert.Equal(t, 503, status)
+	assert.Equal(t, "shutting down", body["checks"].(map[string]interface{})["shutdown"])
+
+	status, _ = probe(httpserver.LivenessEndpoint)
+	assert.Equal(t, 200, status)
+}
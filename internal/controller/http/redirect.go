@@ -0,0 +1,70 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// redirectPolicy guards the redirect endpoint against open redirects: only
+// http(s) URLs on an allowed domain or one of its subdomains are followed. An
+// empty allowlist allows nothing.
+type redirectPolicy struct {
+	domains []string
+}
+
+func newRedirectPolicy(domains []string) redirectPolicy {
+	normalized := make([]string, 0, len(domains))
+	for _, d := range domains {
+		d = strings.Trim(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d != "" {
+			normalized = append(normalized, d)
+		}
+	}
+
+	return redirectPolicy{domains: normalized}
+}
+
+func (p redirectPolicy) allows(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, d := range p.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redirectTarget returns the landing URL with the utm_* parameters of the
+// click request passed through. They take precedence over the ones already
+// in the landing URL.
+func (p redirectPolicy) redirectTarget(landing string, query url.Values) (string, error) {
+	u, err := url.Parse(landing)
+	if err != nil {
+		return "", fmt.Errorf("invalid landing URL %q: %w", landing, err)
+	}
+
+	if !p.allows(u) {
+		return "", fmt.Errorf("landing URL %q is not on an allowed domain", landing)
+	}
+
+	params := u.Query()
+	passed := false
+	for key, values := range query {
+		if strings.HasPrefix(strings.ToLower(key), "utm_") {
+			params[key] = values
+			passed = true
+		}
+	}
+
+	if passed {
+		u.RawQuery = params.Encode()
+	}
+
+	return u.String(), nil
+}
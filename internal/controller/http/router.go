@@ -4,22 +4,58 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"rsclabs-test/internal/service"
 
-	"rsclabs-test/internal/repository"
 	"rsclabs-test/pkg/observe"
 )
 
+// NewRouter registers the endpoints of every tenant twice: at the root, where
+// the tenant is named by the API key or is the default one, and under the
+// /t/:tenant path prefix.
 func NewRouter(
-	banners *repository.BannerRepositoryInMemory,
-	statisticsService *service.StatisticsService,
+	tenants *service.TenantService,
+	countries CountryResolver,
+	redirectDomains []string,
 	s *fiber.App,
 	l *observe.Logger,
 ) {
-	r := &routes{
-		banners:    banners,
-		statistics: statisticsService,
-		l:          l,
+	t := &tenantRoutes{
+		tenants: tenants,
+		routes:  make(map[string]*routes),
 	}
-	s.Get("/counter/:bannerID", r.handleClick)
 
-	s.Post("/stats/:bannerID", r.handleStatsRequest)
+	catalogs := true
+	for _, tenant := range tenants.List() {
+		t.routes[tenant.ID] = &routes{
+			tenant:     tenant.ID,
+			banners:    tenant.Banners,
+			statistics: tenant.Statistics,
+			catalog:    tenant.Catalog,
+			countries:  countries,
+			redirects:  newRedirectPolicy(redirectDomains),
+			l:          l,
+		}
+		catalogs = catalogs && tenant.Catalog != nil
+	}
+
+	t.register(s, catalogs)
+	t.register(s.Group("/t/:tenant"), catalogs)
+}
+
+func (t *tenantRoutes) register(router fiber.Router, catalogs bool) {
+	router.Get("/counter/:bannerID", t.track((*routes).handleClick))
+	router.Post("/counter/batch", t.track((*routes).handleBatchClick))
+	router.Get("/impression/:bannerID", t.track((*routes).handleImpression))
+	router.Get("/r/:bannerID", t.track((*routes).handleRedirect))
+
+	router.Post("/stats", t.manage((*routes).handleStatsQuery))
+	router.Get("/stats/top", t.manage((*routes).handleTopBanners))
+	router.Post("/stats/:bannerID", t.manage((*routes).handleStatsRequest))
+	router.Post("/totals", t.manage((*routes).handleTotalsRequest))
+
+	if catalogs {
+		router.Get("/banners", t.manage((*routes).handleListBanners))
+		router.Post("/banners", t.manage((*routes).handleCreateBanner))
+		router.Get("/banners/:bannerID", t.manage((*routes).handleGetBanner))
+		router.Put("/banners/:bannerID", t.manage((*routes).handleUpdateBanner))
+		router.Delete("/banners/:bannerID", t.manage((*routes).handleDeleteBanner))
+	}
 }
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/service"
+)
+
+// apiKeyHeader carries the API key that names the tenant of a request.
+const apiKeyHeader = "X-API-Key"
+
+// tenantRoutes dispatches a request to the routes of its tenant.
+type tenantRoutes struct {
+	tenants *service.TenantService
+	routes  map[string]*routes
+}
+
+// track wraps a tracking endpoint. Those are hit by browsers, which cannot send
+// an API key, so the path prefix is enough to name the tenant.
+func (t *tenantRoutes) track(h func(*routes, *fiber.Ctx) error) fiber.Handler {
+	return t.dispatch(h, false)
+}
+
+// manage wraps a statistics or catalog endpoint, which requires an API key
+// once any is configured.
+func (t *tenantRoutes) manage(h func(*routes, *fiber.Ctx) error) fiber.Handler {
+	return t.dispatch(h, true)
+}
+
+func (t *tenantRoutes) dispatch(h func(*routes, *fiber.Ctx) error, authenticate bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		r, err := t.resolve(c, authenticate)
+		if err != nil {
+			return respond(c, errorFor(err))
+		}
+
+		return h(r, c)
+	}
+}
+
+// resolve returns the routes of the tenant named by the API key or the path
+// prefix, which must agree when both are given. A request naming no tenant
+// goes to the default one. It fails with errTenantMismatch for an API key
+// used under the prefix of another tenant and with errAPIKeyRequired for a
+// request without one to an endpoint that requires one.
+func (t *tenantRoutes) resolve(c *fiber.Ctx, authenticate bool) (*routes, error) {
+	id := c.Params("tenant")
+
+	if key := c.Get(apiKeyHeader); key != "" {
+		tenant, err := t.tenants.ByAPIKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if id != "" && id != tenant.ID {
+			return nil, errTenantMismatch
+		}
+
+		id = tenant.ID
+	} else if authenticate && t.tenants.RequiresAPIKey() {
+		return nil, errAPIKeyRequired
+	}
+
+	if id == "" {
+		id = model.DefaultTenant
+	}
+
+	r, ok := t.routes[id]
+	if !ok {
+		return nil, service.ErrTenantNotFound
+	}
+
+	return r, nil
+}
@@ -0,0 +1,123 @@
+// Package metrics holds the Prometheus collectors of the service. They are
+// registered with the default registry, which httpserver exposes on /metrics.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"rsclabs-test/internal/model"
+)
+
+// ClickAccepted is the result of a counted click; a rejected click is
+// labelled with the code of its error answer.
+const ClickAccepted = "accepted"
+
+// OtherBanner labels the clicks of the banners beyond the cardinality cap.
+const OtherBanner = "other"
+
+// maxBannerLabels caps the banners labelled in the click metrics of a
+// tenant. The first banners with an accepted click are kept; the clicks of
+// any other banner are counted as OtherBanner.
+const maxBannerLabels = 100
+
+var (
+	clicks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "banner_clicks_total",
+		Help: "Clicks received per tenant, banner and result: accepted or the code of the rejection.",
+	}, []string{"tenant", "banner", "result"})
+
+	// FlushDuration observes a run of the statistics worker: rotating the
+	// counters, storing the snapshot and evicting the expired history.
+	FlushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "statistics_flush_duration_seconds",
+		Help:    "Duration of a statistics worker flush.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 8),
+	})
+
+	// DroppedSnapshots counts the rotated snapshots dropped after failing to be
+	// stored for longer than the statistics service keeps them for retry.
+	DroppedSnapshots = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "statistics_dropped_snapshots_total",
+		Help: "Snapshots dropped after failing to be stored for too long.",
+	})
+
+	lockWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_lock_wait_seconds",
+		Help:    "Time spent waiting for a storage lock.",
+		Buckets: prometheus.ExponentialBuckets(0.000001, 10, 7),
+	}, []string{"lock"})
+
+	// SnapshotLockWait observes the waits for the lock of an in-memory
+	// snapshot store.
+	SnapshotLockWait = lockWait.WithLabelValues("snapshots")
+	// DimensionLockWait observes the waits for the lock of the click
+	// dimension counters.
+	DimensionLockWait = lockWait.WithLabelValues("dimensions")
+	// GateLockWait observes the waits of the clicks held up while their minute
+	// generation is restamped. The clicks that do not wait are not observed,
+	// which keeps the click path cheap.
+	GateLockWait = lockWait.WithLabelValues("gate")
+	// RestampLockWait observes the waits of a restamp for the clicks in flight
+	// on every stripe of the gate.
+	RestampLockWait = lockWait.WithLabelValues("restamp")
+	// RotationLockWait observes the waits for the lock that serializes the
+	// rotations and the restamps of the click counters.
+	RotationLockWait = lockWait.WithLabelValues("rotation")
+)
+
+// Lock acquires the lock and observes how long it waited for it.
+func Lock(l sync.Locker, wait prometheus.Observer) {
+	start := time.Now()
+	l.Lock()
+	wait.Observe(time.Since(start).Seconds())
+}
+
+var banners = &bannerLabels{tenants: make(map[string]map[model.BannerID]struct{})}
+
+// CountClicks counts n clicks of the banner with their result.
+func CountClicks(tenant string, id model.BannerID, result string, n int) {
+	clicks.WithLabelValues(tenant, banners.label(tenant, id, result == ClickAccepted), result).Add(float64(n))
+}
+
+// bannerLabels keeps the banners labelled in the click metrics. Only an
+// accepted click admits a banner, so that clicks of made-up IDs cannot use up
+// the cap.
+type bannerLabels struct {
+	mux     sync.RWMutex
+	tenants map[string]map[model.BannerID]struct{}
+}
+
+func (b *bannerLabels) label(tenant string, id model.BannerID, admit bool) string {
+	b.mux.RLock()
+	_, known := b.tenants[tenant][id]
+	b.mux.RUnlock()
+
+	if known {
+		return string(id)
+	}
+	if !admit {
+		return OtherBanner
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	labelled, ok := b.tenants[tenant]
+	if !ok {
+		labelled = make(map[model.BannerID]struct{})
+		b.tenants[tenant] = labelled
+	}
+
+	if _, ok := labelled[id]; !ok {
+		if len(labelled) >= maxBannerLabels {
+			return OtherBanner
+		}
+		labelled[id] = struct{}{}
+	}
+
+	return string(id)
+}
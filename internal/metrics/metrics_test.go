@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"rsclabs-test/internal/model"
+)
+
+func TestCountClicksCapsBanners(t *testing.T) {
+	tenant := t.Name()
+
+	// Rejected clicks of unknown banners do not take a label
+	CountClicks(tenant, "made-up", "banner_not_found", 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(clicks.WithLabelValues(tenant, OtherBanner, "banner_not_found")))
+
+	for i := 0; i < maxBannerLabels+5; i++ {
+		CountClicks(tenant, model.BannerIDFromInt(i), ClickAccepted, 2)
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(clicks.WithLabelValues(tenant, "0", ClickAccepted)))
+	assert.Equal(t, 10.0, testutil.ToFloat64(clicks.WithLabelValues(tenant, OtherBanner, ClickAccepted)))
+
+	// A labelled banner keeps its label for rejected clicks
+	CountClicks(tenant, "1", "banner_inactive", 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(clicks.WithLabelValues(tenant, "1", "banner_inactive")))
+
+	labels := 0
+	ch := make(chan prometheus.Metric, 1000)
+	clicks.Collect(ch)
+	close(ch)
+	for m := range ch {
+		if strings.Contains(m.Desc().String(), "banner_clicks_total") {
+			labels++
+		}
+	}
+	assert.LessOrEqual(t, labels, maxBannerLabels+3)
+}
+
+func TestWatchSnapshots(t *testing.T) {
+	WatchSnapshots(t.Name(), func() []SnapshotSize {
+		return []SnapshotSize{{Granularity: "minute", Snapshots: 3, Bytes: 1024}}
+	})
+
+	expected := fmt.Sprintf(`
+# HELP statistics_snapshots Snapshots held in memory by the statistics service.
+# TYPE statistics_snapshots gauge
+statistics_snapshots{granularity="minute",tenant=%q} 3
+`, t.Name())
+	require.NoError(t, testutil.CollectAndCompare(snapshots, strings.NewReader(expected), "statistics_snapshots"))
+}
+
+func TestLockObservesWait(t *testing.T) {
+	var mux sync.Mutex
+	wait := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "wait"})
+
+	Lock(&mux, wait)
+	mux.Unlock()
+
+	var m dto.Metric
+	require.NoError(t, wait.Write(&m))
+	assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SnapshotSize is the history kept in memory at one granularity.
+type SnapshotSize struct {
+	Granularity string
+	Snapshots   int
+	Bytes       int
+}
+
+var (
+	snapshotsDesc = prometheus.NewDesc(
+		"statistics_snapshots",
+		"Snapshots held in memory by the statistics service.",
+		[]string{"tenant", "granularity"}, nil,
+	)
+	snapshotBytesDesc = prometheus.NewDesc(
+		"statistics_snapshot_bytes",
+		"Estimated memory held by the snapshots of the statistics service.",
+		[]string{"tenant", "granularity"}, nil,
+	)
+)
+
+var snapshots = newSnapshotCollector()
+
+func init() {
+	prometheus.MustRegister(snapshots)
+}
+
+// WatchSnapshots reports the sizes of the tenant's history on every scrape.
+// A later call for the same tenant replaces the earlier one.
+func WatchSnapshots(tenant string, sizes func() []SnapshotSize) {
+	snapshots.mux.Lock()
+	defer snapshots.mux.Unlock()
+
+	snapshots.sources[tenant] = sizes
+}
+
+// snapshotCollector asks the statistics services for their sizes on scrape,
+// rather than tracking every save and eviction.
+type snapshotCollector struct {
+	mux     sync.Mutex
+	sources map[string]func() []SnapshotSize
+}
+
+func newSnapshotCollector() *snapshotCollector {
+	return &snapshotCollector{sources: make(map[string]func() []SnapshotSize)}
+}
+
+func (c *snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- snapshotsDesc
+	ch <- snapshotBytesDesc
+}
+
+func (c *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for tenant, sizes := range c.sources {
+		for _, size := range sizes() {
+			ch <- prometheus.MustNewConstMetric(snapshotsDesc, prometheus.GaugeValue,
+				float64(size.Snapshots), tenant, size.Granularity)
+			ch <- prometheus.MustNewConstMetric(snapshotBytesDesc, prometheus.GaugeValue,
+				float64(size.Bytes), tenant, size.Granularity)
+		}
+	}
+}
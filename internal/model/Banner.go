@@ -5,11 +5,19 @@ import "time"
 type Banner struct {
 	TimeStamp time.Time `json:"ts"`
 	Name      string    `json:"name"`
-	BannerID  int       `json:"-"`
+	BannerID  BannerID  `json:"-"`
 	Count     int       `json:"v"`
+	// Impressions is the number of times the banner was shown.
+	Impressions int `json:"impressions"`
+	// CTR is the click-through rate, clicks per impression. It is only
+	// computed for statistics responses.
+	CTR float64 `json:"ctr"`
+	// Dimensions breaks the clicks down by click attribute. Not every click
+	// carries every attribute, so a dimension may sum up to less than Count.
+	Dimensions DimensionCounts `json:"dimensions,omitempty"`
 }
 
-func NewBanner(bannerID int, name string) Banner {
+func NewBanner(bannerID BannerID, name string) Banner {
 	return Banner{
 		BannerID:  bannerID,
 		Name:      name,
@@ -23,5 +31,5 @@ func (b *Banner) IncrementCount() {
 }
 
 func (b *Banner) IsEmpty() bool {
-	return b.Count == 0
+	return b.Count == 0 && b.Impressions == 0
 }
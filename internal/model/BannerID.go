@@ -0,0 +1,123 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// MaxBannerIDLength caps the length of a banner ID.
+const MaxBannerIDLength = 128
+
+// BannerID identifies a banner: a slug, a UUID or, in the integer
+// compatibility mode, a decimal number. Numeric IDs are encoded as JSON
+// numbers, so the integer API keeps its shape; any other ID as a string.
+type BannerID string
+
+// BannerIDFromInt returns the ID of an integer banner.
+func BannerIDFromInt(id int) BannerID {
+	return BannerID(strconv.Itoa(id))
+}
+
+// Int returns the integer value of a numeric ID.
+func (id BannerID) Int() (int, bool) {
+	if !id.isCanonicalInt() {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(string(id))
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// Less orders numeric IDs by value ahead of the other IDs, which are ordered
+// lexically.
+func (id BannerID) Less(other BannerID) bool {
+	n, numeric := id.Int()
+	m, otherNumeric := other.Int()
+
+	switch {
+	case numeric && otherNumeric:
+		return n < m
+	case numeric != otherNumeric:
+		return numeric
+	default:
+		return id < other
+	}
+}
+
+// Validate checks that the ID is non-empty, bounded and made of URL-safe
+// characters only: letters, digits and "-", "_", ".", ":".
+func (id BannerID) Validate() error {
+	if id == "" {
+		return fmt.Errorf("banner ID is empty")
+	}
+
+	if len(id) > MaxBannerIDLength {
+		return fmt.Errorf("banner ID exceeds %d characters", MaxBannerIDLength)
+	}
+
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return fmt.Errorf("banner ID contains invalid character %q", c)
+		}
+	}
+
+	return nil
+}
+
+func (id BannerID) MarshalJSON() ([]byte, error) {
+	if id.isCanonicalInt() {
+		return []byte(id), nil
+	}
+
+	return json.Marshal(string(id))
+}
+
+func (id *BannerID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*id = BannerID(s)
+		return nil
+	}
+
+	var n json.Number
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&n); err != nil {
+		return fmt.Errorf("banner ID must be a string or an integer: %w", err)
+	}
+
+	if _, err := strconv.ParseInt(string(n), 10, 64); err != nil {
+		return fmt.Errorf("banner ID must be a string or an integer: %w", err)
+	}
+	*id = BannerID(n)
+
+	return nil
+}
+
+// isCanonicalInt reports whether the ID is a decimal integer without sign or
+// leading zeros, short enough to round-trip through a JSON number.
+func (id BannerID) isCanonicalInt() bool {
+	if len(id) == 0 || len(id) > 15 || (len(id) > 1 && id[0] == '0') {
+		return false
+	}
+
+	for _, c := range []byte(id) {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
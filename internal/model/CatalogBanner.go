@@ -0,0 +1,43 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// BannerStatus is the lifecycle state of a catalog banner. Only active
+// banners count clicks and impressions.
+type BannerStatus string
+
+const (
+	BannerStatusActive  BannerStatus = "active"
+	BannerStatusPaused  BannerStatus = "paused"
+	BannerStatusRetired BannerStatus = "retired"
+)
+
+// ParseBannerStatus parses a status name; an empty name means active.
+func ParseBannerStatus(s string) (BannerStatus, error) {
+	switch BannerStatus(s) {
+	case "":
+		return BannerStatusActive, nil
+	case BannerStatusActive, BannerStatusPaused, BannerStatusRetired:
+		return BannerStatus(s), nil
+	default:
+		return "", fmt.Errorf("unknown banner status %q", s)
+	}
+}
+
+// CatalogBanner describes a banner of the catalog.
+type CatalogBanner struct {
+	ID         BannerID     `json:"id"`
+	Name       string       `json:"name"`
+	LandingURL string       `json:"landingURL,omitempty"`
+	Status     BannerStatus `json:"status"`
+	Campaign   string       `json:"campaign,omitempty"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	UpdatedAt  time.Time    `json:"updatedAt"`
+}
+
+func (b *CatalogBanner) IsActive() bool {
+	return b.Status == BannerStatusActive
+}
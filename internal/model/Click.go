@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// Click is a batch of count clicks on one banner. A zero TimeStamp means the
+// clicks happened now.
+type Click struct {
+	BannerID  BannerID  `json:"bannerID"`
+	TimeStamp time.Time `json:"ts"`
+	Count     int       `json:"count"`
+}
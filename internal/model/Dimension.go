@@ -0,0 +1,94 @@
+package model
+
+import "fmt"
+
+// Dimension is a click attribute statistics can be grouped by.
+type Dimension string
+
+const (
+	DimensionReferrer  Dimension = "referrer"
+	DimensionUserAgent Dimension = "user_agent"
+	DimensionPlacement Dimension = "placement"
+	DimensionCountry   Dimension = "country"
+)
+
+// Dimensions lists the supported dimensions.
+var Dimensions = []Dimension{
+	DimensionReferrer,
+	DimensionUserAgent,
+	DimensionPlacement,
+	DimensionCountry,
+}
+
+// OtherDimensionValue collects the values of a dimension beyond its
+// cardinality cap.
+const OtherDimensionValue = "other"
+
+// ParseDimension parses a dimension name; an empty name means no dimension.
+func ParseDimension(s string) (Dimension, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	for _, d := range Dimensions {
+		if string(d) == s {
+			return d, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown dimension %q", s)
+}
+
+// ClickAttributes are the optional attributes captured with a click. Empty
+// attributes are not recorded.
+type ClickAttributes struct {
+	Referrer  string
+	UserAgent string
+	Placement string
+	Country   string
+}
+
+// IsEmpty reports whether no attribute is set.
+func (a ClickAttributes) IsEmpty() bool {
+	return a == ClickAttributes{}
+}
+
+// Values returns the set attributes by dimension.
+func (a ClickAttributes) Values() map[Dimension]string {
+	values := make(map[Dimension]string, len(Dimensions))
+	set := func(d Dimension, v string) {
+		if v != "" {
+			values[d] = v
+		}
+	}
+
+	set(DimensionReferrer, a.Referrer)
+	set(DimensionUserAgent, a.UserAgent)
+	set(DimensionPlacement, a.Placement)
+	set(DimensionCountry, a.Country)
+
+	return values
+}
+
+// DimensionCounts holds click counts by dimension and value.
+type DimensionCounts map[Dimension]map[string]int
+
+// Add adds n clicks to the value of the dimension.
+func (c DimensionCounts) Add(d Dimension, value string, n int) {
+	values, ok := c[d]
+	if !ok {
+		values = make(map[string]int)
+		c[d] = values
+	}
+
+	values[value] += n
+}
+
+// Merge adds the counts of other.
+func (c DimensionCounts) Merge(other DimensionCounts) {
+	for d, values := range other {
+		for value, n := range values {
+			c.Add(d, value, n)
+		}
+	}
+}
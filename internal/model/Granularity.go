@@ -0,0 +1,99 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Granularity is the width of a statistics bucket. Bucket boundaries are
+// computed in UTC; weeks start on Monday.
+type Granularity string
+
+const (
+	GranularityMinute Granularity = "minute"
+	GranularityHour   Granularity = "hour"
+	GranularityDay    Granularity = "day"
+	GranularityWeek   Granularity = "week"
+	GranularityMonth  Granularity = "month"
+)
+
+// Granularities lists the supported granularities from the finest to the
+// coarsest.
+var Granularities = []Granularity{
+	GranularityMinute,
+	GranularityHour,
+	GranularityDay,
+	GranularityWeek,
+	GranularityMonth,
+}
+
+// ParseGranularity parses a granularity name; an empty name means minute.
+func ParseGranularity(s string) (Granularity, error) {
+	if s == "" {
+		return GranularityMinute, nil
+	}
+
+	for _, g := range Granularities {
+		if string(g) == s {
+			return g, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown granularity %q", s)
+}
+
+// Truncate returns the start of the bucket that contains t.
+func (g Granularity) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+
+	switch g {
+	case GranularityHour:
+		return t.Truncate(time.Hour)
+	case GranularityDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case GranularityWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return t.Truncate(time.Minute)
+	}
+}
+
+// Next returns the start of the bucket that follows the one starting at t.
+func (g Granularity) Next(t time.Time) time.Time {
+	switch g {
+	case GranularityHour:
+		return t.Add(time.Hour)
+	case GranularityDay:
+		return t.AddDate(0, 0, 1)
+	case GranularityWeek:
+		return t.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.Add(time.Minute)
+	}
+}
+
+// Divides reports whether every bucket of coarser is made of whole buckets
+// of g, so that g can be rolled up into coarser.
+func (g Granularity) Divides(coarser Granularity) bool {
+	switch g {
+	case GranularityMinute, GranularityHour, GranularityDay:
+		return g.rank() <= coarser.rank()
+	default:
+		return g == coarser
+	}
+}
+
+func (g Granularity) rank() int {
+	for i, known := range Granularities {
+		if known == g {
+			return i
+		}
+	}
+
+	return -1
+}
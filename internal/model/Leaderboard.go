@@ -0,0 +1,35 @@
+package model
+
+import "fmt"
+
+// Ranking is the order of a leaderboard.
+type Ranking string
+
+const (
+	// RankByClicks ranks the banners by clicks.
+	RankByClicks Ranking = "clicks"
+	// RankByCTR ranks the banners with impressions by click-through rate.
+	RankByCTR Ranking = "ctr"
+)
+
+// ParseRanking parses a ranking name; an empty name means clicks.
+func ParseRanking(s string) (Ranking, error) {
+	switch Ranking(s) {
+	case "", RankByClicks:
+		return RankByClicks, nil
+	case RankByCTR:
+		return RankByCTR, nil
+	default:
+		return "", fmt.Errorf("unknown ranking %q", s)
+	}
+}
+
+// LeaderboardEntry is the rank of a banner over a time window.
+type LeaderboardEntry struct {
+	Rank        int      `json:"rank"`
+	ID          BannerID `json:"id"`
+	Name        string   `json:"name"`
+	Count       int      `json:"v"`
+	Impressions int      `json:"impressions"`
+	CTR         float64  `json:"ctr"`
+}
@@ -3,11 +3,11 @@ package model
 import "time"
 
 type Snapshot struct {
-	Banners   map[int]Banner // Map of BannerID to Banner
+	Banners   map[BannerID]Banner // Map of BannerID to Banner
 	TimeStamp time.Time
 }
 
-func (s *Snapshot) FilterByBannerID(bannerID int) (Banner, bool) {
+func (s *Snapshot) FilterByBannerID(bannerID BannerID) (Banner, bool) {
 	b, ok := s.Banners[bannerID]
 	return b, ok
 }
@@ -15,3 +15,51 @@ func (s *Snapshot) FilterByBannerID(bannerID int) (Banner, bool) {
 func (s *Snapshot) IsEmpty() bool {
 	return len(s.Banners) == 0
 }
+
+// Size estimates the memory held by the snapshot, in bytes: its banners and
+// their dimension values with the overhead of their map entries. It is meant
+// for monitoring, not for accounting.
+func (s *Snapshot) Size() int {
+	const snapshotBytes, bannerBytes, valueBytes = 64, 160, 48
+
+	size := snapshotBytes
+	for id, banner := range s.Banners {
+		size += bannerBytes + len(id) + len(banner.Name)
+		for _, values := range banner.Dimensions {
+			for value := range values {
+				size += valueBytes + len(value)
+			}
+		}
+	}
+
+	return size
+}
+
+// Merge adds the banner counts of other to the snapshot. The snapshot's own
+// timestamp is kept on every merged banner.
+func (s *Snapshot) Merge(other Snapshot) {
+	if s.Banners == nil {
+		s.Banners = make(map[BannerID]Banner, len(other.Banners))
+	}
+
+	for id, banner := range other.Banners {
+		merged, ok := s.Banners[id]
+		if !ok {
+			merged = Banner{
+				BannerID: id,
+				Name:     banner.Name,
+			}
+		}
+
+		merged.Count += banner.Count
+		merged.Impressions += banner.Impressions
+		if len(banner.Dimensions) > 0 {
+			if merged.Dimensions == nil {
+				merged.Dimensions = make(DimensionCounts, len(banner.Dimensions))
+			}
+			merged.Dimensions.Merge(banner.Dimensions)
+		}
+		merged.TimeStamp = s.TimeStamp
+		s.Banners[id] = merged
+	}
+}
@@ -0,0 +1,24 @@
+package model
+
+// StatisticsQuery asks for the statistics of several banners at once: the
+// listed ones or, when All is set, every banner counted in the range. The
+// banner ID of the embedded request is ignored.
+type StatisticsQuery struct {
+	StatisticsRequest
+	BannerIDs []BannerID
+	All       bool
+}
+
+// BannerSeries is the statistics of one banner, one entry per time bucket.
+type BannerSeries struct {
+	ID    BannerID `json:"id"`
+	Name  string   `json:"name"`
+	Stats []Banner `json:"stats"`
+}
+
+// StatisticsQueryResponse holds a series per banner, ordered by ID, and the
+// series of the banners summed up.
+type StatisticsQueryResponse struct {
+	Banners   []BannerSeries `json:"banners"`
+	Aggregate []Banner       `json:"aggregate"`
+}
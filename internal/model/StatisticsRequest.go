@@ -1,7 +1,16 @@
 package model
 
 type StatisticsRequest struct {
-	From     string `json:"from"`
-	To       string `json:"to"`
-	BannerID int    `json:"banner_id"`
+	From        string      `json:"from"`
+	To          string      `json:"to"`
+	BannerID    BannerID    `json:"banner_id"`
+	Granularity Granularity `json:"granularity"`
+	GroupBy     Dimension   `json:"group_by"`
+	// FillGaps asks for a zero bucket for every bucket of the range without
+	// clicks or impressions, so that the series is dense.
+	FillGaps bool `json:"fill_gaps"`
+	// TZ is the IANA zone times without an offset are read in, and bucket
+	// timestamps are rendered in. Without it, times are read in the server's
+	// zone and buckets are rendered in UTC.
+	TZ string `json:"tz"`
 }
@@ -0,0 +1,33 @@
+package model
+
+import "fmt"
+
+// DefaultTenant is the tenant of a deployment that configures no tenants, and
+// of the requests that name none.
+const DefaultTenant = "default"
+
+// MaxTenantIDLength caps the length of a tenant ID.
+const MaxTenantIDLength = 64
+
+// ValidateTenantID checks that the tenant ID is non-empty, bounded and made of
+// lowercase letters, digits, "-" and "_" only, so it is safe in URL paths,
+// file names and log fields.
+func ValidateTenantID(id string) error {
+	if id == "" {
+		return fmt.Errorf("tenant ID is empty")
+	}
+
+	if len(id) > MaxTenantIDLength {
+		return fmt.Errorf("tenant ID exceeds %d characters", MaxTenantIDLength)
+	}
+
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return fmt.Errorf("tenant ID contains invalid character %q", c)
+		}
+	}
+
+	return nil
+}
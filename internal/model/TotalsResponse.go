@@ -0,0 +1,13 @@
+package model
+
+// TotalsResponse sums the statistics of all banners of a tenant, per time
+// bucket in Stats and over the whole range in the other fields.
+type TotalsResponse struct {
+	Tenant      string  `json:"tenant"`
+	Count       int     `json:"v"`
+	Impressions int     `json:"impressions"`
+	CTR         float64 `json:"ctr"`
+	// Banners is the number of distinct banners counted in the range.
+	Banners int      `json:"banners"`
+	Stats   []Banner `json:"stats"`
+}
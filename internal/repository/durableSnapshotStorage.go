@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository/snapshotlog"
+	"rsclabs-test/pkg/observe"
+)
+
+// SnapshotRepositoryDurable writes every snapshot to the on-disk log before
+// handing it to the wrapped store, which serves all reads.
+//
+// A rollup repository logs a bucket only once it is closed, that is once a
+// later bucket is saved: its open bucket is rewritten on every flush, and
+// logging every rewrite would append a full copy of it each minute. The open
+// bucket is lost on restart and rebuilt from the minute history, see
+// service.StatisticsService.RestoreRollups.
+type SnapshotRepositoryDurable struct {
+	log   *snapshotlog.Log
+	store SnapshotStore
+
+	rollup bool
+	mux    sync.Mutex
+	// open is the start of the buckets of a rollup not logged yet
+	open time.Time
+}
+
+// NewDurableSnapshotRepository replays the log into store and returns a
+// repository that appends new snapshots to the log.
+func NewDurableSnapshotRepository(
+	ctx context.Context,
+	log *snapshotlog.Log,
+	store SnapshotStore,
+) (*SnapshotRepositoryDurable, error) {
+	if _, err := replay(ctx, log, store); err != nil {
+		return nil, err
+	}
+
+	return &SnapshotRepositoryDurable{
+		log:   log,
+		store: store,
+	}, nil
+}
+
+// NewDurableRollupRepository replays the log into store and returns a
+// repository that appends the buckets to the log once they are closed.
+func NewDurableRollupRepository(
+	ctx context.Context,
+	log *snapshotlog.Log,
+	store SnapshotStore,
+) (*SnapshotRepositoryDurable, error) {
+	last, err := replay(ctx, log, store)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &SnapshotRepositoryDurable{
+		log:    log,
+		store:  store,
+		rollup: true,
+	}
+	if !last.IsZero() {
+		r.open = last.Add(time.Nanosecond)
+	}
+
+	return r, nil
+}
+
+// replay loads the snapshots of the log into store and returns the time of
+// the newest one.
+func replay(ctx context.Context, log *snapshotlog.Log, store SnapshotStore) (time.Time, error) {
+	var (
+		replayed []model.Snapshot
+		last     time.Time
+	)
+	if err := log.Replay(func(snapshot model.Snapshot) error {
+		replayed = append(replayed, snapshot)
+		if snapshot.TimeStamp.After(last) {
+			last = snapshot.TimeStamp
+		}
+		return nil
+	}); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := store.SaveSnapshots(ctx, replayed); err != nil {
+		return time.Time{}, fmt.Errorf("failed to load replayed snapshots: %w", err)
+	}
+
+	return last, nil
+}
+
+func (r *SnapshotRepositoryDurable) SaveSnapshots(ctx context.Context, snapshots []model.Snapshot) (err error) {
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	ctx, span := observe.StartSpan(ctx, "SnapshotRepositoryDurable.SaveSnapshots",
+		attribute.Int("snapshots", len(snapshots)))
+	defer func() { observe.EndSpan(span, err) }()
+
+	if r.rollup {
+		return r.saveBuckets(ctx, snapshots)
+	}
+
+	if err := r.log.Append(snapshots...); err != nil {
+		return err
+	}
+
+	return r.store.SaveSnapshots(ctx, snapshots)
+}
+
+// saveBuckets logs the rewrites of closed buckets right away, then logs the
+// buckets closed by the newest saved one. A bucket that fails to be logged
+// stays open, so the next save logs it again.
+func (r *SnapshotRepositoryDurable) saveBuckets(ctx context.Context, buckets []model.Snapshot) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var (
+		late   []model.Snapshot
+		newest time.Time
+	)
+	for _, bucket := range buckets {
+		if bucket.TimeStamp.Before(r.open) {
+			late = append(late, bucket)
+		}
+		if bucket.TimeStamp.After(newest) {
+			newest = bucket.TimeStamp
+		}
+	}
+
+	if len(late) > 0 {
+		if err := r.log.Append(late...); err != nil {
+			return err
+		}
+	}
+
+	if err := r.store.SaveSnapshots(ctx, buckets); err != nil {
+		return err
+	}
+
+	if !newest.After(r.open) {
+		return nil
+	}
+
+	closed, err := r.store.GetSnapshots(ctx, r.open, newest.Add(-time.Nanosecond))
+	if err != nil {
+		return err
+	}
+	if len(closed) > 0 {
+		if err := r.log.Append(closed...); err != nil {
+			return err
+		}
+	}
+	r.open = newest
+
+	return nil
+}
+
+func (r *SnapshotRepositoryDurable) GetSnapshots(ctx context.Context, from, to time.Time) ([]model.Snapshot, error) {
+	return r.store.GetSnapshots(ctx, from, to)
+}
+
+func (r *SnapshotRepositoryDurable) GetLastSnapshotTime(ctx context.Context) (time.Time, error) {
+	return r.store.GetLastSnapshotTime(ctx)
+}
+
+// Size returns the size of the wrapped store, or zeros for a store that does
+// not hold its snapshots in memory.
+func (r *SnapshotRepositoryDurable) Size() (snapshots, bytes int) {
+	if sizer, ok := r.store.(SnapshotSizer); ok {
+		return sizer.Size()
+	}
+
+	return 0, 0
+}
+
+// EvictSnapshots evicts from the wrapped store and drops the log segments that
+// hold only evicted snapshots, so a restart does not replay them.
+func (r *SnapshotRepositoryDurable) EvictSnapshots(ctx context.Context, before time.Time, keep int) (_ int, err error) {
+	ctx, span := observe.StartSpan(ctx, "SnapshotRepositoryDurable.EvictSnapshots")
+	defer func() { observe.EndSpan(span, err) }()
+
+	removed, err := r.store.EvictSnapshots(ctx, before, keep)
+	if err != nil {
+		return removed, err
+	}
+
+	if _, err := r.log.Compact(before, keep); err != nil {
+		return removed, err
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository/snapshotlog"
+)
+
+func TestDurableSnapshotRepositoryReplay(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+
+	log, err := snapshotlog.Open(dir, snapshotlog.Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	repo, err := NewDurableSnapshotRepository(ctx, log, NewInMemorySnapshotRepository())
+	if err != nil {
+		t.Fatalf("NewDurableSnapshotRepository failed: %v", err)
+	}
+
+	err = repo.SaveSnapshots(ctx, []model.Snapshot{{
+		TimeStamp: base,
+		Banners:   map[model.BannerID]model.Banner{"0": {BannerID: "0", Name: "Banner 0", Count: 4}},
+	}})
+	if err != nil {
+		t.Fatalf("SaveSnapshots failed: %v", err)
+	}
+	log.Close()
+
+	// Simulate a restart: a fresh in-memory store is rebuilt from the log
+	log, err = snapshotlog.Open(dir, snapshotlog.Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer log.Close()
+
+	repo, err = NewDurableSnapshotRepository(ctx, log, NewInMemorySnapshotRepository())
+	if err != nil {
+		t.Fatalf("NewDurableSnapshotRepository failed: %v", err)
+	}
+
+	got, err := repo.GetSnapshots(ctx, base, base)
+	if err != nil {
+		t.Fatalf("GetSnapshots failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Banners["0"].Count != 4 {
+		t.Errorf("Expected replayed snapshot with count 4, got %+v", got)
+	}
+}
+
+func TestDurableRollupRepositoryLogsClosedBuckets(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+
+	bucket := func(ts time.Time, count int) []model.Snapshot {
+		return []model.Snapshot{{
+			TimeStamp: ts,
+			Banners:   map[model.BannerID]model.Banner{"1": {BannerID: "1", Name: "Banner 1", Count: count}},
+		}}
+	}
+
+	log, err := snapshotlog.Open(dir, snapshotlog.Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	repo, err := NewDurableRollupRepository(ctx, log, NewInMemorySnapshotRepository())
+	if err != nil {
+		t.Fatalf("NewDurableRollupRepository failed: %v", err)
+	}
+
+	// The open bucket is rewritten on every flush
+	for count := 1; count <= 3; count++ {
+		if err := repo.SaveSnapshots(ctx, bucket(base, count)); err != nil {
+			t.Fatalf("SaveSnapshots failed: %v", err)
+		}
+	}
+	repo.SaveSnapshots(ctx, bucket(base.Add(time.Hour), 1))
+	repo.SaveSnapshots(ctx, bucket(base.Add(time.Hour), 2))
+	// A late rewrite of the closed bucket
+	repo.SaveSnapshots(ctx, bucket(base, 4))
+
+	records := 0
+	log.Replay(func(model.Snapshot) error {
+		records++
+		return nil
+	})
+	if records != 2 {
+		t.Errorf("Expected the closed bucket and its late rewrite to be logged, got %d records", records)
+	}
+	log.Close()
+
+	log, err = snapshotlog.Open(dir, snapshotlog.Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer log.Close()
+
+	repo, err = NewDurableRollupRepository(ctx, log, NewInMemorySnapshotRepository())
+	if err != nil {
+		t.Fatalf("NewDurableRollupRepository failed: %v", err)
+	}
+
+	got, err := repo.GetSnapshots(ctx, time.Time{}, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetSnapshots failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Banners["1"].Count != 4 {
+		t.Errorf("Expected only the closed bucket with its late rewrite, got %+v", got)
+	}
+
+	// The bucket open at the restart is logged once a later one is saved
+	repo.SaveSnapshots(ctx, bucket(base.Add(time.Hour), 2))
+	repo.SaveSnapshots(ctx, bucket(base.Add(2*time.Hour), 1))
+
+	records = 0
+	log.Replay(func(model.Snapshot) error {
+		records++
+		return nil
+	})
+	if records != 3 {
+		t.Errorf("Expected the bucket closed after the restart to be logged, got %d records", records)
+	}
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"rsclabs-test/internal/model"
+)
+
+// CatalogRepositoryFile keeps the banner catalog in memory and, when a path is
+// set, persists it as a JSON file. Every change rewrites the file through a
+// temporary file and a rename, so a crash never leaves a half-written catalog.
+type CatalogRepositoryFile struct {
+	mux     sync.Mutex
+	path    string
+	banners map[model.BannerID]model.CatalogBanner
+}
+
+// NewCatalogRepository loads the catalog from path; a missing file is an empty
+// catalog. An empty path keeps the catalog in memory only.
+func NewCatalogRepository(path string) (*CatalogRepositoryFile, error) {
+	r := &CatalogRepositoryFile{
+		path:    path,
+		banners: make(map[model.BannerID]model.CatalogBanner),
+	}
+
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read banner catalog: %w", err)
+	}
+
+	var banners []model.CatalogBanner
+	if err := json.Unmarshal(data, &banners); err != nil {
+		return nil, fmt.Errorf("failed to decode banner catalog: %w", err)
+	}
+
+	for _, banner := range banners {
+		r.banners[banner.ID] = banner
+	}
+
+	return r, nil
+}
+
+// ListBanners returns the catalog ordered by ID.
+func (r *CatalogRepositoryFile) ListBanners(_ context.Context) ([]model.CatalogBanner, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.sorted(), nil
+}
+
+// SaveBanner creates or replaces the banner with the same ID.
+func (r *CatalogRepositoryFile) SaveBanner(_ context.Context, banner model.CatalogBanner) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	previous, existed := r.banners[banner.ID]
+	r.banners[banner.ID] = banner
+
+	if err := r.persist(); err != nil {
+		if existed {
+			r.banners[banner.ID] = previous
+		} else {
+			delete(r.banners, banner.ID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *CatalogRepositoryFile) sorted() []model.CatalogBanner {
+	banners := make([]model.CatalogBanner, 0, len(r.banners))
+	for _, banner := range r.banners {
+		banners = append(banners, banner)
+	}
+
+	sort.Slice(banners, func(i, j int) bool {
+		return banners[i].ID.Less(banners[j].ID)
+	})
+
+	return banners
+}
+
+func (r *CatalogRepositoryFile) persist() error {
+	if r.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode banner catalog: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create banner catalog file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write banner catalog: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync banner catalog: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close banner catalog file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return fmt.Errorf("failed to replace banner catalog: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"rsclabs-test/internal/model"
+)
+
+func TestCatalogRepositoryPersists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.json")
+
+	repo, err := NewCatalogRepository(path)
+	if err != nil {
+		t.Fatalf("NewCatalogRepository failed: %v", err)
+	}
+
+	for _, banner := range []model.CatalogBanner{
+		{ID: "10", Name: "Summer sale", Status: model.BannerStatusActive},
+		{ID: "black-friday", Name: "Black Friday", Status: model.BannerStatusActive},
+		{ID: "9", Name: "Winter sale", Status: model.BannerStatusPaused},
+		{ID: "10", Name: "Summer sale 2", Status: model.BannerStatusActive},
+	} {
+		if err := repo.SaveBanner(ctx, banner); err != nil {
+			t.Fatalf("SaveBanner failed: %v", err)
+		}
+	}
+
+	repo, err = NewCatalogRepository(path)
+	if err != nil {
+		t.Fatalf("NewCatalogRepository (reload) failed: %v", err)
+	}
+
+	banners, err := repo.ListBanners(ctx)
+	if err != nil {
+		t.Fatalf("ListBanners failed: %v", err)
+	}
+
+	if len(banners) != 3 {
+		t.Fatalf("Expected 3 banners, got %d", len(banners))
+	}
+	if banners[0].ID != "9" || banners[0].Status != model.BannerStatusPaused {
+		t.Errorf("Unexpected first banner: %+v", banners[0])
+	}
+	if banners[1].Name != "Summer sale 2" {
+		t.Errorf("Expected the replaced banner name, got %q", banners[1].Name)
+	}
+	if banners[2].ID != "black-friday" {
+		t.Errorf("Expected string IDs after the numeric ones, got %q", banners[2].ID)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the catalog file to remain, got %d entries", len(entries))
+	}
+}
+
+func TestCatalogRepositoryRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatalf("failed to write catalog: %v", err)
+	}
+
+	if _, err := NewCatalogRepository(path); err == nil {
+		t.Errorf("Expected an error for a corrupt catalog")
+	}
+}
@@ -1,11 +1,14 @@
 package repository
 
 import (
+	"fmt"
 	"rsclabs-test/internal/model"
 	"rsclabs-test/internal/repository/inmemorystorage"
 	"time"
 )
 
+// BannerRepositoryInMemory counts the integer banner IDs 1..MaxBanners, the
+// compatibility mode of the service.
 type BannerRepositoryInMemory struct {
 	storage    *inmemorystorage.InMemoryStorage
 	MaxBanners int
@@ -18,8 +21,40 @@ func NewBannerRepository(storage *inmemorystorage.InMemoryStorage) (*BannerRepos
 	}, nil
 }
 
-func (r *BannerRepositoryInMemory) RegisterClick(id int) error {
-	return r.storage.IncrementCountTakeTimestamp(id)
+func (r *BannerRepositoryInMemory) RegisterClick(id model.BannerID) error {
+	bid, err := r.index(id)
+	if err != nil {
+		return err
+	}
+
+	return r.storage.IncrementCountTakeTimestamp(bid)
+}
+
+// RegisterImpression counts an impression of the banner.
+func (r *BannerRepositoryInMemory) RegisterImpression(id model.BannerID) error {
+	bid, err := r.index(id)
+	if err != nil {
+		return err
+	}
+
+	return r.storage.IncrementImpression(bid)
+}
+
+// RegisterClickWithAttributes counts a click together with the attributes it
+// was made with.
+func (r *BannerRepositoryInMemory) RegisterClickWithAttributes(id model.BannerID, attrs model.ClickAttributes) error {
+	bid, err := r.index(id)
+	if err != nil {
+		return err
+	}
+
+	return r.storage.IncrementWithAttributes(bid, attrs)
+}
+
+// RegisterClicks applies a batch of clicks at once and returns one error per
+// click, nil for the registered ones.
+func (r *BannerRepositoryInMemory) RegisterClicks(clicks []model.Click) []error {
+	return r.storage.IncrementBatch(clicks)
 }
 
 func (r *BannerRepositoryInMemory) GetCountSnapshot() model.Snapshot {
@@ -29,6 +64,13 @@ func (r *BannerRepositoryInMemory) GetCountSnapshot() model.Snapshot {
 	}
 }
 
+// RotateCounts returns the counters collected so far, one snapshot per
+// wall-clock minute stamped with the minute start, and resets them atomically,
+// so no click is lost between the snapshot and the reset.
+func (r *BannerRepositoryInMemory) RotateCounts() []model.Snapshot {
+	return r.storage.Rotate()
+}
+
 func (r *BannerRepositoryInMemory) ZeroOutCounts() {
 	r.storage.ClearCount()
 }
@@ -36,3 +78,26 @@ func (r *BannerRepositoryInMemory) ZeroOutCounts() {
 func (r *BannerRepositoryInMemory) GetValues() []model.Banner {
 	return r.storage.GetNotZeroValues()
 }
+
+// ValidateID accepts the integer IDs 1..MaxBanners.
+func (r *BannerRepositoryInMemory) ValidateID(id model.BannerID) error {
+	_, err := r.index(id)
+	return err
+}
+
+func (r *BannerRepositoryInMemory) GetMaxBanners() int {
+	return r.MaxBanners
+}
+
+func (r *BannerRepositoryInMemory) index(id model.BannerID) (int, error) {
+	bid, ok := id.Int()
+	if !ok {
+		return 0, fmt.Errorf("%w: %q is not an integer", ErrInvalidBannerID, id)
+	}
+
+	if bid < 1 || bid > r.MaxBanners {
+		return 0, fmt.Errorf("%w: %d is not between 1 and %d", ErrBannerIDOutOfRange, bid, r.MaxBanners)
+	}
+
+	return bid, nil
+}
@@ -1,13 +1,16 @@
 package repository
 
 import (
+	"errors"
 	"testing"
+	"time"
 
+	"rsclabs-test/internal/model"
 	"rsclabs-test/internal/repository/inmemorystorage"
 )
 
 func setupTestRepository() *BannerRepositoryInMemory {
-	storage := inmemorystorage.NewInMemoryStorage(100, nil) // Set max capacity to 100
+	storage := inmemorystorage.NewInMemoryStorage(100, 100, nil) // Set max capacity to 100
 	repo, _ := NewBannerRepository(storage)
 	return repo
 }
@@ -15,14 +18,14 @@ func setupTestRepository() *BannerRepositoryInMemory {
 func TestRegisterClick(t *testing.T) {
 	repo := setupTestRepository()
 
-	err := repo.RegisterClick(1)
+	err := repo.RegisterClick("1")
 	if err != nil {
 		t.Errorf("RegisterClick failed: %v", err)
 	}
 
 	// Test registering multiple clicks
 	for i := 0; i < 5; i++ {
-		err = repo.RegisterClick(1)
+		err = repo.RegisterClick("1")
 		if err != nil {
 			t.Errorf("RegisterClick failed on iteration %d: %v", i, err)
 		}
@@ -31,7 +34,7 @@ func TestRegisterClick(t *testing.T) {
 	snapshot := repo.GetCountSnapshot()
 	found := false
 	for _, banner := range snapshot.Banners {
-		if banner.BannerID == 1 {
+		if banner.BannerID == "1" {
 			found = true
 			if banner.Count != 6 { // 1 initial + 5 additional clicks
 				t.Errorf("Expected count 6, got %d", banner.Count)
@@ -47,9 +50,9 @@ func TestRegisterClick(t *testing.T) {
 func TestGetCountSnapshot(t *testing.T) {
 	repo := setupTestRepository()
 
-	repo.RegisterClick(1)
-	repo.RegisterClick(2)
-	repo.RegisterClick(1)
+	repo.RegisterClick("1")
+	repo.RegisterClick("2")
+	repo.RegisterClick("1")
 
 	snapshot := repo.GetCountSnapshot()
 
@@ -57,25 +60,25 @@ func TestGetCountSnapshot(t *testing.T) {
 		t.Error("Snapshot timestamp is zero")
 	}
 
-	counts := make(map[int]int)
+	counts := make(map[model.BannerID]int)
 	for _, banner := range snapshot.Banners {
 		counts[banner.BannerID] = banner.Count
 	}
 
-	if counts[1] != 2 {
-		t.Errorf("Expected count 2 for banner 1, got %d", counts[1])
+	if counts["1"] != 2 {
+		t.Errorf("Expected count 2 for banner 1, got %d", counts["1"])
 	}
-	if counts[2] != 1 {
-		t.Errorf("Expected count 1 for banner 2, got %d", counts[2])
+	if counts["2"] != 1 {
+		t.Errorf("Expected count 1 for banner 2, got %d", counts["2"])
 	}
 }
 
 func TestZeroOutCounts(t *testing.T) {
 	repo := setupTestRepository()
 
-	repo.RegisterClick(1)
-	repo.RegisterClick(2)
-	repo.RegisterClick(1)
+	repo.RegisterClick("1")
+	repo.RegisterClick("2")
+	repo.RegisterClick("1")
 
 	// Zero out counts
 	repo.ZeroOutCounts()
@@ -84,7 +87,7 @@ func TestZeroOutCounts(t *testing.T) {
 	snapshot := repo.GetCountSnapshot()
 	for _, banner := range snapshot.Banners {
 		if banner.Count != 0 {
-			t.Errorf("Expected count 0 for banner %d, got %d", banner.BannerID, banner.Count)
+			t.Errorf("Expected count 0 for banner %s, got %d", banner.BannerID, banner.Count)
 		}
 	}
 }
@@ -93,9 +96,9 @@ func TestGetValues(t *testing.T) {
 	repo := setupTestRepository()
 
 	// Register clicks for some banners
-	repo.RegisterClick(1)
-	repo.RegisterClick(2)
-	repo.RegisterClick(1)
+	repo.RegisterClick("1")
+	repo.RegisterClick("2")
+	repo.RegisterClick("1")
 
 	// Get non-zero values
 	values := repo.GetValues()
@@ -106,16 +109,16 @@ func TestGetValues(t *testing.T) {
 	}
 
 	// Verify the counts
-	counts := make(map[int]int)
+	counts := make(map[model.BannerID]int)
 	for _, banner := range values {
 		counts[banner.BannerID] = banner.Count
 	}
 
-	if counts[1] != 2 {
-		t.Errorf("Expected count 2 for banner 1, got %d", counts[1])
+	if counts["1"] != 2 {
+		t.Errorf("Expected count 2 for banner 1, got %d", counts["1"])
 	}
-	if counts[2] != 1 {
-		t.Errorf("Expected count 1 for banner 2, got %d", counts[2])
+	if counts["2"] != 1 {
+		t.Errorf("Expected count 1 for banner 2, got %d", counts["2"])
 	}
 }
 
@@ -130,7 +133,7 @@ func TestConcurrentClicks(t *testing.T) {
 	for i := 0; i < goroutines; i++ {
 		go func() {
 			for j := 0; j < iterations; j++ {
-				repo.RegisterClick(1)
+				repo.RegisterClick("1")
 			}
 			done <- true
 		}()
@@ -143,7 +146,7 @@ func TestConcurrentClicks(t *testing.T) {
 	snapshot := repo.GetCountSnapshot()
 	found := false
 	for _, banner := range snapshot.Banners {
-		if banner.BannerID == 1 {
+		if banner.BannerID == "1" {
 			found = true
 			expectedCount := goroutines * iterations
 			if banner.Count != expectedCount {
@@ -156,3 +159,66 @@ func TestConcurrentClicks(t *testing.T) {
 		t.Error("Banner not found in snapshot")
 	}
 }
+
+func TestRotateCounts(t *testing.T) {
+	repo := setupTestRepository()
+
+	repo.RegisterClick("1")
+	repo.RegisterClick("1")
+	repo.RegisterClick("3")
+
+	counts := rotatedCounts(t, repo)
+	if counts["1"] != 2 {
+		t.Errorf("Expected count 2 for banner 1, got %d", counts["1"])
+	}
+	if counts["3"] != 1 {
+		t.Errorf("Expected count 1 for banner 3, got %d", counts["3"])
+	}
+
+	// Counters must start from zero after the rotation
+	if values := repo.GetValues(); len(values) != 0 {
+		t.Errorf("Expected no non-zero banners after rotation, got %d", len(values))
+	}
+
+	repo.RegisterClick("1")
+	if counts := rotatedCounts(t, repo); counts["1"] != 1 {
+		t.Errorf("Expected count 1 for banner 1 after rotation, got %d", counts["1"])
+	}
+}
+
+// rotatedCounts sums the rotated snapshots per banner. Clicks may span a
+// minute boundary, so more than one snapshot can come back.
+func rotatedCounts(t *testing.T, repo *BannerRepositoryInMemory) map[model.BannerID]int {
+	counts := make(map[model.BannerID]int)
+	for _, snapshot := range repo.RotateCounts() {
+		if snapshot.TimeStamp.Truncate(time.Minute) != snapshot.TimeStamp {
+			t.Errorf("Snapshot timestamp %v is not aligned to a minute", snapshot.TimeStamp)
+		}
+		for id, banner := range snapshot.Banners {
+			counts[id] += banner.Count
+		}
+	}
+
+	return counts
+}
+
+func TestIntegerModeValidatesIDs(t *testing.T) {
+	repo := setupTestRepository()
+
+	for _, id := range []model.BannerID{"summer-sale", "01", "-1", ""} {
+		if err := repo.RegisterClick(id); !errors.Is(err, ErrInvalidBannerID) {
+			t.Errorf("Expected ErrInvalidBannerID for %q, got %v", id, err)
+		}
+	}
+
+	for _, id := range []model.BannerID{"0", "101"} {
+		if err := repo.RegisterClick(id); !errors.Is(err, ErrBannerIDOutOfRange) {
+			t.Errorf("Expected ErrBannerIDOutOfRange for %q, got %v", id, err)
+		}
+	}
+
+	errs := repo.RegisterClicks([]model.Click{{BannerID: "100", Count: 1}, {BannerID: "slug", Count: 1}})
+	if errs[0] != nil || !errors.Is(errs[1], ErrInvalidBannerID) {
+		t.Errorf("Unexpected batch results: %v", errs)
+	}
+}
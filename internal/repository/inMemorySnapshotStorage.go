@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"rsclabs-test/internal/metrics"
+	"rsclabs-test/internal/model"
+	"rsclabs-test/pkg/observe"
+)
+
+type SnapshotRepositoryInMemory struct {
+	mux       sync.RWMutex
+	snapshots []model.Snapshot
+	// bytes is the estimated size of the snapshots, kept up to date so that
+	// reading it does not walk the history
+	bytes int
+}
+
+func NewInMemorySnapshotRepository() *SnapshotRepositoryInMemory {
+	return &SnapshotRepositoryInMemory{
+		snapshots: make([]model.Snapshot, 0),
+	}
+}
+
+// SaveSnapshots inserts the snapshots into the time-ordered history. A
+// snapshot with the same timestamp as a stored one replaces it.
+func (r *SnapshotRepositoryInMemory) SaveSnapshots(ctx context.Context, snapshots []model.Snapshot) error {
+	_, span := observe.StartSpan(ctx, "SnapshotRepositoryInMemory.SaveSnapshots",
+		attribute.Int("snapshots", len(snapshots)))
+	defer span.End()
+
+	metrics.Lock(&r.mux, metrics.SnapshotLockWait)
+	defer r.mux.Unlock()
+
+	for _, snapshot := range snapshots {
+		i := r.search(snapshot.TimeStamp)
+		r.bytes += snapshot.Size()
+
+		switch {
+		case i < len(r.snapshots) && r.snapshots[i].TimeStamp.Equal(snapshot.TimeStamp):
+			r.bytes -= r.snapshots[i].Size()
+			r.snapshots[i] = snapshot
+		case i == len(r.snapshots):
+			r.snapshots = append(r.snapshots, snapshot)
+		default:
+			r.snapshots = slices.Insert(r.snapshots, i, snapshot)
+		}
+	}
+
+	return nil
+}
+
+// GetSnapshots returns a copy of the snapshots taken in [from, to]. The history
+// is ordered by time, so the range is located by binary search.
+func (r *SnapshotRepositoryInMemory) GetSnapshots(ctx context.Context, from, to time.Time) ([]model.Snapshot, error) {
+	_, span := observe.StartSpan(ctx, "SnapshotRepositoryInMemory.GetSnapshots", spanRange(from, to)...)
+	defer span.End()
+
+	metrics.Lock(r.mux.RLocker(), metrics.SnapshotLockWait)
+	defer r.mux.RUnlock()
+
+	start := r.search(from)
+	end := sort.Search(len(r.snapshots), func(i int) bool {
+		return r.snapshots[i].TimeStamp.After(to)
+	})
+
+	if start >= end {
+		return nil, nil
+	}
+
+	out := make([]model.Snapshot, end-start)
+	copy(out, r.snapshots[start:end])
+	span.SetAttributes(attribute.Int("snapshots", len(out)))
+
+	return out, nil
+}
+
+func (r *SnapshotRepositoryInMemory) GetLastSnapshotTime(_ context.Context) (time.Time, error) {
+	metrics.Lock(r.mux.RLocker(), metrics.SnapshotLockWait)
+	defer r.mux.RUnlock()
+
+	if len(r.snapshots) == 0 {
+		return time.Time{}, nil
+	}
+
+	return r.snapshots[len(r.snapshots)-1].TimeStamp, nil
+}
+
+func (r *SnapshotRepositoryInMemory) EvictSnapshots(ctx context.Context, before time.Time, keep int) (int, error) {
+	_, span := observe.StartSpan(ctx, "SnapshotRepositoryInMemory.EvictSnapshots")
+	defer span.End()
+
+	metrics.Lock(&r.mux, metrics.SnapshotLockWait)
+	defer r.mux.Unlock()
+
+	start := r.search(before)
+	if keep > 0 && len(r.snapshots)-start > keep {
+		start = len(r.snapshots) - keep
+	}
+
+	if start == 0 {
+		return 0, nil
+	}
+
+	for _, snapshot := range r.snapshots[:start] {
+		r.bytes -= snapshot.Size()
+	}
+
+	// Copy the retained tail so the evicted snapshots can be collected
+	retained := make([]model.Snapshot, len(r.snapshots)-start)
+	copy(retained, r.snapshots[start:])
+	r.snapshots = retained
+	span.SetAttributes(attribute.Int("evicted", start))
+
+	return start, nil
+}
+
+// Size returns the number of snapshots held and their estimated size.
+func (r *SnapshotRepositoryInMemory) Size() (snapshots, bytes int) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	return len(r.snapshots), r.bytes
+}
+
+// search returns the index of the first snapshot taken at or after ts.
+func (r *SnapshotRepositoryInMemory) search(ts time.Time) int {
+	return sort.Search(len(r.snapshots), func(i int) bool {
+		return !r.snapshots[i].TimeStamp.Before(ts)
+	})
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"rsclabs-test/internal/model"
+)
+
+func TestInMemorySnapshotRepositoryRange(t *testing.T) {
+	repo := NewInMemorySnapshotRepository()
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		err := repo.SaveSnapshots(ctx, []model.Snapshot{{
+			TimeStamp: base.Add(time.Duration(i) * time.Minute),
+			Banners:   map[model.BannerID]model.Banner{"0": {BannerID: "0", Count: i + 1}},
+		}})
+		if err != nil {
+			t.Fatalf("SaveSnapshots failed: %v", err)
+		}
+	}
+
+	got, err := repo.GetSnapshots(ctx, base.Add(time.Minute), base.Add(3*time.Minute))
+	if err != nil {
+		t.Fatalf("GetSnapshots failed: %v", err)
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 snapshots, got %d", len(got))
+	}
+	if got[0].Banners["0"].Count != 2 || got[2].Banners["0"].Count != 4 {
+		t.Errorf("Unexpected snapshots returned: %+v", got)
+	}
+}
+
+func TestInMemorySnapshotRepositoryKeepsOrder(t *testing.T) {
+	repo := NewInMemorySnapshotRepository()
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+	repo.SaveSnapshots(ctx, []model.Snapshot{{TimeStamp: base.Add(time.Hour)}})
+	repo.SaveSnapshots(ctx, []model.Snapshot{{TimeStamp: base}, {TimeStamp: base.Add(time.Minute)}})
+
+	last, err := repo.GetLastSnapshotTime(ctx)
+	if err != nil {
+		t.Fatalf("GetLastSnapshotTime failed: %v", err)
+	}
+	if !last.Equal(base.Add(time.Hour)) {
+		t.Errorf("Expected last snapshot time %v, got %v", base.Add(time.Hour), last)
+	}
+
+	got, _ := repo.GetSnapshots(ctx, time.Time{}, base.Add(2*time.Hour))
+	for i := 1; i < len(got); i++ {
+		if got[i].TimeStamp.Before(got[i-1].TimeStamp) {
+			t.Errorf("Snapshots are not ordered by time: %v before %v", got[i].TimeStamp, got[i-1].TimeStamp)
+		}
+	}
+}
+
+func TestInMemorySnapshotRepositorySize(t *testing.T) {
+	repo := NewInMemorySnapshotRepository()
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+	snapshot := func(ts time.Time, banners int) model.Snapshot {
+		s := model.Snapshot{TimeStamp: ts, Banners: make(map[model.BannerID]model.Banner)}
+		for i := 0; i < banners; i++ {
+			id := model.BannerIDFromInt(i)
+			s.Banners[id] = model.Banner{BannerID: id, Count: 1}
+		}
+		return s
+	}
+
+	small, large := snapshot(base, 1), snapshot(base, 10)
+	repo.SaveSnapshots(ctx, []model.Snapshot{small, snapshot(base.Add(time.Minute), 1)})
+
+	count, bytes := repo.Size()
+	if count != 2 || bytes != 2*small.Size() {
+		t.Fatalf("Expected 2 snapshots of %d bytes, got %d of %d bytes", 2*small.Size(), count, bytes)
+	}
+
+	// A replaced snapshot no longer counts
+	repo.SaveSnapshots(ctx, []model.Snapshot{large})
+	count, bytes = repo.Size()
+	if count != 2 || bytes != small.Size()+large.Size() {
+		t.Fatalf("Expected 2 snapshots of %d bytes, got %d of %d bytes", small.Size()+large.Size(), count, bytes)
+	}
+
+	repo.EvictSnapshots(ctx, base.Add(time.Minute), 0)
+	count, bytes = repo.Size()
+	if count != 1 || bytes != small.Size() {
+		t.Errorf("Expected 1 snapshot of %d bytes, got %d of %d bytes", small.Size(), count, bytes)
+	}
+}
+
+func TestInMemorySnapshotRepositoryEvictSnapshots(t *testing.T) {
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		before  time.Time
+		keep    int
+		evicted int
+	}{
+		{"nothing to evict", time.Time{}, 0, 0},
+		{"age only", base.Add(4 * time.Minute), 0, 4},
+		{"age between snapshots", base.Add(4*time.Minute + 30*time.Second), 0, 5},
+		{"age before the first snapshot", base.Add(-time.Minute), 0, 0},
+		{"age at the first snapshot", base, 0, 0},
+		{"age at the last snapshot", base.Add(9 * time.Minute), 0, 9},
+		{"age after the last snapshot", base.Add(10 * time.Minute), 0, 10},
+		{"count only", time.Time{}, 3, 7},
+		{"count above the size", time.Time{}, 20, 0},
+		{"count equal to the size", time.Time{}, 10, 0},
+		{"count stricter than age", base.Add(2 * time.Minute), 5, 5},
+		{"age stricter than count", base.Add(8 * time.Minute), 5, 8},
+		{"age and count agree", base.Add(5 * time.Minute), 5, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewInMemorySnapshotRepository()
+			ctx := context.Background()
+
+			for i := 0; i < 10; i++ {
+				repo.SaveSnapshots(ctx, []model.Snapshot{{
+					TimeStamp: base.Add(time.Duration(i) * time.Minute),
+					Banners:   map[model.BannerID]model.Banner{"1": {BannerID: "1", Count: 1}},
+				}})
+			}
+
+			evicted, err := repo.EvictSnapshots(ctx, tt.before, tt.keep)
+			if err != nil {
+				t.Fatalf("EvictSnapshots failed: %v", err)
+			}
+			if evicted != tt.evicted {
+				t.Errorf("Expected %d evicted snapshots, got %d", tt.evicted, evicted)
+			}
+
+			got, _ := repo.GetSnapshots(ctx, time.Time{}, base.Add(time.Hour))
+			if len(got) != 10-tt.evicted {
+				t.Fatalf("Expected %d snapshots left, got %d", 10-tt.evicted, len(got))
+			}
+			if len(got) > 0 && !got[0].TimeStamp.Equal(base.Add(time.Duration(tt.evicted)*time.Minute)) {
+				t.Errorf("Expected the oldest snapshots to be evicted, first left is %v", got[0].TimeStamp)
+			}
+
+			if count, _ := repo.Size(); count != len(got) {
+				t.Errorf("Expected a size of %d snapshots, got %d", len(got), count)
+			}
+		})
+	}
+}
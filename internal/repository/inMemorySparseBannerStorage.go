@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository/inmemorystorage"
+)
+
+// BannerRepositorySparse counts banners with arbitrary string IDs, such as
+// slugs or UUIDs. Counters are created on demand and evicted once idle.
+type BannerRepositorySparse struct {
+	storage *inmemorystorage.SparseStorage
+}
+
+func NewSparseBannerRepository(storage *inmemorystorage.SparseStorage) *BannerRepositorySparse {
+	return &BannerRepositorySparse{storage: storage}
+}
+
+func (r *BannerRepositorySparse) RegisterClick(id model.BannerID) error {
+	return r.storage.IncrementCountTakeTimestamp(id)
+}
+
+// RegisterImpression counts an impression of the banner.
+func (r *BannerRepositorySparse) RegisterImpression(id model.BannerID) error {
+	return r.storage.IncrementImpression(id)
+}
+
+// RegisterClickWithAttributes counts a click together with the attributes it
+// was made with.
+func (r *BannerRepositorySparse) RegisterClickWithAttributes(id model.BannerID, attrs model.ClickAttributes) error {
+	return r.storage.IncrementWithAttributes(id, attrs)
+}
+
+// RegisterClicks applies a batch of clicks at once and returns one error per
+// click, nil for the registered ones.
+func (r *BannerRepositorySparse) RegisterClicks(clicks []model.Click) []error {
+	return r.storage.IncrementBatch(clicks)
+}
+
+func (r *BannerRepositorySparse) GetCountSnapshot() model.Snapshot {
+	return model.Snapshot{
+		Banners:   r.storage.GetSnapshot(),
+		TimeStamp: time.Now(),
+	}
+}
+
+// RotateCounts returns the counters collected so far, one snapshot per
+// wall-clock minute, resets them and evicts the idle banners.
+func (r *BannerRepositorySparse) RotateCounts() []model.Snapshot {
+	return r.storage.Rotate()
+}
+
+func (r *BannerRepositorySparse) ZeroOutCounts() {
+	r.storage.ClearCount()
+}
+
+func (r *BannerRepositorySparse) GetValues() []model.Banner {
+	return r.storage.GetNotZeroValues()
+}
+
+// ValidateID accepts any well-formed ID, see model.BannerID.Validate.
+func (r *BannerRepositorySparse) ValidateID(id model.BannerID) error {
+	if err := id.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBannerID, err)
+	}
+
+	return nil
+}
+
+// LiveBanners returns the number of banners currently holding counters.
+func (r *BannerRepositorySparse) LiveBanners() int {
+	return r.storage.LiveBanners()
+}
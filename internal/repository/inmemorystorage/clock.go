@@ -0,0 +1,51 @@
+package inmemorystorage
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minuteClock publishes the current Unix minute so that the click path does
+// not have to call time.Now(). A timer advances it on every minute boundary.
+type minuteClock struct {
+	minute  atomic.Int64
+	mux     sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+func newMinuteClock() *minuteClock {
+	c := &minuteClock{}
+	c.tick()
+
+	return c
+}
+
+func (c *minuteClock) Minute() int64 {
+	return c.minute.Load()
+}
+
+// Stop freezes the clock at its current minute.
+func (c *minuteClock) Stop() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.stopped = true
+	c.timer.Stop()
+}
+
+func (c *minuteClock) tick() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.stopped {
+		return
+	}
+
+	now := time.Now()
+	c.minute.Store(now.Unix() / 60)
+
+	next := now.Truncate(time.Minute).Add(time.Minute)
+	c.timer = time.AfterFunc(next.Sub(now), c.tick)
+}
@@ -0,0 +1,112 @@
+package inmemorystorage
+
+import (
+	"sync"
+
+	"rsclabs-test/internal/metrics"
+	"rsclabs-test/internal/model"
+)
+
+// dimensionCounter counts the clicks of one minute by click attribute.
+// Attributed clicks are a fraction of the traffic, so a mutex is enough here.
+type dimensionCounter struct {
+	mux    sync.Mutex
+	counts map[model.BannerID]model.DimensionCounts
+}
+
+func (c *dimensionCounter) add(id model.BannerID, values map[model.Dimension]string) {
+	metrics.Lock(&c.mux, metrics.DimensionLockWait)
+	defer c.mux.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[model.BannerID]model.DimensionCounts)
+	}
+
+	counts, ok := c.counts[id]
+	if !ok {
+		counts = make(model.DimensionCounts, len(values))
+		c.counts[id] = counts
+	}
+
+	for d, value := range values {
+		counts.Add(d, value, 1)
+	}
+}
+
+// swap returns the counts collected so far and starts over.
+func (c *dimensionCounter) swap() map[model.BannerID]model.DimensionCounts {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	counts := c.counts
+	c.counts = nil
+
+	return counts
+}
+
+// dimensionRegistry caps the cardinality of every dimension: the first
+// maxValues distinct values seen since the last rotation are kept, later ones
+// are folded into model.OtherDimensionValue; maxValues <= 0 disables the cap.
+// This bounds the memory held by any snapshot regardless of the traffic, while
+// a value first seen once the cap is reached gets its own count again from
+// the next rotation on.
+type dimensionRegistry struct {
+	mux       sync.RWMutex
+	maxValues int
+	values    map[model.Dimension]map[string]struct{}
+}
+
+func newDimensionRegistry(maxValues int) *dimensionRegistry {
+	return &dimensionRegistry{
+		maxValues: maxValues,
+		values:    make(map[model.Dimension]map[string]struct{}),
+	}
+}
+
+// capped replaces the values beyond the cardinality cap in place.
+func (r *dimensionRegistry) capped(values map[model.Dimension]string) map[model.Dimension]string {
+	for d, value := range values {
+		values[d] = r.value(d, value)
+	}
+
+	return values
+}
+
+func (r *dimensionRegistry) value(d model.Dimension, value string) string {
+	r.mux.RLock()
+	_, known := r.values[d][value]
+	r.mux.RUnlock()
+
+	if known {
+		return value
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	seen, ok := r.values[d]
+	if !ok {
+		seen = make(map[string]struct{})
+		r.values[d] = seen
+	}
+
+	if _, ok := seen[value]; ok {
+		return value
+	}
+
+	if r.maxValues > 0 && len(seen) >= r.maxValues {
+		return model.OtherDimensionValue
+	}
+
+	seen[value] = struct{}{}
+
+	return value
+}
+
+// reset forgets the values seen, starting a new rotation window.
+func (r *dimensionRegistry) reset() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.values = make(map[model.Dimension]map[string]struct{})
+}
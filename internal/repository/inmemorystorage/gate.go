@@ -0,0 +1,105 @@
+package inmemorystorage
+
+import (
+	"math/bits"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rsclabs-test/internal/metrics"
+	"rsclabs-test/internal/model"
+)
+
+// stripe is padded to a full cache line, like counter.
+type stripe struct {
+	mux sync.RWMutex
+	_   [cacheLineSize - 24]byte
+}
+
+// minuteGate stamps a generation of counters with the minute it counts. A
+// count holds one stripe of the gate for reading while it checks the stamp
+// and adds; restamping holds every stripe, so it waits for the counts in
+// flight and no count of the old minute can land once the stamp has moved
+// on. Counts pick a stripe at random, so concurrent clicks rarely share one.
+type minuteGate struct {
+	minute  atomic.Int64
+	stripes []stripe
+}
+
+func newMinuteGate(stripes int) minuteGate {
+	return minuteGate{stripes: make([]stripe, stripes)}
+}
+
+// stripeCount is the number of stripes and shards: GOMAXPROCS rounded up to
+// a power of two, so that a random one is picked with a mask.
+func stripeCount() int {
+	return 1 << bits.Len(uint(runtime.GOMAXPROCS(0)-1))
+}
+
+// enter holds stripe i for reading if the generation is stamped with the
+// minute. The caller counts, then leaves.
+func (g *minuteGate) enter(i uint32, minute int64) bool {
+	st := &g.stripes[i]
+
+	if !st.mux.TryRLock() {
+		metrics.Lock(st.mux.RLocker(), metrics.GateLockWait)
+	}
+	if g.minute.Load() == minute {
+		return true
+	}
+	st.mux.RUnlock()
+
+	return false
+}
+
+func (g *minuteGate) leave(i uint32) {
+	g.stripes[i].mux.RUnlock()
+}
+
+// restamp stamps the generation with the minute. The counts it still holds
+// belong to the old stamp, so spill takes them out first, with every stripe
+// held.
+func (g *minuteGate) restamp(minute int64, spill func(old int64)) {
+	start := time.Now()
+	for i := range g.stripes {
+		g.stripes[i].mux.Lock()
+	}
+	metrics.RestampLockWait.Observe(time.Since(start).Seconds())
+
+	defer func() {
+		for i := range g.stripes {
+			g.stripes[i].mux.Unlock()
+		}
+	}()
+
+	if old := g.minute.Load(); old != minute {
+		spill(old)
+		g.minute.Store(minute)
+	}
+}
+
+// mergeMinutes merges the snapshots of the same minute and sorts them, oldest
+// first.
+func mergeMinutes(snapshots []model.Snapshot) []model.Snapshot {
+	var result []model.Snapshot
+
+	index := make(map[int64]int, len(snapshots))
+	for _, snapshot := range snapshots {
+		i, ok := index[snapshot.TimeStamp.Unix()]
+		if !ok {
+			index[snapshot.TimeStamp.Unix()] = len(result)
+			result = append(result, snapshot)
+			continue
+		}
+
+		result[i].Merge(snapshot)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].TimeStamp.Before(result[j].TimeStamp)
+	})
+
+	return result
+}
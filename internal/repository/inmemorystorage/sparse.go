@@ -0,0 +1,393 @@
+package inmemorystorage
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rsclabs-test/internal/metrics"
+	"rsclabs-test/internal/model"
+	"rsclabs-test/pkg/observe"
+)
+
+// ErrTooManyBanners is returned for the first click of a banner while the
+// sparse storage already counts its maximum number of banners.
+var ErrTooManyBanners = errors.New("too many banners counted")
+
+// evicted marks the counters of a banner removed from the sparse storage.
+const evicted = -1
+
+// sparseCounters are the counters of one banner, one per generation.
+type sparseCounters struct {
+	// lastMinute is the latest minute the banner was counted in, or evicted
+	lastMinute  atomic.Int64
+	clicks      [2]atomic.Int64
+	impressions [2]atomic.Int64
+}
+
+// touch records that the banner is counted in the minute. It fails once the
+// counters are evicted, so that no click lands in counters nobody rotates.
+func (c *sparseCounters) touch(minute int64) bool {
+	for {
+		last := c.lastMinute.Load()
+		switch {
+		case last == evicted:
+			return false
+		case last >= minute:
+			return true
+		case c.lastMinute.CompareAndSwap(last, minute):
+			return true
+		}
+	}
+}
+
+func (c *sparseCounters) load() totals {
+	var t totals
+	for g := range c.clicks {
+		t.clicks += c.clicks[g].Load()
+		t.impressions += c.impressions[g].Load()
+	}
+
+	return t
+}
+
+func (c *sparseCounters) swap(g int) totals {
+	return totals{
+		clicks:      c.clicks[g].Swap(0),
+		impressions: c.impressions[g].Swap(0),
+	}
+}
+
+// SparseStorage counts the clicks of banners with arbitrary string IDs. The
+// counters of a banner are created on its first click and evicted once it has
+// been idle for the idle timeout, so the memory is bounded by the number of
+// recently clicked banners, at most maxBanners, rather than by the ID space.
+//
+// Like InMemoryStorage, clicks are bucketed by minute into two alternating
+// generations, and a generation left behind by a late rotation is spilled
+// before it counts a newer minute; a click takes the read lock of a random
+// gate stripe on top of its atomic add. The counters of a banner are not sharded:
+// that keeps a banner at about a hundred bytes, at the cost of contention on
+// very hot banners.
+type SparseStorage struct {
+	maxBanners  int
+	idleMinutes int64
+	banners     sync.Map // model.BannerID -> *sparseCounters
+	live        atomic.Int64
+	gates       [2]minuteGate
+	stripeMask  uint32
+	dims        [2]dimensionCounter
+	dimensions  *dimensionRegistry
+	clock       *minuteClock
+	l           *observe.Logger
+
+	// mux serializes the rotations and the restamping of the generations
+	mux     sync.Mutex
+	spilled []model.Snapshot
+}
+
+// NewSparseStorage creates a storage counting at most maxBanners banners at a
+// time. A banner is evicted after idleTimeout without clicks or impressions;
+// the timeout is at least two minutes, so the pending minutes are never
+// evicted. Every click dimension keeps at most maxDimensionValues distinct
+// values.
+func NewSparseStorage(maxBanners int, idleTimeout time.Duration, maxDimensionValues int, l *observe.Logger) *SparseStorage {
+	stripes := stripeCount()
+
+	return &SparseStorage{
+		maxBanners:  maxBanners,
+		idleMinutes: max(int64(idleTimeout/time.Minute), 2),
+		gates:       [2]minuteGate{newMinuteGate(stripes), newMinuteGate(stripes)},
+		stripeMask:  uint32(stripes - 1),
+		dimensions:  newDimensionRegistry(maxDimensionValues),
+		clock:       newMinuteClock(),
+		l:           l,
+	}
+}
+
+func (s *SparseStorage) IncrementCountTakeTimestamp(id model.BannerID) error {
+	return s.increment(id, s.clock.Minute(), 1, false, nil)
+}
+
+// IncrementImpression counts an impression of the banner.
+func (s *SparseStorage) IncrementImpression(id model.BannerID) error {
+	return s.increment(id, s.clock.Minute(), 1, true, nil)
+}
+
+// IncrementWithAttributes counts a click together with its attributes.
+func (s *SparseStorage) IncrementWithAttributes(id model.BannerID, attrs model.ClickAttributes) error {
+	var values map[model.Dimension]string
+	if !attrs.IsEmpty() {
+		values = s.dimensions.capped(attrs.Values())
+	}
+
+	return s.increment(id, s.clock.Minute(), 1, false, values)
+}
+
+// IncrementBatch applies a batch of clicks in one pass and returns one error
+// per click, nil for the applied ones. A click must fall into the current or
+// the previous minute.
+func (s *SparseStorage) IncrementBatch(clicks []model.Click) []error {
+	errs := make([]error, len(clicks))
+
+	current := s.clock.Minute()
+	for i, click := range clicks {
+		minute, err := clickMinute(click, current)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		errs[i] = s.increment(click.BannerID, minute, int64(click.Count), false, nil)
+	}
+
+	return errs
+}
+
+// GetSnapshot returns the non-empty banners counted so far, summed over all
+// pending minutes.
+func (s *SparseStorage) GetSnapshot() map[model.BannerID]model.Banner {
+	result := make(map[model.BannerID]model.Banner)
+
+	now := time.Now()
+	spilled := s.spilledTotals()
+	s.banners.Range(func(key, value any) bool {
+		id := key.(model.BannerID)
+		if t := value.(*sparseCounters).load().plus(spilled[id]); !t.isZero() {
+			result[id] = sparseBanner(id, t, now)
+		}
+		delete(spilled, id)
+		return true
+	})
+
+	// The banners evicted since their counts were spilled
+	for id, t := range spilled {
+		if !t.isZero() {
+			result[id] = sparseBanner(id, t, now)
+		}
+	}
+
+	return result
+}
+
+func (s *SparseStorage) GetNotZeroValues() []model.Banner {
+	var result []model.Banner
+	for _, banner := range s.GetSnapshot() {
+		result = append(result, banner)
+	}
+
+	return result
+}
+
+func (s *SparseStorage) ClearCount() {
+	metrics.Lock(&s.mux, metrics.RotationLockWait)
+	defer s.mux.Unlock()
+
+	s.spilled = nil
+	for g := range s.dims {
+		s.dims[g].swap()
+	}
+
+	s.banners.Range(func(_, value any) bool {
+		for g := range s.gates {
+			value.(*sparseCounters).swap(g)
+		}
+		return true
+	})
+}
+
+// Rotate returns one snapshot per minute with clicks or impressions, oldest
+// first, and resets the counters, like InMemoryStorage.Rotate. It then evicts
+// the banners idle for longer than the idle timeout.
+func (s *SparseStorage) Rotate() []model.Snapshot {
+	metrics.Lock(&s.mux, metrics.RotationLockWait)
+	defer s.mux.Unlock()
+
+	result := s.spilled
+	s.spilled = nil
+
+	for g := range s.gates {
+		if snapshot, ok := s.collect(g, s.gates[g].minute.Load()); ok {
+			result = append(result, snapshot)
+		}
+	}
+	s.dimensions.reset()
+
+	s.evict(s.clock.Minute())
+
+	return mergeMinutes(result)
+}
+
+// collect swaps the counters of generation g with zero and returns them as
+// the snapshot of the minute, if any.
+func (s *SparseStorage) collect(g int, minute int64) (model.Snapshot, bool) {
+	start := time.Unix(minute*60, 0).UTC()
+
+	// The dimensions are recorded after the counter and rotated out before it,
+	// so the attributes of a click are never rotated out ahead of the click
+	dims := s.dims[g].swap()
+
+	banners := make(map[model.BannerID]model.Banner)
+	s.banners.Range(func(key, value any) bool {
+		id := key.(model.BannerID)
+		if t := value.(*sparseCounters).swap(g); !t.isZero() {
+			banners[id] = sparseBanner(id, t, start)
+		}
+		return true
+	})
+
+	// The attributes may belong to a click counted in the previous rotation
+	for id, counts := range dims {
+		banner, ok := banners[id]
+		if !ok {
+			banner = sparseBanner(id, totals{}, start)
+		}
+		banner.Dimensions = counts
+		banners[id] = banner
+	}
+
+	if len(banners) == 0 {
+		return model.Snapshot{}, false
+	}
+
+	return model.Snapshot{Banners: banners, TimeStamp: start}, true
+}
+
+// LiveBanners returns the number of banners currently holding counters.
+func (s *SparseStorage) LiveBanners() int {
+	return int(s.live.Load())
+}
+
+func (s *SparseStorage) GetMaxCapacity() int {
+	return s.maxBanners
+}
+
+// increment counts in the generation of the minute, restamping it first if it
+// still holds an older minute, like InMemoryStorage.addWithDimensions.
+func (s *SparseStorage) increment(id model.BannerID, minute int64, n int64, impression bool, values map[model.Dimension]string) error {
+	if err := id.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBannerID, err)
+	}
+
+	c, err := s.counters(id, minute)
+	if err != nil {
+		return err
+	}
+
+	g := minute & 1
+	j := rand.Uint32() & s.stripeMask
+
+	for !s.gates[g].enter(j, minute) {
+		s.restamp(int(g), minute)
+	}
+	defer s.gates[g].leave(j)
+
+	if impression {
+		c.impressions[g].Add(n)
+	} else {
+		c.clicks[g].Add(n)
+	}
+
+	if values != nil {
+		s.dims[g].add(id, values)
+	}
+
+	return nil
+}
+
+// restamp moves generation g to the minute, spilling the counts of its
+// previous minute.
+func (s *SparseStorage) restamp(g int, minute int64) {
+	metrics.Lock(&s.mux, metrics.RotationLockWait)
+	defer s.mux.Unlock()
+
+	s.gates[g].restamp(minute, func(old int64) {
+		if snapshot, ok := s.collect(g, old); ok {
+			s.spilled = append(s.spilled, snapshot)
+		}
+	})
+}
+
+// spilledTotals sums the counts spilled by the generations and not rotated
+// out yet.
+func (s *SparseStorage) spilledTotals() map[model.BannerID]totals {
+	metrics.Lock(&s.mux, metrics.RotationLockWait)
+	defer s.mux.Unlock()
+
+	return sumSnapshots(s.spilled)
+}
+
+// counters returns the counters of the banner touched for the minute,
+// creating them on the first click.
+func (s *SparseStorage) counters(id model.BannerID, minute int64) (*sparseCounters, error) {
+	for {
+		value, ok := s.banners.Load(id)
+		if !ok {
+			if s.live.Add(1) > int64(s.maxBanners) {
+				s.live.Add(-1)
+				return nil, fmt.Errorf("%w: at most %d banners", ErrTooManyBanners, s.maxBanners)
+			}
+
+			created := &sparseCounters{}
+			created.lastMinute.Store(minute)
+
+			value, ok = s.banners.LoadOrStore(id, created)
+			if !ok {
+				return created, nil
+			}
+			s.live.Add(-1)
+		}
+
+		c := value.(*sparseCounters)
+		if c.touch(minute) {
+			return c, nil
+		}
+
+		// Evicted in the meantime: drop the stale entry and start over
+		if s.banners.CompareAndDelete(id, c) {
+			s.live.Add(-1)
+		}
+	}
+}
+
+// evict removes the banners idle for longer than the idle timeout. It runs
+// right after a rotation, so their counters are empty. A banner is marked
+// evicted before it is removed, so a concurrent click either keeps it alive
+// or goes to new counters.
+func (s *SparseStorage) evict(current int64) {
+	threshold := current - s.idleMinutes
+
+	removed := 0
+	s.banners.Range(func(key, value any) bool {
+		c := value.(*sparseCounters)
+
+		last := c.lastMinute.Load()
+		if last == evicted || last >= threshold || !c.lastMinute.CompareAndSwap(last, evicted) {
+			return true
+		}
+
+		if s.banners.CompareAndDelete(key, c) {
+			s.live.Add(-1)
+			removed++
+		}
+
+		return true
+	})
+
+	if removed > 0 && s.l != nil {
+		s.l.Debug("evicted idle banners", map[string]any{"evicted": removed, "live": s.live.Load()})
+	}
+}
+
+func sparseBanner(id model.BannerID, t totals, ts time.Time) model.Banner {
+	return model.Banner{
+		TimeStamp:   ts,
+		Name:        string(id),
+		BannerID:    id,
+		Count:       int(t.clicks),
+		Impressions: int(t.impressions),
+	}
+}
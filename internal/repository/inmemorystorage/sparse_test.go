@@ -0,0 +1,148 @@
+package inmemorystorage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"rsclabs-test/internal/model"
+)
+
+func TestSparseStorageCountsStringIDs(t *testing.T) {
+	storage := NewSparseStorage(10, time.Hour, 100, nil)
+	storage.clock.Stop()
+
+	for i := 0; i < 3; i++ {
+		if err := storage.IncrementCountTakeTimestamp("summer-sale"); err != nil {
+			t.Fatalf("IncrementCountTakeTimestamp failed: %v", err)
+		}
+	}
+	storage.IncrementImpression("3f2a9c1e-7b4d-4e0a-9d51-0c8e2b7f6a13")
+	storage.IncrementWithAttributes("summer-sale", model.ClickAttributes{Country: "DE"})
+
+	for _, id := range []model.BannerID{"", "with space", "a/b"} {
+		if err := storage.IncrementCountTakeTimestamp(id); !errors.Is(err, ErrInvalidBannerID) {
+			t.Errorf("Expected ErrInvalidBannerID for %q, got %v", id, err)
+		}
+	}
+
+	snapshots := storage.Rotate()
+	if len(snapshots) != 1 {
+		t.Fatalf("Expected 1 snapshot, got %d", len(snapshots))
+	}
+
+	sale := snapshots[0].Banners["summer-sale"]
+	if sale.Count != 4 || sale.BannerID != "summer-sale" || sale.Dimensions[model.DimensionCountry]["DE"] != 1 {
+		t.Errorf("Unexpected summer-sale banner: %+v", sale)
+	}
+	if got := snapshots[0].Banners["3f2a9c1e-7b4d-4e0a-9d51-0c8e2b7f6a13"].Impressions; got != 1 {
+		t.Errorf("Expected 1 impression, got %d", got)
+	}
+	if storage.LiveBanners() != 2 {
+		t.Errorf("Expected 2 live banners, got %d", storage.LiveBanners())
+	}
+}
+
+func TestSparseStorageEvictsIdleBanners(t *testing.T) {
+	storage := NewSparseStorage(2, 5*time.Minute, 100, nil)
+	storage.clock.Stop()
+
+	base := time.Date(2025, 6, 6, 1, 30, 0, 0, time.UTC).Unix() / 60
+	storage.clock.minute.Store(base)
+
+	storage.IncrementCountTakeTimestamp("idle")
+	storage.IncrementCountTakeTimestamp("busy")
+
+	if err := storage.IncrementCountTakeTimestamp("third"); !errors.Is(err, ErrTooManyBanners) {
+		t.Fatalf("Expected ErrTooManyBanners, got %v", err)
+	}
+
+	for minute := base + 1; minute <= base+6; minute++ {
+		storage.clock.minute.Store(minute)
+		storage.IncrementCountTakeTimestamp("busy")
+		storage.Rotate()
+	}
+
+	if storage.LiveBanners() != 1 {
+		t.Fatalf("Expected the idle banner to be evicted, got %d live banners", storage.LiveBanners())
+	}
+
+	// The evicted banner starts over with fresh counters
+	if err := storage.IncrementCountTakeTimestamp("idle"); err != nil {
+		t.Fatalf("Expected a click on an evicted banner to be counted, got %v", err)
+	}
+
+	total := 0
+	for _, snapshot := range storage.Rotate() {
+		total += snapshot.Banners["idle"].Count
+	}
+	if total != 1 {
+		t.Errorf("Expected 1 click on the evicted banner, got %d", total)
+	}
+}
+
+func TestSparseStorageConcurrentEviction(t *testing.T) {
+	storage := NewSparseStorage(1000, 0, 100, nil)
+	storage.clock.Stop()
+
+	// Every banner is clicked once long ago, so it is due for eviction while
+	// it is clicked again
+	base := time.Now().Unix() / 60
+	storage.clock.minute.Store(base - 10)
+	for i := 0; i < 50; i++ {
+		storage.IncrementCountTakeTimestamp(model.BannerID(fmt.Sprintf("banner-%d", i)))
+	}
+	total := 0
+	for _, snapshot := range storage.Rotate() {
+		for _, banner := range snapshot.Banners {
+			total += banner.Count
+		}
+	}
+	storage.clock.minute.Store(base)
+
+	goroutines := 8
+	iterations := 5000
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				id := model.BannerID(fmt.Sprintf("banner-%d", (g+j)%50))
+				if err := storage.IncrementCountTakeTimestamp(id); err != nil {
+					t.Errorf("IncrementCountTakeTimestamp failed: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		for _, snapshot := range storage.Rotate() {
+			for _, banner := range snapshot.Banners {
+				total += banner.Count
+			}
+		}
+	}
+
+	if want := 50 + goroutines*iterations; total != want {
+		t.Errorf("Expected %d clicks across rotations, got %d", want, total)
+	}
+	if storage.LiveBanners() != 50 {
+		t.Errorf("Expected 50 live banners, got %d", storage.LiveBanners())
+	}
+}
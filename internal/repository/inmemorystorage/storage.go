@@ -1,114 +1,261 @@
 package inmemorystorage
 
 import (
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"rsclabs-test/internal/metrics"
 	"rsclabs-test/internal/model"
 	"rsclabs-test/pkg/observe"
 )
 
+const cacheLineSize = 64
+
+var (
+	// ErrClickOutsideWindow is returned for a click timestamped before the
+	// previous minute or after the current one.
+	ErrClickOutsideWindow = errors.New("click timestamp is outside the accepted window")
+	// ErrInvalidBannerID is returned for a banner ID the storage cannot count.
+	ErrInvalidBannerID = errors.New("invalid banner ID")
+)
+
+// counter is padded to a full cache line so that neighbouring banners never
+// share a line between cores.
+type counter struct {
+	n atomic.Int64
+	_ [cacheLineSize - 8]byte
+}
+
+// shard holds one counter per banner for clicks and one for impressions. They
+// are kept apart so that clicks and impressions do not contend either.
+type shard struct {
+	clicks      []counter
+	impressions []counter
+}
+
+// generation holds the counters of one wall-clock minute, stamped by its
+// gate.
+type generation struct {
+	gate   minuteGate
+	shards []shard
+	dims   dimensionCounter
+}
+
+// InMemoryStorage counts the clicks of the integer banner IDs 1..maxCapacity
+// with atomic counters and no global lock. Every banner has one atomic
+// counter per shard; a click picks a random shard, so concurrent clicks on the
+// same banner rarely contend on one cache line. Reads sum the shards.
+//
+// Clicks are bucketed by the wall-clock minute they happen in. Two generations
+// of counters alternate between even and odd minutes, which leaves the
+// previous minute untouched until it is rotated out. A generation still
+// holding the counts of an older minute when a click of a newer one arrives,
+// because a rotation came late, is spilled into a pending snapshot first.
+//
+// The click path is therefore not strictly lock-free: besides its atomic add,
+// a click takes the read lock of the gate stripe of its shard (see
+// minuteGate), two more atomic operations on a cache line shared by the
+// clicks that picked the same shard. It blocks only while its generation is
+// restamped, about once a minute for the time it takes to swap the counters.
 type InMemoryStorage struct {
-	mux          sync.RWMutex
-	maxCapacity  int
-	values       map[int]*model.Banner
-	minTimestamp time.Time
-	maxTimestamp time.Time
-	totalCount   int
-	l            *observe.Logger
+	maxCapacity int
+	ids         []model.BannerID
+	names       []string
+	generations [2]generation
+	shardMask   uint32
+	dimensions  *dimensionRegistry
+	clock       *minuteClock
+	l           *observe.Logger
+
+	// mux serializes the rotations and the restamping of the generations
+	mux     sync.Mutex
+	spilled []model.Snapshot
 }
 
-func NewInMemoryStorage(maxCapacity int, l *observe.Logger) *InMemoryStorage {
-	values := make(map[int]*model.Banner, maxCapacity)
+// NewInMemoryStorage creates the counters for the banners 1..maxCapacity.
+// Every click dimension keeps at most maxDimensionValues distinct values.
+func NewInMemoryStorage(maxCapacity, maxDimensionValues int, l *observe.Logger) *InMemoryStorage {
+	shardCount := stripeCount()
+
 	storage := InMemoryStorage{
-		values:      values,
 		maxCapacity: maxCapacity,
+		ids:         make([]model.BannerID, maxCapacity),
+		names:       make([]string, maxCapacity),
+		shardMask:   uint32(shardCount - 1),
+		dimensions:  newDimensionRegistry(maxDimensionValues),
+		clock:       newMinuteClock(),
 		l:           l,
 	}
 
+	for g := range storage.generations {
+		storage.generations[g].gate = newMinuteGate(shardCount)
+		storage.generations[g].shards = make([]shard, shardCount)
+		for i := range storage.generations[g].shards {
+			storage.generations[g].shards[i].clicks = make([]counter, maxCapacity)
+			storage.generations[g].shards[i].impressions = make([]counter, maxCapacity)
+		}
+	}
+
 	storage.seedBanners()
 
 	return &storage
 }
 
-func (s *InMemoryStorage) GetSnapshot() map[int]model.Banner {
-	s.mux.RLock()
-	defer s.mux.RUnlock()
+// GetSnapshot returns the non-empty banners counted so far, summed over all
+// pending minutes.
+func (s *InMemoryStorage) GetSnapshot() map[model.BannerID]model.Banner {
+	result := make(map[model.BannerID]model.Banner)
 
-	result := make(map[int]model.Banner)
-
-	for _, banner := range s.values {
-		if !banner.IsEmpty() {
-			result[banner.BannerID] = model.Banner{
-				TimeStamp: banner.TimeStamp,
-				Name:      banner.Name,
-				BannerID:  banner.BannerID,
-				Count:     banner.Count,
-			}
+	now := time.Now()
+	spilled := s.spilledTotals()
+	for i := 0; i < s.maxCapacity; i++ {
+		if t := s.load(i).plus(spilled[s.ids[i]]); !t.isZero() {
+			result[s.ids[i]] = s.banner(i, t, now)
 		}
 	}
 
 	return result
 }
 
-func (s *InMemoryStorage) Set(index int, value model.Banner) bool {
-	s.mux.Lock()
-	defer s.mux.Unlock()
-
-	if index < 0 || index >= len(s.values) {
-		return false
+func (s *InMemoryStorage) IncrementCountTakeTimestamp(id int) error {
+	if id < 1 || id > s.maxCapacity {
+		return fmt.Errorf("%w: %d", ErrInvalidBannerID, id)
 	}
 
-	s.values[index] = &value
+	s.add(clicksOf, id-1, s.clock.Minute(), 1)
 
-	return true
+	return nil
 }
 
-func (s *InMemoryStorage) IncrementCountTakeTimestamp(id int) error {
-	s.mux.Lock()
-	defer s.mux.Unlock()
+// IncrementImpression counts an impression of the banner.
+func (s *InMemoryStorage) IncrementImpression(id int) error {
+	if id < 1 || id > s.maxCapacity {
+		return fmt.Errorf("%w: %d", ErrInvalidBannerID, id)
+	}
 
-	if id < 0 || id >= s.maxCapacity {
-		return fmt.Errorf("invalid index: %d", id)
+	s.add(impressionsOf, id-1, s.clock.Minute(), 1)
+
+	return nil
+}
+
+// IncrementWithAttributes counts a click together with its attributes.
+func (s *InMemoryStorage) IncrementWithAttributes(id int, attrs model.ClickAttributes) error {
+	if id < 1 || id > s.maxCapacity {
+		return fmt.Errorf("%w: %d", ErrInvalidBannerID, id)
 	}
-	s.values[id].IncrementCount()
 
-	t := time.Now()
-	s.values[id].TimeStamp = t
-	if s.totalCount == 0 {
-		s.minTimestamp = t
-	} else {
-		s.maxTimestamp = t
+	var values map[model.Dimension]string
+	if !attrs.IsEmpty() {
+		values = s.dimensions.capped(attrs.Values())
 	}
 
-	s.totalCount += 1
+	s.addWithDimensions(clicksOf, id-1, s.clock.Minute(), 1, values)
 
 	return nil
 }
 
+// IncrementBatch applies a batch of clicks in one pass and returns one error
+// per click, nil for the applied ones. A click must fall into the current or
+// the previous minute: older minutes may already have been rotated out.
+func (s *InMemoryStorage) IncrementBatch(clicks []model.Click) []error {
+	errs := make([]error, len(clicks))
+
+	current := s.clock.Minute()
+	for i, click := range clicks {
+		id, ok := click.BannerID.Int()
+		if !ok || id < 1 || id > s.maxCapacity {
+			errs[i] = fmt.Errorf("%w: %s", ErrInvalidBannerID, click.BannerID)
+			continue
+		}
+
+		minute, err := clickMinute(click, current)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		s.add(clicksOf, id-1, minute, int64(click.Count))
+	}
+
+	return errs
+}
+
 func (s *InMemoryStorage) ClearCount() {
-	s.mux.Lock()
+	metrics.Lock(&s.mux, metrics.RotationLockWait)
 	defer s.mux.Unlock()
 
-	s.values = make(map[int]*model.Banner, s.maxCapacity)
-	s.seedBanners()
+	s.spilled = nil
+	for g := range s.generations {
+		s.generations[g].dims.swap()
+		for i := 0; i < s.maxCapacity; i++ {
+			s.generations[g].swap(i)
+		}
+	}
 }
 
-func (s *InMemoryStorage) GetNotZeroValues() []model.Banner {
-	s.mux.RLock()
-	defer s.mux.RUnlock()
+// Rotate returns one snapshot per minute with clicks or impressions, oldest
+// first, stamped with the start of that minute, and resets the counters. Each
+// shard counter is swapped with zero atomically, so every click lands either
+// in the returned snapshots or in the next rotation.
+func (s *InMemoryStorage) Rotate() []model.Snapshot {
+	metrics.Lock(&s.mux, metrics.RotationLockWait)
+	defer s.mux.Unlock()
+
+	result := s.spilled
+	s.spilled = nil
+
+	for g := range s.generations {
+		gen := &s.generations[g]
+		if snapshot, ok := s.collect(gen, gen.gate.minute.Load()); ok {
+			result = append(result, snapshot)
+		}
+	}
+	s.dimensions.reset()
+
+	return mergeMinutes(result)
+}
+
+// collect swaps the counters of the generation with zero and returns them as
+// the snapshot of the minute, if any.
+func (s *InMemoryStorage) collect(gen *generation, minute int64) (model.Snapshot, bool) {
+	start := time.Unix(minute*60, 0).UTC()
+
+	// The dimensions are recorded after the counter and rotated out before it,
+	// so the attributes of a click are never rotated out ahead of the click
+	dims := gen.dims.swap()
+
+	banners := make(map[model.BannerID]model.Banner)
+	for i := 0; i < s.maxCapacity; i++ {
+		id := s.ids[i]
+		t := gen.swap(i)
+		if t.isZero() && dims[id] == nil {
+			continue
+		}
+
+		banner := s.banner(i, t, start)
+		banner.Dimensions = dims[id]
+		banners[id] = banner
+	}
 
+	if len(banners) == 0 {
+		return model.Snapshot{}, false
+	}
+
+	return model.Snapshot{Banners: banners, TimeStamp: start}, true
+}
+
+func (s *InMemoryStorage) GetNotZeroValues() []model.Banner {
 	var result []model.Banner
-	for _, v := range s.values {
-		if !v.IsEmpty() {
-			banner := model.Banner{
-				TimeStamp: v.TimeStamp,
-				Name:      v.Name,
-				BannerID:  v.BannerID,
-				Count:     v.Count,
-			}
-			result = append(result, banner)
+
+	now := time.Now()
+	spilled := s.spilledTotals()
+	for i := 0; i < s.maxCapacity; i++ {
+		if t := s.load(i).plus(spilled[s.ids[i]]); !t.isZero() {
+			result = append(result, s.banner(i, t, now))
 		}
 	}
 
@@ -118,13 +265,140 @@ func (s *InMemoryStorage) GetNotZeroValues() []model.Banner {
 func (s *InMemoryStorage) GetMaxCapacity() int {
 	return s.maxCapacity
 }
+
 func (s *InMemoryStorage) seedBanners() {
 	for i := 0; i < s.maxCapacity; i++ {
-		s.values[i] = &model.Banner{
-			TimeStamp: time.Now(),
-			Name:      fmt.Sprintf("Banner %d", i),
-			BannerID:  i,
-			Count:     0,
+		s.ids[i] = model.BannerIDFromInt(i + 1)
+		s.names[i] = fmt.Sprintf("Banner %d", i+1)
+	}
+}
+
+// totals are the counts of one banner.
+type totals struct {
+	clicks      int64
+	impressions int64
+}
+
+func (t totals) isZero() bool {
+	return t.clicks == 0 && t.impressions == 0
+}
+
+func (t totals) plus(other totals) totals {
+	return totals{
+		clicks:      t.clicks + other.clicks,
+		impressions: t.impressions + other.impressions,
+	}
+}
+
+// sumSnapshots sums the counts of every banner over the snapshots.
+func sumSnapshots(snapshots []model.Snapshot) map[model.BannerID]totals {
+	result := make(map[model.BannerID]totals)
+	for _, snapshot := range snapshots {
+		for id, banner := range snapshot.Banners {
+			result[id] = result[id].plus(totals{clicks: int64(banner.Count), impressions: int64(banner.Impressions)})
 		}
 	}
+
+	return result
+}
+
+// clickMinute returns the minute a batch click is counted in. It must be the
+// current or the previous one: older minutes may already have been rotated
+// out.
+func clickMinute(click model.Click, current int64) (int64, error) {
+	if click.Count <= 0 {
+		return 0, fmt.Errorf("invalid count: %d", click.Count)
+	}
+
+	minute := current
+	if !click.TimeStamp.IsZero() {
+		minute = click.TimeStamp.Unix() / 60
+	}
+
+	if minute < current-1 || minute > current {
+		return 0, fmt.Errorf("%w: %s", ErrClickOutsideWindow, click.TimeStamp.Format(time.RFC3339))
+	}
+
+	return minute, nil
+}
+
+func clicksOf(sh *shard) []counter      { return sh.clicks }
+func impressionsOf(sh *shard) []counter { return sh.impressions }
+
+// load, add and swap address the counters by index, the banner ID minus one.
+func (s *InMemoryStorage) load(i int) totals {
+	var t totals
+	for g := range s.generations {
+		for j := range s.generations[g].shards {
+			sh := &s.generations[g].shards[j]
+			t.clicks += sh.clicks[i].n.Load()
+			t.impressions += sh.impressions[i].n.Load()
+		}
+	}
+
+	return t
+}
+
+func (s *InMemoryStorage) add(counters func(*shard) []counter, i int, minute int64, n int64) {
+	s.addWithDimensions(counters, i, minute, n, nil)
+}
+
+// addWithDimensions counts in the generation of the minute, restamping it
+// first if it still holds an older minute, so that a count is never stamped
+// with a minute it does not belong to.
+func (s *InMemoryStorage) addWithDimensions(counters func(*shard) []counter, i int, minute int64, n int64, values map[model.Dimension]string) {
+	g := &s.generations[minute&1]
+	j := rand.Uint32() & s.shardMask
+
+	for !g.gate.enter(j, minute) {
+		s.restamp(g, minute)
+	}
+	defer g.gate.leave(j)
+
+	counters(&g.shards[j])[i].n.Add(n)
+	if values != nil {
+		g.dims.add(s.ids[i], values)
+	}
+}
+
+// restamp moves the generation to the minute, spilling the counts of its
+// previous minute.
+func (s *InMemoryStorage) restamp(g *generation, minute int64) {
+	metrics.Lock(&s.mux, metrics.RotationLockWait)
+	defer s.mux.Unlock()
+
+	g.gate.restamp(minute, func(old int64) {
+		if snapshot, ok := s.collect(g, old); ok {
+			s.spilled = append(s.spilled, snapshot)
+		}
+	})
+}
+
+func (g *generation) swap(i int) totals {
+	var t totals
+	for j := range g.shards {
+		t.clicks += g.shards[j].clicks[i].n.Swap(0)
+		t.impressions += g.shards[j].impressions[i].n.Swap(0)
+	}
+
+	return t
+}
+
+// spilledTotals sums the counts spilled by the generations and not rotated
+// out yet.
+func (s *InMemoryStorage) spilledTotals() map[model.BannerID]totals {
+	metrics.Lock(&s.mux, metrics.RotationLockWait)
+	defer s.mux.Unlock()
+
+	return sumSnapshots(s.spilled)
+}
+
+func (s *InMemoryStorage) banner(i int, t totals, ts time.Time) model.Banner {
+	return model.Banner{
+		TimeStamp:   ts,
+		Name:        s.names[i],
+		BannerID:    s.ids[i],
+		Count:       int(t.clicks),
+		Impressions: int(t.impressions),
+	}
 }
@@ -0,0 +1,605 @@
+package inmemorystorage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"rsclabs-test/internal/model"
+)
+
+// mutexStorage is the previous global-lock implementation, kept here as the
+// baseline for the benchmarks.
+type mutexStorage struct {
+	mux         sync.RWMutex
+	maxCapacity int
+	values      map[int]*model.Banner
+}
+
+func newMutexStorage(maxCapacity int) *mutexStorage {
+	s := &mutexStorage{
+		maxCapacity: maxCapacity,
+		values:      make(map[int]*model.Banner, maxCapacity),
+	}
+	for i := 1; i <= maxCapacity; i++ {
+		s.values[i] = &model.Banner{BannerID: model.BannerIDFromInt(i), Name: fmt.Sprintf("Banner %d", i)}
+	}
+
+	return s
+}
+
+func (s *mutexStorage) IncrementCountTakeTimestamp(id int) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if id < 1 || id > s.maxCapacity {
+		return fmt.Errorf("invalid index: %d", id)
+	}
+	s.values[id].IncrementCount()
+	s.values[id].TimeStamp = time.Now()
+
+	return nil
+}
+
+func (s *mutexStorage) Rotate() []model.Snapshot {
+	s.mux.Lock()
+	old := s.values
+	s.values = make(map[int]*model.Banner, s.maxCapacity)
+	for i := 1; i <= s.maxCapacity; i++ {
+		s.values[i] = &model.Banner{BannerID: old[i].BannerID, Name: old[i].Name}
+	}
+	s.mux.Unlock()
+
+	result := make(map[model.BannerID]model.Banner)
+	for _, banner := range old {
+		if !banner.IsEmpty() {
+			result[banner.BannerID] = *banner
+		}
+	}
+
+	return []model.Snapshot{{Banners: result, TimeStamp: time.Now()}}
+}
+
+type clickStorage interface {
+	IncrementCountTakeTimestamp(id int) error
+	Rotate() []model.Snapshot
+}
+
+// sparseByIndex adapts the sparse storage to the integer IDs of the
+// benchmarks.
+type sparseByIndex struct {
+	*SparseStorage
+	ids []model.BannerID
+}
+
+func newSparseByIndex(maxCapacity int) *sparseByIndex {
+	s := &sparseByIndex{SparseStorage: NewSparseStorage(maxCapacity, time.Hour, 100, nil)}
+	for i := 0; i <= maxCapacity; i++ {
+		s.ids = append(s.ids, model.BannerIDFromInt(i))
+	}
+
+	return s
+}
+
+func (s *sparseByIndex) IncrementCountTakeTimestamp(id int) error {
+	return s.SparseStorage.IncrementCountTakeTimestamp(s.ids[id])
+}
+
+func TestConcurrentIncrementAndRotate(t *testing.T) {
+	storage := NewInMemoryStorage(10, 100, nil)
+
+	goroutines := 16
+	iterations := 10000
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				if err := storage.IncrementCountTakeTimestamp((g+j)%10 + 1); err != nil {
+					t.Errorf("IncrementCountTakeTimestamp failed: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	total := 0
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		for _, snapshot := range storage.Rotate() {
+			for _, banner := range snapshot.Banners {
+				total += banner.Count
+			}
+		}
+	}
+
+	if total != goroutines*iterations {
+		t.Errorf("Expected %d clicks across rotations, got %d", goroutines*iterations, total)
+	}
+}
+
+func TestIncrementRejectsInvalidIndex(t *testing.T) {
+	storage := NewInMemoryStorage(10, 100, nil)
+
+	for _, id := range []int{-1, 0, 11, 100} {
+		if err := storage.IncrementCountTakeTimestamp(id); err == nil {
+			t.Errorf("Expected error for index %d", id)
+		}
+	}
+}
+
+// benchmarkedStorages are the click storages compared by the benchmarks, over
+// 100 banners.
+var benchmarkedStorages = []struct {
+	name string
+	new  func() clickStorage
+}{
+	{"mutex", func() clickStorage { return newMutexStorage(100) }},
+	{"sharded", func() clickStorage { return NewInMemoryStorage(100, 100, nil) }},
+	{"sparse", func() clickStorage { return newSparseByIndex(100) }},
+}
+
+// BenchmarkIncrement compares the sharded atomic counters with the mutex map.
+// Each iteration counts a burst of clicks as fast as GOMAXPROCS goroutines
+// can, spread over 100 banners, followed by a rotation. The bursts are not
+// paced: they measure the cost of a click under contention, not a rate.
+func BenchmarkIncrement(b *testing.B) {
+	bursts := []struct {
+		name   string
+		clicks int
+	}{
+		{"burst_1k", 1_000},
+		{"burst_10k", 10_000},
+		{"burst_100k", 100_000},
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+
+	for _, impl := range benchmarkedStorages {
+		for _, burst := range bursts {
+			b.Run(impl.name+"/"+burst.name, func(b *testing.B) {
+				storage := impl.new()
+				perWorker := burst.clicks / workers
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					var wg sync.WaitGroup
+					for w := 0; w < workers; w++ {
+						wg.Add(1)
+						go func(w int) {
+							defer wg.Done()
+							for j := 0; j < perWorker; j++ {
+								_ = storage.IncrementCountTakeTimestamp((w+j)%100 + 1)
+							}
+						}(w)
+					}
+					wg.Wait()
+
+					storage.Rotate()
+				}
+
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*perWorker*workers), "ns/click")
+			})
+		}
+	}
+}
+
+// BenchmarkIncrementPaced compares the storages at a steady rate rather than
+// in bursts. Each iteration replays 100ms of traffic at the target rate: every
+// GOMAXPROCS goroutine sends its share of the clicks on each tick of a 1ms
+// ticker, for 100 ticks, followed by a rotation. It reports the rate achieved,
+// which falls short of the target when the clicks of a tick take longer than
+// the tick or the ticker lags, and the latency percentiles of a click.
+func BenchmarkIncrementPaced(b *testing.B) {
+	const (
+		tick   = time.Millisecond
+		window = 100 * time.Millisecond
+	)
+
+	rates := []struct {
+		name string
+		rps  int
+	}{
+		{"1k_rps", 1_000},
+		{"10k_rps", 10_000},
+		{"100k_rps", 100_000},
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+
+	for _, impl := range benchmarkedStorages {
+		for _, rate := range rates {
+			b.Run(impl.name+"/"+rate.name, func(b *testing.B) {
+				storage := impl.new()
+
+				// The clicks of a tick, spread over the workers
+				perTick := rate.rps * int(tick) / int(time.Second)
+				share := func(w int) int {
+					n := perTick / workers
+					if w < perTick%workers {
+						n++
+					}
+					return n
+				}
+
+				latencies := make([][]time.Duration, workers)
+
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					var wg sync.WaitGroup
+					for w := 0; w < workers; w++ {
+						n := share(w)
+						if n == 0 {
+							continue
+						}
+
+						wg.Add(1)
+						go func(w, n int) {
+							defer wg.Done()
+
+							ticker := time.NewTicker(tick)
+							defer ticker.Stop()
+
+							for t, j := 0, 0; t < int(window/tick); t++ {
+								<-ticker.C
+								for k := 0; k < n; k, j = k+1, j+1 {
+									start := time.Now()
+									_ = storage.IncrementCountTakeTimestamp((w+j)%100 + 1)
+									latencies[w] = append(latencies[w], time.Since(start))
+								}
+							}
+						}(w, n)
+					}
+					wg.Wait()
+
+					storage.Rotate()
+				}
+
+				b.StopTimer()
+
+				var all []time.Duration
+				for _, l := range latencies {
+					all = append(all, l...)
+				}
+				sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+				b.ReportMetric(float64(len(all))/b.Elapsed().Seconds(), "clicks/s")
+				b.ReportMetric(float64(rate.rps), "target_clicks/s")
+				if len(all) > 0 {
+					b.ReportMetric(float64(all[len(all)/2].Nanoseconds()), "p50_ns/click")
+					b.ReportMetric(float64(all[len(all)*99/100].Nanoseconds()), "p99_ns/click")
+				}
+			})
+		}
+	}
+}
+
+func TestClicksAreBucketedByMinute(t *testing.T) {
+	storage := NewInMemoryStorage(10, 100, nil)
+	storage.clock.Stop()
+
+	base := time.Date(2025, 6, 6, 1, 30, 0, 0, time.UTC).Unix() / 60
+
+	storage.clock.minute.Store(base)
+	storage.IncrementCountTakeTimestamp(1)
+	storage.IncrementCountTakeTimestamp(1)
+
+	storage.clock.minute.Store(base + 1)
+	storage.IncrementCountTakeTimestamp(1)
+	storage.IncrementCountTakeTimestamp(2)
+
+	snapshots := storage.Rotate()
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 minute snapshots, got %d", len(snapshots))
+	}
+
+	first, second := snapshots[0], snapshots[1]
+	if !first.TimeStamp.Equal(time.Date(2025, 6, 6, 1, 30, 0, 0, time.UTC)) {
+		t.Errorf("Expected first bucket at 01:30:00, got %v", first.TimeStamp)
+	}
+	if !second.TimeStamp.Equal(time.Date(2025, 6, 6, 1, 31, 0, 0, time.UTC)) {
+		t.Errorf("Expected second bucket at 01:31:00, got %v", second.TimeStamp)
+	}
+	if first.Banners["1"].Count != 2 || second.Banners["1"].Count != 1 || second.Banners["2"].Count != 1 {
+		t.Errorf("Unexpected bucket counts: %+v, %+v", first.Banners, second.Banners)
+	}
+
+	if snapshots := storage.Rotate(); len(snapshots) != 0 {
+		t.Errorf("Expected no snapshots after rotation, got %d", len(snapshots))
+	}
+}
+
+func TestIncrementBatch(t *testing.T) {
+	storage := NewInMemoryStorage(10, 100, nil)
+	storage.clock.Stop()
+
+	now := time.Date(2025, 6, 6, 1, 30, 20, 0, time.UTC)
+	storage.clock.minute.Store(now.Unix() / 60)
+
+	errs := storage.IncrementBatch([]model.Click{
+		{BannerID: "1", Count: 2},
+		{BannerID: "1", TimeStamp: now.Add(-time.Minute), Count: 3},
+		{BannerID: "2", TimeStamp: now.Add(-2 * time.Minute), Count: 1},
+		{BannerID: "2", TimeStamp: now.Add(time.Minute), Count: 1},
+		{BannerID: "11", Count: 1},
+		{BannerID: "3", Count: 0},
+	})
+
+	if len(errs) != 6 {
+		t.Fatalf("Expected 6 results, got %d", len(errs))
+	}
+	if errs[0] != nil || errs[1] != nil {
+		t.Errorf("Expected clicks in the current and previous minute to be applied, got %v, %v", errs[0], errs[1])
+	}
+	for _, i := range []int{2, 3} {
+		if !errors.Is(errs[i], ErrClickOutsideWindow) {
+			t.Errorf("Expected ErrClickOutsideWindow for click %d, got %v", i, errs[i])
+		}
+	}
+	if errs[4] == nil || errs[5] == nil {
+		t.Errorf("Expected errors for an invalid index and count, got %v, %v", errs[4], errs[5])
+	}
+
+	snapshots := storage.Rotate()
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 minute snapshots, got %d", len(snapshots))
+	}
+	if snapshots[0].Banners["1"].Count != 3 || snapshots[1].Banners["1"].Count != 2 {
+		t.Errorf("Unexpected bucket counts: %+v, %+v", snapshots[0].Banners, snapshots[1].Banners)
+	}
+	if _, ok := snapshots[0].Banners["2"]; ok {
+		t.Errorf("Rejected clicks must not be counted")
+	}
+}
+
+func TestIncrementWithAttributes(t *testing.T) {
+	storage := NewInMemoryStorage(10, 2, nil)
+	storage.clock.Stop()
+
+	for _, country := range []string{"DE", "FR", "DE", "IT", "ES"} {
+		attrs := model.ClickAttributes{Country: country, Placement: "top"}
+		if err := storage.IncrementWithAttributes(1, attrs); err != nil {
+			t.Fatalf("IncrementWithAttributes failed: %v", err)
+		}
+	}
+	if err := storage.IncrementWithAttributes(1, model.ClickAttributes{}); err != nil {
+		t.Fatalf("IncrementWithAttributes failed: %v", err)
+	}
+
+	snapshots := storage.Rotate()
+	if len(snapshots) != 1 {
+		t.Fatalf("Expected 1 snapshot, got %d", len(snapshots))
+	}
+
+	banner := snapshots[0].Banners["1"]
+	if banner.Count != 6 {
+		t.Errorf("Expected 6 clicks, got %d", banner.Count)
+	}
+
+	// The cap keeps the first two countries and folds the rest into "other"
+	want := map[string]int{"DE": 2, "FR": 1, model.OtherDimensionValue: 2}
+	if got := banner.Dimensions[model.DimensionCountry]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected countries %v, got %v", want, got)
+	}
+	if got := banner.Dimensions[model.DimensionPlacement]["top"]; got != 5 {
+		t.Errorf("Expected 5 clicks on the top placement, got %d", got)
+	}
+	if _, ok := banner.Dimensions[model.DimensionReferrer]; ok {
+		t.Errorf("Expected no referrer dimension, got %v", banner.Dimensions[model.DimensionReferrer])
+	}
+}
+
+func TestIncrementImpression(t *testing.T) {
+	storage := NewInMemoryStorage(10, 100, nil)
+
+	for i := 0; i < 4; i++ {
+		if err := storage.IncrementImpression(2); err != nil {
+			t.Fatalf("IncrementImpression failed: %v", err)
+		}
+	}
+	storage.IncrementCountTakeTimestamp(2)
+	storage.IncrementImpression(3)
+
+	if err := storage.IncrementImpression(11); err == nil {
+		t.Errorf("Expected error for banner 11")
+	}
+
+	banners := make(map[model.BannerID]model.Banner)
+	for _, snapshot := range storage.Rotate() {
+		for id, banner := range snapshot.Banners {
+			b := banners[id]
+			b.Count += banner.Count
+			b.Impressions += banner.Impressions
+			banners[id] = b
+		}
+	}
+
+	if banners["2"].Impressions != 4 || banners["2"].Count != 1 {
+		t.Errorf("Expected 4 impressions and 1 click for banner 2, got %+v", banners["2"])
+	}
+	if banners["3"].Impressions != 1 || banners["3"].Count != 0 {
+		t.Errorf("Expected 1 impression and no clicks for banner 3, got %+v", banners["3"])
+	}
+}
+
+func TestLateRotationKeepsMinuteStamps(t *testing.T) {
+	storage := NewInMemoryStorage(10, 100, nil)
+	storage.clock.Stop()
+
+	base := time.Date(2025, 6, 6, 1, 30, 0, 0, time.UTC)
+	minute := base.Unix() / 60
+
+	storage.clock.minute.Store(minute)
+	storage.IncrementWithAttributes(1, model.ClickAttributes{Country: "DE"})
+	storage.IncrementCountTakeTimestamp(1)
+
+	// No rotation for two minutes: minute+2 reuses the generation of minute
+	storage.clock.minute.Store(minute + 2)
+	storage.IncrementCountTakeTimestamp(1)
+	storage.IncrementCountTakeTimestamp(2)
+
+	if got := storage.GetSnapshot()["1"].Count; got != 3 {
+		t.Errorf("Expected 3 pending clicks on banner 1, got %d", got)
+	}
+
+	snapshots := storage.Rotate()
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 minute snapshots, got %d", len(snapshots))
+	}
+
+	first, second := snapshots[0], snapshots[1]
+	if !first.TimeStamp.Equal(base) || !second.TimeStamp.Equal(base.Add(2*time.Minute)) {
+		t.Fatalf("Unexpected buckets %v, %v", first.TimeStamp, second.TimeStamp)
+	}
+	if first.Banners["1"].Count != 2 || first.Banners["1"].Dimensions[model.DimensionCountry]["DE"] != 1 {
+		t.Errorf("Expected the 2 clicks of the first minute in its bucket, got %+v", first.Banners)
+	}
+	if second.Banners["1"].Count != 1 || second.Banners["2"].Count != 1 {
+		t.Errorf("Expected 1 click per banner in the later bucket, got %+v", second.Banners)
+	}
+}
+
+// TestConcurrentMinutesAreNeverRestamped counts batch clicks with explicit
+// minutes while the clock advances faster than the rotations, and checks that
+// every minute gets exactly its own clicks back.
+func TestConcurrentMinutesAreNeverRestamped(t *testing.T) {
+	storages := map[string]interface {
+		IncrementBatch([]model.Click) []error
+		Rotate() []model.Snapshot
+	}{
+		"sharded": NewInMemoryStorage(10, 100, nil),
+		"sparse":  NewSparseStorage(10, time.Hour, 100, nil),
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			var clock *minuteClock
+			switch s := storage.(type) {
+			case *InMemoryStorage:
+				clock = s.clock
+			case *SparseStorage:
+				clock = s.clock
+			}
+			clock.Stop()
+
+			base := time.Date(2025, 6, 6, 1, 30, 0, 0, time.UTC).Unix() / 60
+			clock.minute.Store(base)
+
+			var (
+				mux     sync.Mutex
+				counted = make(map[int64]int)
+				wg      sync.WaitGroup
+				stop    = make(chan struct{})
+			)
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+
+					local := make(map[int64]int)
+					for j := 0; ; j++ {
+						select {
+						case <-stop:
+							mux.Lock()
+							for minute, n := range local {
+								counted[minute] += n
+							}
+							mux.Unlock()
+							return
+						default:
+						}
+
+						minute := clock.Minute() - int64(j%2)
+						click := model.Click{
+							BannerID:  model.BannerIDFromInt(w + 1),
+							TimeStamp: time.Unix(minute*60, 0),
+							Count:     1,
+						}
+						if errs := storage.IncrementBatch([]model.Click{click}); errs[0] == nil {
+							local[minute]++
+						}
+					}
+				}(w)
+			}
+
+			rotated := make(map[int64]int)
+			collect := func() {
+				for _, snapshot := range storage.Rotate() {
+					for _, banner := range snapshot.Banners {
+						rotated[snapshot.TimeStamp.Unix()/60] += banner.Count
+					}
+				}
+			}
+
+			// Rotate only every third minute, so that generations are reused
+			// while they still hold an older minute
+			for minute := base + 1; minute <= base+30; minute++ {
+				time.Sleep(time.Millisecond)
+				clock.minute.Store(minute)
+				if minute%3 == 0 {
+					collect()
+				}
+			}
+
+			close(stop)
+			wg.Wait()
+			collect()
+
+			if !reflect.DeepEqual(counted, rotated) {
+				t.Errorf("Expected the clicks of every minute in its own bucket:\ncounted %v\nrotated %v", counted, rotated)
+			}
+		})
+	}
+}
+
+func TestDimensionCapIsScopedToRotation(t *testing.T) {
+	storage := NewInMemoryStorage(10, 2, nil)
+	storage.clock.Stop()
+
+	for _, country := range []string{"DE", "FR", "IT"} {
+		storage.IncrementWithAttributes(1, model.ClickAttributes{Country: country})
+	}
+
+	snapshots := storage.Rotate()
+	if len(snapshots) != 1 {
+		t.Fatalf("Expected 1 snapshot, got %d", len(snapshots))
+	}
+	want := map[string]int{"DE": 1, "FR": 1, model.OtherDimensionValue: 1}
+	if got := snapshots[0].Banners["1"].Dimensions[model.DimensionCountry]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected countries %v before the rotation, got %v", want, got)
+	}
+
+	// A new window admits values that were capped in the previous one
+	for _, country := range []string{"IT", "ES", "DE"} {
+		storage.IncrementWithAttributes(1, model.ClickAttributes{Country: country})
+	}
+
+	snapshots = storage.Rotate()
+	if len(snapshots) != 1 {
+		t.Fatalf("Expected 1 snapshot, got %d", len(snapshots))
+	}
+	want = map[string]int{"IT": 1, "ES": 1, model.OtherDimensionValue: 1}
+	if got := snapshots[0].Banners["1"].Dimensions[model.DimensionCountry]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected countries %v after the rotation, got %v", want, got)
+	}
+}
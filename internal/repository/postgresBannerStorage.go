@@ -1,3 +1,396 @@
 package repository
 
-// TODO: ADD IMPLEMENTATION
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/pkg/observe"
+)
+
+// postgresMigrations are applied in order, each once: the applied versions are
+// recorded in schema_migrations. The first three predate that table and are
+// idempotent, so replaying them on an older database is harmless.
+var postgresMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS banner_statistics (
+		ts        TIMESTAMPTZ NOT NULL,
+		banner_id INTEGER     NOT NULL,
+		name      TEXT        NOT NULL,
+		clicks    INTEGER     NOT NULL,
+		PRIMARY KEY (ts, banner_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS banner_statistics_dimensions (
+		ts        TIMESTAMPTZ NOT NULL,
+		banner_id INTEGER     NOT NULL,
+		dimension TEXT        NOT NULL,
+		value     TEXT        NOT NULL,
+		clicks    INTEGER     NOT NULL,
+		PRIMARY KEY (ts, banner_id, dimension, value),
+		FOREIGN KEY (ts, banner_id) REFERENCES banner_statistics (ts, banner_id) ON DELETE CASCADE
+	)`,
+	`ALTER TABLE banner_statistics ADD COLUMN IF NOT EXISTS impressions INTEGER NOT NULL DEFAULT 0`,
+	// String banner IDs: the zero-based indexes stored so far become the
+	// public integer IDs
+	`ALTER TABLE banner_statistics_dimensions DROP CONSTRAINT IF EXISTS banner_statistics_dimensions_ts_banner_id_fkey;
+	ALTER TABLE banner_statistics ALTER COLUMN banner_id TYPE TEXT USING (banner_id + 1)::text;
+	ALTER TABLE banner_statistics_dimensions ALTER COLUMN banner_id TYPE TEXT USING (banner_id + 1)::text;
+	ALTER TABLE banner_statistics_dimensions ADD CONSTRAINT banner_statistics_dimensions_ts_banner_id_fkey
+		FOREIGN KEY (ts, banner_id) REFERENCES banner_statistics (ts, banner_id) ON DELETE CASCADE`,
+	// Tenants: the rows stored so far belong to the default tenant
+	`ALTER TABLE banner_statistics_dimensions DROP CONSTRAINT banner_statistics_dimensions_ts_banner_id_fkey;
+	ALTER TABLE banner_statistics ADD COLUMN tenant TEXT NOT NULL DEFAULT 'default';
+	ALTER TABLE banner_statistics_dimensions ADD COLUMN tenant TEXT NOT NULL DEFAULT 'default';
+	ALTER TABLE banner_statistics DROP CONSTRAINT banner_statistics_pkey,
+		ADD PRIMARY KEY (tenant, ts, banner_id);
+	ALTER TABLE banner_statistics_dimensions DROP CONSTRAINT banner_statistics_dimensions_pkey,
+		ADD PRIMARY KEY (tenant, ts, banner_id, dimension, value);
+	ALTER TABLE banner_statistics_dimensions ADD CONSTRAINT banner_statistics_dimensions_tenant_fkey
+		FOREIGN KEY (tenant, ts, banner_id) REFERENCES banner_statistics (tenant, ts, banner_id) ON DELETE CASCADE`,
+}
+
+// BannerRepositoryPostgres stores the snapshots of one tenant. The tenants
+// share the tables and the connection pool, see ForTenant.
+type BannerRepositoryPostgres struct {
+	pool   *pgxpool.Pool
+	tenant string
+}
+
+// NewPostgresBannerRepository connects to the database and migrates it. The
+// repository stores the snapshots of the default tenant.
+func NewPostgresBannerRepository(ctx context.Context, dsn string) (*BannerRepositoryPostgres, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create postgres pool: %w", err)
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to reach postgres: %w", err)
+	}
+
+	r := &BannerRepositoryPostgres{
+		pool:   pool,
+		tenant: model.DefaultTenant,
+	}
+
+	if err := r.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// ForTenant returns a repository storing the snapshots of the tenant over the
+// same connection pool. Only the repository returned by
+// NewPostgresBannerRepository closes the pool.
+func (r *BannerRepositoryPostgres) ForTenant(tenant string) *BannerRepositoryPostgres {
+	return &BannerRepositoryPostgres{
+		pool:   r.pool,
+		tenant: tenant,
+	}
+}
+
+// SaveSnapshots writes the snapshots in a single transaction. Rows are upserted
+// by (tenant, ts, banner_id), and the dimension breakdown by (tenant, ts,
+// banner_id, dimension, value), so flushing the same snapshot twice is
+// harmless.
+func (r *BannerRepositoryPostgres) SaveSnapshots(ctx context.Context, snapshots []model.Snapshot) (err error) {
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	ctx, span := r.startSpan(ctx, "BannerRepositoryPostgres.SaveSnapshots",
+		attribute.Int("snapshots", len(snapshots)))
+	defer func() { observe.EndSpan(span, err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, snapshot := range snapshots {
+		for id, banner := range snapshot.Banners {
+			batch.Queue(
+				`INSERT INTO banner_statistics (tenant, ts, banner_id, name, clicks, impressions)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (tenant, ts, banner_id) DO UPDATE
+				SET name = EXCLUDED.name, clicks = EXCLUDED.clicks, impressions = EXCLUDED.impressions`,
+				r.tenant, snapshot.TimeStamp.UTC(), string(id), banner.Name, banner.Count, banner.Impressions,
+			)
+
+			for dimension, values := range banner.Dimensions {
+				for value, clicks := range values {
+					batch.Queue(
+						`INSERT INTO banner_statistics_dimensions (tenant, ts, banner_id, dimension, value, clicks)
+						VALUES ($1, $2, $3, $4, $5, $6)
+						ON CONFLICT (tenant, ts, banner_id, dimension, value) DO UPDATE
+						SET clicks = EXCLUDED.clicks`,
+						r.tenant, snapshot.TimeStamp.UTC(), string(id), string(dimension), value, clicks,
+					)
+				}
+			}
+		}
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to insert snapshots: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit snapshots: %w", err)
+	}
+
+	return nil
+}
+
+// GetSnapshots returns the snapshots stored in [from, to], ordered by time.
+func (r *BannerRepositoryPostgres) GetSnapshots(ctx context.Context, from, to time.Time) (_ []model.Snapshot, err error) {
+	ctx, span := r.startSpan(ctx, "BannerRepositoryPostgres.GetSnapshots", spanRange(from, to)...)
+	defer func() { observe.EndSpan(span, err) }()
+
+	rows, err := r.pool.Query(ctx,
+		`SELECT ts, banner_id, name, clicks, impressions
+		FROM banner_statistics
+		WHERE tenant = $1 AND ts >= $2 AND ts <= $3
+		ORDER BY ts, banner_id`,
+		r.tenant, from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to query snapshots: %w", ErrStoreUnavailable, err)
+	}
+	defer rows.Close()
+
+	var out []model.Snapshot
+	for rows.Next() {
+		var (
+			banner   model.Banner
+			bannerID string
+		)
+		if err := rows.Scan(&banner.TimeStamp, &bannerID, &banner.Name, &banner.Count, &banner.Impressions); err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot row: %w", err)
+		}
+		banner.BannerID = model.BannerID(bannerID)
+
+		if len(out) == 0 || !out[len(out)-1].TimeStamp.Equal(banner.TimeStamp) {
+			out = append(out, model.Snapshot{
+				Banners:   make(map[model.BannerID]model.Banner),
+				TimeStamp: banner.TimeStamp,
+			})
+		}
+		out[len(out)-1].Banners[banner.BannerID] = banner
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read snapshots: %w", err)
+	}
+
+	if err := r.loadDimensions(ctx, out, from, to); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("snapshots", len(out)))
+
+	return out, nil
+}
+
+// loadDimensions attaches the dimension breakdown stored in [from, to] to the
+// snapshots.
+func (r *BannerRepositoryPostgres) loadDimensions(ctx context.Context, snapshots []model.Snapshot, from, to time.Time) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	index := make(map[int64]int, len(snapshots))
+	for i, snapshot := range snapshots {
+		index[snapshot.TimeStamp.UnixMicro()] = i
+	}
+
+	rows, err := r.pool.Query(ctx,
+		`SELECT ts, banner_id, dimension, value, clicks
+		FROM banner_statistics_dimensions
+		WHERE tenant = $1 AND ts >= $2 AND ts <= $3`,
+		r.tenant, from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: failed to query snapshot dimensions: %w", ErrStoreUnavailable, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			ts        time.Time
+			bannerID  string
+			dimension string
+			value     string
+			clicks    int
+		)
+		if err := rows.Scan(&ts, &bannerID, &dimension, &value, &clicks); err != nil {
+			return fmt.Errorf("failed to scan snapshot dimension row: %w", err)
+		}
+
+		i, ok := index[ts.UnixMicro()]
+		if !ok {
+			continue
+		}
+
+		banner, ok := snapshots[i].Banners[model.BannerID(bannerID)]
+		if !ok {
+			continue
+		}
+
+		if banner.Dimensions == nil {
+			banner.Dimensions = make(model.DimensionCounts)
+		}
+		banner.Dimensions.Add(model.Dimension(dimension), value, clicks)
+		snapshots[i].Banners[model.BannerID(bannerID)] = banner
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read snapshot dimensions: %w", err)
+	}
+
+	return nil
+}
+
+// GetLastSnapshotTime returns the timestamp of the newest stored snapshot, or
+// the zero time when the table is empty.
+func (r *BannerRepositoryPostgres) GetLastSnapshotTime(ctx context.Context) (time.Time, error) {
+	var last *time.Time
+	if err := r.pool.QueryRow(ctx, `SELECT MAX(ts) FROM banner_statistics WHERE tenant = $1`, r.tenant).Scan(&last); err != nil {
+		return time.Time{}, fmt.Errorf("%w: failed to query last snapshot time: %w", ErrStoreUnavailable, err)
+	}
+
+	if last == nil {
+		return time.Time{}, nil
+	}
+
+	return *last, nil
+}
+
+func (r *BannerRepositoryPostgres) EvictSnapshots(ctx context.Context, before time.Time, keep int) (_ int, err error) {
+	ctx, span := r.startSpan(ctx, "BannerRepositoryPostgres.EvictSnapshots")
+	defer func() { observe.EndSpan(span, err) }()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	countQuery := `SELECT COUNT(DISTINCT ts) FROM banner_statistics WHERE tenant = $1`
+
+	var total int
+	if err := tx.QueryRow(ctx, countQuery, r.tenant).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count snapshots: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM banner_statistics WHERE tenant = $1 AND ts < $2`, r.tenant, before.UTC()); err != nil {
+		return 0, fmt.Errorf("failed to evict expired snapshots: %w", err)
+	}
+
+	if keep > 0 {
+		if _, err := tx.Exec(ctx,
+			`WITH retained AS (
+				SELECT DISTINCT ts FROM banner_statistics WHERE tenant = $1 ORDER BY ts DESC LIMIT $2
+			)
+			DELETE FROM banner_statistics WHERE tenant = $1 AND ts < (SELECT MIN(ts) FROM retained)`,
+			r.tenant, keep,
+		); err != nil {
+			return 0, fmt.Errorf("failed to evict overflowing snapshots: %w", err)
+		}
+	}
+
+	var left int
+	if err := tx.QueryRow(ctx, countQuery, r.tenant).Scan(&left); err != nil {
+		return 0, fmt.Errorf("failed to count retained snapshots: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit eviction: %w", err)
+	}
+
+	return total - left, nil
+}
+
+// Ping checks that the database is reachable.
+func (r *BannerRepositoryPostgres) Ping(ctx context.Context) error {
+	if err := r.pool.Ping(ctx); err != nil {
+		return fmt.Errorf("%w: failed to ping postgres: %w", ErrStoreUnavailable, err)
+	}
+
+	return nil
+}
+
+// startSpan starts a span of a query of the tenant's statistics.
+func (r *BannerRepositoryPostgres) startSpan(
+	ctx context.Context,
+	name string,
+	attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	return observe.StartSpan(ctx, name, append(attrs,
+		semconv.DBSystemPostgreSQL,
+		attribute.String("tenant", r.tenant),
+	)...)
+}
+
+func (r *BannerRepositoryPostgres) Close() {
+	r.pool.Close()
+}
+
+func (r *BannerRepositoryPostgres) migrate(ctx context.Context) error {
+	if _, err := r.pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	for i, migration := range postgresMigrations {
+		if err := r.applyMigration(ctx, i, migration); err != nil {
+			return fmt.Errorf("failed to apply postgres migration %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration applies the migration unless it is recorded already. The
+// table lock serializes instances starting at the same time.
+func (r *BannerRepositoryPostgres) applyMigration(ctx context.Context, version int, migration string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("failed to lock schema_migrations: %w", err)
+	}
+
+	var applied bool
+	if err := tx.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version,
+	).Scan(&applied); err != nil {
+		return fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+
+	if applied {
+		return nil
+	}
+
+	if _, err := tx.Exec(ctx, migration); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"rsclabs-test/internal/model"
+)
+
+// setupTestPostgresRepository connects to the database from POSTGRES_TEST_DSN,
+// e.g. a local container started with
+// `docker run --rm -e POSTGRES_PASSWORD=postgres -p 5432:5432 postgres:16`.
+func setupTestPostgresRepository(t *testing.T) *BannerRepositoryPostgres {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	ctx := context.Background()
+	repo, err := NewPostgresBannerRepository(ctx, dsn)
+	if err != nil {
+		t.Fatalf("NewPostgresBannerRepository failed: %v", err)
+	}
+
+	if _, err := repo.pool.Exec(ctx, `TRUNCATE banner_statistics CASCADE`); err != nil {
+		t.Fatalf("failed to truncate banner_statistics: %v", err)
+	}
+
+	t.Cleanup(repo.Close)
+
+	return repo
+}
+
+func TestPostgresSaveAndGetSnapshots(t *testing.T) {
+	repo := setupTestPostgresRepository(t)
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+	snapshots := []model.Snapshot{
+		{
+			TimeStamp: base,
+			Banners: map[model.BannerID]model.Banner{
+				"0": {
+					BannerID: "0", Name: "Banner 0", Count: 3,
+					Dimensions: model.DimensionCounts{model.DimensionCountry: {"DE": 2, "FR": 1}},
+				},
+				"5": {BannerID: "5", Name: "Banner 5", Count: 1, Impressions: 4},
+			},
+		},
+		{
+			TimeStamp: base.Add(time.Minute),
+			Banners: map[model.BannerID]model.Banner{
+				"0": {BannerID: "0", Name: "Banner 0", Count: 7},
+			},
+		},
+	}
+
+	if err := repo.SaveSnapshots(ctx, snapshots); err != nil {
+		t.Fatalf("SaveSnapshots failed: %v", err)
+	}
+
+	// Saving the same snapshots again must not duplicate rows
+	if err := repo.SaveSnapshots(ctx, snapshots); err != nil {
+		t.Fatalf("SaveSnapshots (repeat) failed: %v", err)
+	}
+
+	got, err := repo.GetSnapshots(ctx, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetSnapshots failed: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d", len(got))
+	}
+	if len(got[0].Banners) != 2 {
+		t.Errorf("Expected 2 banners in first snapshot, got %d", len(got[0].Banners))
+	}
+	if got[1].Banners["0"].Count != 7 {
+		t.Errorf("Expected count 7 for banner 0, got %d", got[1].Banners["0"].Count)
+	}
+	if got[0].Banners["5"].Impressions != 4 {
+		t.Errorf("Expected 4 impressions for banner 5, got %d", got[0].Banners["5"].Impressions)
+	}
+	if got[0].Banners["0"].Dimensions[model.DimensionCountry]["DE"] != 2 {
+		t.Errorf("Expected 2 clicks from DE for banner 0, got %v", got[0].Banners["0"].Dimensions)
+	}
+
+	got, err = repo.GetSnapshots(ctx, base.Add(30*time.Second), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetSnapshots failed: %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("Expected 1 snapshot in range, got %d", len(got))
+	}
+}
+
+func TestPostgresGetLastSnapshotTime(t *testing.T) {
+	repo := setupTestPostgresRepository(t)
+	ctx := context.Background()
+
+	last, err := repo.GetLastSnapshotTime(ctx)
+	if err != nil {
+		t.Fatalf("GetLastSnapshotTime failed: %v", err)
+	}
+	if !last.IsZero() {
+		t.Errorf("Expected zero time for empty table, got %v", last)
+	}
+
+	ts := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+	err = repo.SaveSnapshots(ctx, []model.Snapshot{
+		{TimeStamp: ts, Banners: map[model.BannerID]model.Banner{"1": {BannerID: "1", Name: "Banner 1", Count: 1}}},
+	})
+	if err != nil {
+		t.Fatalf("SaveSnapshots failed: %v", err)
+	}
+
+	last, err = repo.GetLastSnapshotTime(ctx)
+	if err != nil {
+		t.Fatalf("GetLastSnapshotTime failed: %v", err)
+	}
+	if !last.Equal(ts) {
+		t.Errorf("Expected last snapshot time %v, got %v", ts, last)
+	}
+}
+
+func TestPostgresTenantsAreIsolated(t *testing.T) {
+	repo := setupTestPostgresRepository(t)
+	acme := repo.ForTenant("acme")
+	ctx := context.Background()
+
+	ts := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+	for _, r := range []*BannerRepositoryPostgres{repo, acme} {
+		err := r.SaveSnapshots(ctx, []model.Snapshot{
+			{TimeStamp: ts, Banners: map[model.BannerID]model.Banner{"1": {BannerID: "1", Name: r.tenant, Count: 1}}},
+		})
+		if err != nil {
+			t.Fatalf("SaveSnapshots failed: %v", err)
+		}
+	}
+
+	got, err := acme.GetSnapshots(ctx, ts, ts.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetSnapshots failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Banners["1"].Name != "acme" {
+		t.Fatalf("Expected the snapshot of acme only, got %+v", got)
+	}
+
+	removed, err := acme.EvictSnapshots(ctx, ts.Add(time.Minute), 0)
+	if err != nil {
+		t.Fatalf("EvictSnapshots failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 evicted snapshot, got %d", removed)
+	}
+
+	if last, _ := repo.GetLastSnapshotTime(ctx); !last.Equal(ts) {
+		t.Errorf("Expected the default tenant to keep its snapshot, got last time %v", last)
+	}
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository/inmemorystorage"
+)
+
+var (
+	// ErrClickOutsideWindow is returned by ClickCounter.RegisterClicks for a
+	// click that is too old or in the future to be counted.
+	ErrClickOutsideWindow = inmemorystorage.ErrClickOutsideWindow
+	// ErrInvalidBannerID is returned for a malformed banner ID: in the
+	// integer mode, one that is not a number.
+	ErrInvalidBannerID = inmemorystorage.ErrInvalidBannerID
+	// ErrBannerIDOutOfRange is returned in the integer mode for a banner ID
+	// outside 1..MaxBanners.
+	ErrBannerIDOutOfRange = errors.New("banner ID is out of range")
+	// ErrTooManyBanners is returned when the sparse counters are full.
+	ErrTooManyBanners = inmemorystorage.ErrTooManyBanners
+	// ErrStoreUnavailable is returned by a SnapshotStore that cannot reach
+	// its backend, e.g. for a failed database query.
+	ErrStoreUnavailable = errors.New("snapshot store is unavailable")
+)
+
+// ClickCounter holds the live click and impression counters of the current
+// minute.
+type ClickCounter interface {
+	RegisterClick(id model.BannerID) error
+	RegisterClickWithAttributes(id model.BannerID, attrs model.ClickAttributes) error
+	RegisterClicks(clicks []model.Click) []error
+	RegisterImpression(id model.BannerID) error
+	GetCountSnapshot() model.Snapshot
+	RotateCounts() []model.Snapshot
+	ZeroOutCounts()
+	GetValues() []model.Banner
+	// ValidateID checks that the banner ID can be counted. It returns
+	// ErrInvalidBannerID or ErrBannerIDOutOfRange otherwise.
+	ValidateID(id model.BannerID) error
+}
+
+// SnapshotStore keeps a time-ordered history of snapshots, either the
+// per-minute ones or a rollup of them.
+type SnapshotStore interface {
+	// SaveSnapshots stores the snapshots; a snapshot replaces the stored one
+	// with the same timestamp.
+	SaveSnapshots(ctx context.Context, snapshots []model.Snapshot) error
+	GetSnapshots(ctx context.Context, from, to time.Time) ([]model.Snapshot, error)
+	GetLastSnapshotTime(ctx context.Context) (time.Time, error)
+	// EvictSnapshots removes the snapshots taken before the given time and
+	// then the oldest ones beyond the newest keep (keep <= 0 keeps all). It
+	// returns the number of removed snapshots.
+	EvictSnapshots(ctx context.Context, before time.Time, keep int) (int, error)
+}
+
+// SnapshotSizer is implemented by the snapshot stores that hold their
+// snapshots in memory.
+type SnapshotSizer interface {
+	// Size returns the number of snapshots held and an estimate of the memory
+	// they take, in bytes.
+	Size() (snapshots, bytes int)
+}
+
+// BannerCatalog persists the banner catalog.
+type BannerCatalog interface {
+	ListBanners(ctx context.Context) ([]model.CatalogBanner, error)
+	// SaveBanner creates or replaces the banner with the same ID.
+	SaveBanner(ctx context.Context, banner model.CatalogBanner) error
+}
+
+var (
+	_ ClickCounter  = (*BannerRepositoryInMemory)(nil)
+	_ ClickCounter  = (*BannerRepositorySparse)(nil)
+	_ SnapshotStore = (*SnapshotRepositoryInMemory)(nil)
+	_ SnapshotStore = (*SnapshotRepositoryDurable)(nil)
+	_ SnapshotStore = (*BannerRepositoryPostgres)(nil)
+	_ BannerCatalog = (*CatalogRepositoryFile)(nil)
+	_ SnapshotSizer = (*SnapshotRepositoryInMemory)(nil)
+	_ SnapshotSizer = (*SnapshotRepositoryDurable)(nil)
+)
+
+// spanRange describes the time range of a snapshot query on its span.
+func spanRange(from, to time.Time) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("from", from.UTC().Format(time.RFC3339)),
+		attribute.String("to", to.UTC().Format(time.RFC3339)),
+	}
+}
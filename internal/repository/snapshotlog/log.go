@@ -0,0 +1,510 @@
+package snapshotlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"rsclabs-test/internal/model"
+)
+
+// Every record is framed as: payload length (uint32, LE), CRC-32C of the
+// payload (uint32, LE), JSON payload.
+const (
+	headerSize       = 8
+	maxRecordSize    = 64 * 1024 * 1024
+	segmentExtension = ".wal"
+
+	DefaultSegmentSize  int64 = 64 * 1024 * 1024
+	DefaultSyncInterval       = 1 * time.Second
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptRecord = errors.New("corrupt snapshot log record")
+
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs the segment after every append.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsyncs on append when the previous fsync is older than
+	// Options.SyncInterval, and on Close.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+type Options struct {
+	SegmentSize  int64
+	SyncPolicy   SyncPolicy
+	SyncInterval time.Duration
+}
+
+type record struct {
+	TimeStamp time.Time      `json:"ts"`
+	Banners   []bannerRecord `json:"banners"`
+}
+
+// bannerRecord identifies the banner by Key. Records written before string
+// banner IDs carry the zero-based index of an integer banner in ID instead.
+type bannerRecord struct {
+	ID          int                   `json:"id,omitempty"`
+	Key         model.BannerID        `json:"key,omitempty"`
+	Name        string                `json:"name"`
+	Count       int                   `json:"v"`
+	Impressions int                   `json:"i,omitempty"`
+	Dimensions  model.DimensionCounts `json:"d,omitempty"`
+}
+
+type segment struct {
+	index int
+	// timestamps are the distinct snapshot times of the segment, in Unix
+	// nanoseconds: a snapshot rewritten in place is logged again
+	timestamps map[int64]struct{}
+	maxTS      time.Time
+}
+
+func (s *segment) add(snapshot model.Snapshot) error {
+	if s.timestamps == nil {
+		s.timestamps = make(map[int64]struct{})
+	}
+	s.timestamps[snapshot.TimeStamp.UnixNano()] = struct{}{}
+
+	if snapshot.TimeStamp.After(s.maxTS) {
+		s.maxTS = snapshot.TimeStamp
+	}
+
+	return nil
+}
+
+// Log is an append-only, checksummed log of snapshots split into numbered
+// segment files.
+type Log struct {
+	mux         sync.Mutex
+	dir         string
+	opts        Options
+	segments    []*segment
+	active      *os.File
+	activeSize  int64
+	lastSync    time.Time
+	truncatedAt int64
+	// appendErr is the error of the last append, if it failed
+	appendErr error
+}
+
+// Open opens the log in dir, creating the directory if needed. A corrupt or
+// partially written record at the end of the last segment is truncated away;
+// corruption anywhere else is reported as an error.
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	switch opts.SyncPolicy {
+	case SyncAlways, SyncInterval, SyncNever:
+	case "":
+		opts.SyncPolicy = SyncAlways
+	default:
+		return nil, fmt.Errorf("unknown snapshot log sync policy %q", opts.SyncPolicy)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot log directory: %w", err)
+	}
+
+	indexes, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:      dir,
+		opts:     opts,
+		lastSync: time.Now(),
+	}
+
+	for i, index := range indexes {
+		seg := &segment{index: index}
+		l.segments = append(l.segments, seg)
+
+		size, err := scanSegment(l.segmentPath(index), seg.add)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errCorruptRecord) || i != len(indexes)-1 {
+			return nil, fmt.Errorf("failed to read snapshot log segment %d: %w", index, err)
+		}
+
+		if err := os.Truncate(l.segmentPath(index), size); err != nil {
+			return nil, fmt.Errorf("failed to truncate corrupt snapshot log tail: %w", err)
+		}
+		l.truncatedAt = size
+	}
+
+	if len(l.segments) == 0 {
+		l.segments = append(l.segments, &segment{index: 1})
+	}
+
+	if err := l.openActive(l.segments[len(l.segments)-1].index); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Append writes the snapshots to the active segment, rolling over to a new
+// segment once it grows past the configured size.
+func (l *Log) Append(snapshots ...model.Snapshot) (err error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	defer func() { l.appendErr = err }()
+
+	for _, snapshot := range snapshots {
+		frame, err := encode(snapshot)
+		if err != nil {
+			return err
+		}
+
+		if l.activeSize > 0 && l.activeSize+int64(len(frame)) > l.opts.SegmentSize {
+			if err := l.rollover(); err != nil {
+				return err
+			}
+		}
+
+		if _, err := l.active.Write(frame); err != nil {
+			// Drop the partial frame so later appends stay readable
+			_ = l.active.Truncate(l.activeSize)
+			return fmt.Errorf("failed to append to snapshot log: %w", err)
+		}
+		l.activeSize += int64(len(frame))
+		_ = l.segments[len(l.segments)-1].add(snapshot)
+	}
+
+	switch l.opts.SyncPolicy {
+	case SyncAlways:
+		return l.sync()
+	case SyncInterval:
+		if time.Since(l.lastSync) >= l.opts.SyncInterval {
+			return l.sync()
+		}
+	}
+
+	return nil
+}
+
+// Replay calls fn for every snapshot in the log, oldest first.
+func (l *Log) Replay(fn func(model.Snapshot) error) error {
+	l.mux.Lock()
+	indexes := make([]int, 0, len(l.segments))
+	for _, seg := range l.segments {
+		indexes = append(indexes, seg.index)
+	}
+	l.mux.Unlock()
+
+	for _, index := range indexes {
+		if _, err := scanSegment(l.segmentPath(index), fn); err != nil {
+			return fmt.Errorf("failed to replay snapshot log segment %d: %w", index, err)
+		}
+	}
+
+	return nil
+}
+
+// Compact removes the oldest closed segments whose snapshots are all outside
+// the retention: older than before, or not among the newest keep snapshots
+// (keep <= 0 disables the count limit). Snapshots are counted by timestamp,
+// so the rewrites of a snapshot count once. It returns the number of removed
+// segments.
+func (l *Log) Compact(before time.Time, keep int) (int, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	var oldestKept time.Time
+	if keep > 0 {
+		oldestKept = l.newest(keep)
+	}
+
+	removed := 0
+	for len(l.segments) > 1 {
+		seg := l.segments[0]
+
+		expired := seg.maxTS.Before(before)
+		overflow := seg.maxTS.Before(oldestKept)
+		if !expired && !overflow {
+			break
+		}
+
+		if err := os.Remove(l.segmentPath(seg.index)); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot log segment %d: %w", seg.index, err)
+		}
+
+		l.segments = l.segments[1:]
+		removed++
+	}
+
+	return removed, nil
+}
+
+// newest returns the time of the keep-th newest snapshot, or the zero time
+// when the log holds no more than keep snapshots.
+func (l *Log) newest(keep int) time.Time {
+	distinct := make(map[int64]struct{})
+	for _, seg := range l.segments {
+		for ts := range seg.timestamps {
+			distinct[ts] = struct{}{}
+		}
+	}
+
+	if len(distinct) <= keep {
+		return time.Time{}
+	}
+
+	timestamps := make([]int64, 0, len(distinct))
+	for ts := range distinct {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] > timestamps[j] })
+
+	return time.Unix(0, timestamps[keep-1])
+}
+
+// Writable fails when the last append failed or when no file can be created
+// in the directory of the log, e.g. because the disk is full or read-only.
+func (l *Log) Writable() error {
+	l.mux.Lock()
+	appendErr := l.appendErr
+	l.mux.Unlock()
+
+	if appendErr != nil {
+		return appendErr
+	}
+
+	probe, err := os.CreateTemp(l.dir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("snapshot log directory is not writable: %w", err)
+	}
+	_ = probe.Close()
+
+	return os.Remove(probe.Name())
+}
+
+// TruncatedAt returns the offset at which a corrupt tail was cut off when the
+// log was opened, or 0 if the log was intact.
+func (l *Log) TruncatedAt() int64 {
+	return l.truncatedAt
+}
+
+func (l *Log) Sync() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return l.sync()
+}
+
+func (l *Log) Close() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if err := l.sync(); err != nil {
+		return err
+	}
+
+	return l.active.Close()
+}
+
+func (l *Log) sync() error {
+	if err := l.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync snapshot log: %w", err)
+	}
+	l.lastSync = time.Now()
+
+	return nil
+}
+
+func (l *Log) rollover() error {
+	if err := l.sync(); err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot log segment: %w", err)
+	}
+
+	next := &segment{index: l.segments[len(l.segments)-1].index + 1}
+	l.segments = append(l.segments, next)
+
+	return l.openActive(next.index)
+}
+
+func (l *Log) openActive(index int) error {
+	f, err := os.OpenFile(l.segmentPath(index), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot log segment: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat snapshot log segment: %w", err)
+	}
+
+	l.active = f
+	l.activeSize = info.Size()
+
+	return nil
+}
+
+func (l *Log) segmentPath(index int) string {
+	return filepath.Join(l.dir, segmentFileName(index))
+}
+
+func segmentFileName(index int) string {
+	return fmt.Sprintf("%020d%s", index, segmentExtension)
+}
+
+func listSegments(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot log directory: %w", err)
+	}
+
+	var segments []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+
+		index, err := strconv.Atoi(strings.TrimSuffix(name, segmentExtension))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, index)
+	}
+
+	sort.Ints(segments)
+
+	return segments, nil
+}
+
+// scanSegment reads the records of one segment, passing each decoded snapshot
+// to fn when it is not nil. It returns the offset right after the last valid
+// record; the error wraps errCorruptRecord if a bad record follows it.
+func scanSegment(path string, fn func(model.Snapshot) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, headerSize)
+
+	var offset int64
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("%w: short header at offset %d", errCorruptRecord, offset)
+		}
+
+		size := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		if size > maxRecordSize {
+			return offset, fmt.Errorf("%w: record size %d at offset %d", errCorruptRecord, size, offset)
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, fmt.Errorf("%w: short payload at offset %d", errCorruptRecord, offset)
+		}
+
+		if crc32.Checksum(payload, crcTable) != checksum {
+			return offset, fmt.Errorf("%w: checksum mismatch at offset %d", errCorruptRecord, offset)
+		}
+
+		if fn != nil {
+			snapshot, err := decode(payload)
+			if err != nil {
+				return offset, fmt.Errorf("%w: %v at offset %d", errCorruptRecord, err, offset)
+			}
+			if err := fn(snapshot); err != nil {
+				return offset, err
+			}
+		}
+
+		offset += headerSize + int64(size)
+	}
+}
+
+func encode(snapshot model.Snapshot) ([]byte, error) {
+	rec := record{
+		TimeStamp: snapshot.TimeStamp,
+		Banners:   make([]bannerRecord, 0, len(snapshot.Banners)),
+	}
+	for id, banner := range snapshot.Banners {
+		rec.Banners = append(rec.Banners, bannerRecord{
+			Key:         id,
+			Name:        banner.Name,
+			Count:       banner.Count,
+			Impressions: banner.Impressions,
+			Dimensions:  banner.Dimensions,
+		})
+	}
+
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	frame := make([]byte, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	copy(frame[headerSize:], payload)
+
+	return frame, nil
+}
+
+func decode(payload []byte) (model.Snapshot, error) {
+	var rec record
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return model.Snapshot{}, err
+	}
+
+	snapshot := model.Snapshot{
+		TimeStamp: rec.TimeStamp,
+		Banners:   make(map[model.BannerID]model.Banner, len(rec.Banners)),
+	}
+	for _, b := range rec.Banners {
+		id := b.Key
+		if id == "" {
+			id = model.BannerIDFromInt(b.ID + 1)
+		}
+
+		snapshot.Banners[id] = model.Banner{
+			TimeStamp:   rec.TimeStamp,
+			Name:        b.Name,
+			BannerID:    id,
+			Count:       b.Count,
+			Impressions: b.Impressions,
+			Dimensions:  b.Dimensions,
+		}
+	}
+
+	return snapshot, nil
+}
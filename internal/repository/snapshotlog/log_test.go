@@ -0,0 +1,257 @@
+package snapshotlog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"rsclabs-test/internal/model"
+)
+
+func testSnapshot(ts time.Time, count int) model.Snapshot {
+	return model.Snapshot{
+		TimeStamp: ts,
+		Banners: map[model.BannerID]model.Banner{
+			"3": {BannerID: "3", Name: "Banner 3", Count: count},
+		},
+	}
+}
+
+func replayAll(t *testing.T, l *Log) []model.Snapshot {
+	var out []model.Snapshot
+	require.NoError(t, l.Replay(func(s model.Snapshot) error {
+		out = append(out, s)
+		return nil
+	}))
+
+	return out
+}
+
+func TestAppendAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(testSnapshot(base, 1), testSnapshot(base.Add(time.Minute), 2)))
+	require.NoError(t, l.Close())
+
+	l, err = Open(dir, Options{})
+	require.NoError(t, err)
+	defer l.Close()
+
+	got := replayAll(t, l)
+	require.Len(t, got, 2)
+	assert.True(t, got[0].TimeStamp.Equal(base))
+	assert.Equal(t, 2, got[1].Banners["3"].Count)
+	assert.Equal(t, "Banner 3", got[1].Banners["3"].Name)
+	assert.Equal(t, int64(0), l.TruncatedAt())
+}
+
+func TestSegmentRollover(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+
+	l, err := Open(dir, Options{SegmentSize: 128, SyncPolicy: SyncNever})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, l.Append(testSnapshot(base.Add(time.Duration(i)*time.Minute), i+1)))
+	}
+
+	got := replayAll(t, l)
+	require.NoError(t, l.Close())
+
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+	assert.Greater(t, len(segments), 1, "expected the log to roll over to new segments")
+
+	require.Len(t, got, 10)
+	for i, s := range got {
+		assert.Equal(t, i+1, s.Banners["3"].Count)
+	}
+}
+
+func TestCorruptTailIsTruncated(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, path string)
+	}{
+		{
+			name: "partial record",
+			corrupt: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+				require.NoError(t, err)
+				_, err = f.Write([]byte{42, 0, 0, 0, 1, 2})
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+			},
+		},
+		{
+			name: "checksum mismatch",
+			corrupt: func(t *testing.T, path string) {
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				data[len(data)-2] ^= 0xff
+				require.NoError(t, os.WriteFile(path, data, 0o644))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+
+			l, err := Open(dir, Options{})
+			require.NoError(t, err)
+			require.NoError(t, l.Append(testSnapshot(base, 1)))
+			require.NoError(t, l.Append(testSnapshot(base.Add(time.Minute), 2)))
+			require.NoError(t, l.Close())
+
+			segments, err := listSegments(dir)
+			require.NoError(t, err)
+			tt.corrupt(t, filepath.Join(dir, segmentFileName(segments[len(segments)-1])))
+
+			l, err = Open(dir, Options{})
+			require.NoError(t, err)
+			assert.Greater(t, l.TruncatedAt(), int64(0))
+
+			// The log stays appendable after the truncation
+			require.NoError(t, l.Append(testSnapshot(base.Add(2*time.Minute), 3)))
+			require.NoError(t, l.Close())
+
+			l, err = Open(dir, Options{})
+			require.NoError(t, err)
+			defer l.Close()
+
+			got := replayAll(t, l)
+			assert.Equal(t, int64(0), l.TruncatedAt())
+			require.NotEmpty(t, got)
+			assert.Equal(t, 1, got[0].Banners["3"].Count)
+			assert.Equal(t, 3, got[len(got)-1].Banners["3"].Count)
+		})
+	}
+}
+
+func TestUnknownSyncPolicy(t *testing.T) {
+	_, err := Open(t.TempDir(), Options{SyncPolicy: "sometimes"})
+	assert.Error(t, err)
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+
+	l, err := Open(dir, Options{SegmentSize: 128, SyncPolicy: SyncNever})
+	require.NoError(t, err)
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, l.Append(testSnapshot(base.Add(time.Duration(i)*time.Minute), i+1)))
+	}
+
+	removed, err := l.Compact(base.Add(5*time.Minute), 0)
+	require.NoError(t, err)
+	assert.Greater(t, removed, 0)
+
+	got := replayAll(t, l)
+	require.NotEmpty(t, got)
+	// Whole segments are dropped, so a few expired records may survive, but
+	// nothing inside the retention may be lost
+	assert.False(t, got[0].TimeStamp.After(base.Add(5*time.Minute)))
+	assert.Equal(t, 10, got[len(got)-1].Banners["3"].Count)
+
+	_, err = l.Compact(time.Time{}, 2)
+	require.NoError(t, err)
+
+	got = replayAll(t, l)
+	assert.GreaterOrEqual(t, len(got), 2)
+	assert.Equal(t, 10, got[len(got)-1].Banners["3"].Count)
+}
+
+func TestDecodeStringAndLegacyIDs(t *testing.T) {
+	frame, err := encode(model.Snapshot{
+		TimeStamp: time.Date(2025, 6, 6, 1, 30, 0, 0, time.UTC),
+		Banners: map[model.BannerID]model.Banner{
+			"summer-sale": {Name: "Summer sale", Count: 2},
+			"7":           {Name: "Banner 7", Count: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	got, err := decode(frame[headerSize:])
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Banners["summer-sale"].Count)
+	assert.Equal(t, model.BannerID("7"), got.Banners["7"].BannerID)
+
+	// Records written before string IDs carry the zero-based index
+	got, err = decode([]byte(`{"ts":"2025-06-06T01:30:00Z","banners":[{"id":0,"name":"Banner 0","v":3},{"id":4,"name":"Banner 4","v":1}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, 3, got.Banners["1"].Count)
+	assert.Equal(t, 1, got.Banners["5"].Count)
+}
+
+func TestWritable(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Writable())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the probe file is removed")
+
+	require.NoError(t, l.active.Close())
+	require.Error(t, l.Append(testSnapshot(base, 1)))
+	assert.Error(t, l.Writable(), "a failed append makes the log unwritable")
+
+	l.appendErr = nil
+	require.NoError(t, os.RemoveAll(dir))
+	assert.Error(t, l.Writable())
+}
+
+func TestCompactCountsRewritesOnce(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+
+	l, err := Open(dir, Options{SegmentSize: 128, SyncPolicy: SyncNever})
+	require.NoError(t, err)
+	defer l.Close()
+
+	require.NoError(t, l.Append(testSnapshot(base, 1), testSnapshot(base.Add(time.Minute), 1)))
+	// The rewrites of the newest snapshot fill the segments that follow
+	for count := 1; count <= 5; count++ {
+		require.NoError(t, l.Append(testSnapshot(base.Add(2*time.Minute), count)))
+	}
+
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(segments), 3, "expected the rewrites to cross segment boundaries")
+
+	// Three snapshots are within a count retention of 3, however many records
+	// they took
+	_, err = l.Compact(time.Time{}, 3)
+	require.NoError(t, err)
+
+	latest := make(map[time.Time]int)
+	for _, snapshot := range replayAll(t, l) {
+		latest[snapshot.TimeStamp] = snapshot.Banners["3"].Count
+	}
+	assert.Equal(t, map[time.Time]int{base: 1, base.Add(time.Minute): 1, base.Add(2 * time.Minute): 5}, latest)
+
+	// With a retention of 1, the segments before the newest snapshot go
+	removed, err := l.Compact(time.Time{}, 1)
+	require.NoError(t, err)
+	assert.Greater(t, removed, 0)
+
+	got := replayAll(t, l)
+	require.NotEmpty(t, got)
+	assert.Equal(t, 5, got[len(got)-1].Banners["3"].Count)
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository"
+	"rsclabs-test/pkg/observe"
+)
+
+const maxCatalogFieldLength = 128
+
+var (
+	ErrBannerNotFound = errors.New("banner not found")
+	ErrBannerInactive = errors.New("banner is not active")
+	ErrBannerExists   = errors.New("banner already exists")
+	ErrCatalogFull    = errors.New("banner catalog is full")
+	ErrInvalidBanner  = errors.New("invalid banner")
+)
+
+// BannerUpdate holds the catalog fields to change; nil fields are kept.
+type BannerUpdate struct {
+	Name       *string
+	LandingURL *string
+	Status     *model.BannerStatus
+	Campaign   *string
+}
+
+// CatalogService manages the banner catalog. The catalog is cached in memory,
+// so that the click path validates banners without touching the store.
+type CatalogService struct {
+	store      repository.BannerCatalog
+	validateID func(model.BannerID) error
+	l          *observe.Logger
+
+	// update serializes the changes, mux guards the cache
+	update  sync.Mutex
+	mux     sync.RWMutex
+	banners map[model.BannerID]model.CatalogBanner
+}
+
+// NewCatalogService loads the catalog from the store. Only the banner IDs
+// accepted by validateID, usually the one of the click counters, can be
+// created.
+func NewCatalogService(
+	ctx context.Context,
+	store repository.BannerCatalog,
+	validateID func(model.BannerID) error,
+	l *observe.Logger,
+) (*CatalogService, error) {
+	banners, err := store.ListBanners(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load banner catalog: %w", err)
+	}
+
+	s := &CatalogService{
+		store:      store,
+		validateID: validateID,
+		l:          l,
+		banners:    make(map[model.BannerID]model.CatalogBanner, len(banners)),
+	}
+
+	for _, banner := range banners {
+		s.banners[banner.ID] = banner
+	}
+
+	return s, nil
+}
+
+// List returns the catalog ordered by ID.
+func (s *CatalogService) List() []model.CatalogBanner {
+	s.mux.RLock()
+	banners := make([]model.CatalogBanner, 0, len(s.banners))
+	for _, banner := range s.banners {
+		banners = append(banners, banner)
+	}
+	s.mux.RUnlock()
+
+	sort.Slice(banners, func(i, j int) bool {
+		return banners[i].ID.Less(banners[j].ID)
+	})
+
+	return banners
+}
+
+func (s *CatalogService) Get(id model.BannerID) (model.CatalogBanner, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	banner, ok := s.banners[id]
+	if !ok {
+		return model.CatalogBanner{}, fmt.Errorf("%w: %s", ErrBannerNotFound, id)
+	}
+
+	return banner, nil
+}
+
+// Active returns the banner if it exists and counts clicks.
+func (s *CatalogService) Active(id model.BannerID) (model.CatalogBanner, error) {
+	banner, err := s.Get(id)
+	if err != nil {
+		return model.CatalogBanner{}, err
+	}
+
+	if !banner.IsActive() {
+		return model.CatalogBanner{}, fmt.Errorf("%w: %s is %s", ErrBannerInactive, id, banner.Status)
+	}
+
+	return banner, nil
+}
+
+// Create adds a banner to the catalog. An empty ID picks the lowest free
+// integer one.
+func (s *CatalogService) Create(ctx context.Context, banner model.CatalogBanner) (model.CatalogBanner, error) {
+	s.update.Lock()
+	defer s.update.Unlock()
+
+	if banner.ID == "" {
+		banner.ID = s.freeID()
+		if banner.ID == "" {
+			return model.CatalogBanner{}, fmt.Errorf("%w: all banner IDs are taken", ErrCatalogFull)
+		}
+	}
+
+	if err := s.validateID(banner.ID); err != nil {
+		return model.CatalogBanner{}, fmt.Errorf("%w: %w", ErrInvalidBanner, err)
+	}
+
+	if _, err := s.Get(banner.ID); err == nil {
+		return model.CatalogBanner{}, fmt.Errorf("%w: %s", ErrBannerExists, banner.ID)
+	}
+
+	if banner.Status == "" {
+		banner.Status = model.BannerStatusActive
+	}
+
+	if err := validateBanner(banner); err != nil {
+		return model.CatalogBanner{}, err
+	}
+
+	banner.CreatedAt = time.Now().UTC()
+	banner.UpdatedAt = banner.CreatedAt
+
+	return banner, s.save(ctx, banner)
+}
+
+// Update changes the given fields of a banner.
+func (s *CatalogService) Update(ctx context.Context, id model.BannerID, update BannerUpdate) (model.CatalogBanner, error) {
+	s.update.Lock()
+	defer s.update.Unlock()
+
+	banner, err := s.Get(id)
+	if err != nil {
+		return model.CatalogBanner{}, err
+	}
+
+	if update.Name != nil {
+		banner.Name = *update.Name
+	}
+	if update.LandingURL != nil {
+		banner.LandingURL = *update.LandingURL
+	}
+	if update.Status != nil {
+		banner.Status = *update.Status
+	}
+	if update.Campaign != nil {
+		banner.Campaign = *update.Campaign
+	}
+
+	if err := validateBanner(banner); err != nil {
+		return model.CatalogBanner{}, err
+	}
+
+	banner.UpdatedAt = time.Now().UTC()
+
+	return banner, s.save(ctx, banner)
+}
+
+// Retire stops a banner from counting. Retired banners stay in the catalog, so
+// their statistics keep their names.
+func (s *CatalogService) Retire(ctx context.Context, id model.BannerID) (model.CatalogBanner, error) {
+	status := model.BannerStatusRetired
+	return s.Update(ctx, id, BannerUpdate{Status: &status})
+}
+
+// Name returns the catalog name of the banner.
+func (s *CatalogService) Name(id model.BannerID) (string, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	banner, ok := s.banners[id]
+
+	return banner.Name, ok
+}
+
+func (s *CatalogService) save(ctx context.Context, banner model.CatalogBanner) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	if err := s.store.SaveBanner(ctx, banner); err != nil {
+		return fmt.Errorf("failed to save banner %s: %w", banner.ID, err)
+	}
+
+	s.mux.Lock()
+	s.banners[banner.ID] = banner
+	s.mux.Unlock()
+
+	return nil
+}
+
+// freeID returns the lowest unused integer banner ID, or an empty ID once the
+// counters accept no further integer IDs.
+func (s *CatalogService) freeID() model.BannerID {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	for n := 1; ; n++ {
+		id := model.BannerIDFromInt(n)
+		if s.validateID(id) != nil {
+			return ""
+		}
+
+		if _, ok := s.banners[id]; !ok {
+			return id
+		}
+	}
+}
+
+func validateBanner(banner model.CatalogBanner) error {
+	name := strings.TrimSpace(banner.Name)
+	if name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidBanner)
+	}
+
+	if len(name) > maxCatalogFieldLength || len(banner.Campaign) > maxCatalogFieldLength {
+		return fmt.Errorf("%w: name and campaign must not exceed %d characters", ErrInvalidBanner, maxCatalogFieldLength)
+	}
+
+	if _, err := model.ParseBannerStatus(string(banner.Status)); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBanner, err)
+	}
+
+	if banner.LandingURL != "" {
+		u, err := url.Parse(banner.LandingURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: landing URL must be an absolute http(s) URL", ErrInvalidBanner)
+		}
+	}
+
+	return nil
+}
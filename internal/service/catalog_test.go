@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository"
+	"rsclabs-test/internal/repository/inmemorystorage"
+	"rsclabs-test/pkg/observe"
+)
+
+func setupTestCatalog(t *testing.T, path string, maxBanners int) *CatalogService {
+	counter, err := repository.NewBannerRepository(inmemorystorage.NewInMemoryStorage(maxBanners, 100, nil))
+	require.NoError(t, err)
+
+	return setupTestCatalogWith(t, path, counter)
+}
+
+func setupTestCatalogWith(t *testing.T, path string, counter repository.ClickCounter) *CatalogService {
+	repo, err := repository.NewCatalogRepository(path)
+	require.NoError(t, err)
+
+	catalog, err := NewCatalogService(context.Background(), repo, counter.ValidateID, observe.NewZapLogger("test-app"))
+	require.NoError(t, err)
+
+	return catalog
+}
+
+func TestCatalogCreate(t *testing.T) {
+	catalog := setupTestCatalog(t, "", 3)
+	ctx := context.Background()
+
+	created, err := catalog.Create(ctx, model.CatalogBanner{ID: "2", Name: "Summer sale"})
+	require.NoError(t, err)
+	assert.Equal(t, model.BannerStatusActive, created.Status)
+	assert.False(t, created.CreatedAt.IsZero())
+
+	// IDs are assigned from the lowest free one
+	created, err = catalog.Create(ctx, model.CatalogBanner{Name: "Winter sale"})
+	require.NoError(t, err)
+	assert.Equal(t, model.BannerID("1"), created.ID)
+
+	_, err = catalog.Create(ctx, model.CatalogBanner{ID: "2", Name: "Duplicate"})
+	assert.ErrorIs(t, err, ErrBannerExists)
+
+	_, err = catalog.Create(ctx, model.CatalogBanner{ID: "4", Name: "Out of range"})
+	assert.ErrorIs(t, err, ErrInvalidBanner)
+
+	_, err = catalog.Create(ctx, model.CatalogBanner{ID: "summer-sale", Name: "Not an integer"})
+	assert.ErrorIs(t, err, ErrInvalidBanner)
+
+	_, err = catalog.Create(ctx, model.CatalogBanner{Name: "   "})
+	assert.ErrorIs(t, err, ErrInvalidBanner)
+
+	_, err = catalog.Create(ctx, model.CatalogBanner{Name: "Bad URL", LandingURL: "javascript:alert(1)"})
+	assert.ErrorIs(t, err, ErrInvalidBanner)
+
+	_, err = catalog.Create(ctx, model.CatalogBanner{Name: "Third"})
+	require.NoError(t, err)
+
+	_, err = catalog.Create(ctx, model.CatalogBanner{Name: "Fourth"})
+	assert.ErrorIs(t, err, ErrCatalogFull)
+}
+
+func TestCatalogStringIDs(t *testing.T) {
+	counter := repository.NewSparseBannerRepository(inmemorystorage.NewSparseStorage(10, time.Hour, 100, nil))
+	catalog := setupTestCatalogWith(t, "", counter)
+	ctx := context.Background()
+
+	_, err := catalog.Create(ctx, model.CatalogBanner{ID: "summer-sale", Name: "Summer sale"})
+	require.NoError(t, err)
+
+	_, err = catalog.Create(ctx, model.CatalogBanner{ID: "summer sale", Name: "Invalid ID"})
+	assert.ErrorIs(t, err, ErrInvalidBanner)
+
+	// Without an ID the lowest free integer one is picked
+	created, err := catalog.Create(ctx, model.CatalogBanner{Name: "Winter sale"})
+	require.NoError(t, err)
+	assert.Equal(t, model.BannerID("1"), created.ID)
+
+	banners := catalog.List()
+	require.Len(t, banners, 2)
+	assert.Equal(t, model.BannerID("1"), banners[0].ID)
+	assert.Equal(t, model.BannerID("summer-sale"), banners[1].ID)
+}
+
+func TestCatalogUpdateAndRetire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	catalog := setupTestCatalog(t, path, 10)
+	ctx := context.Background()
+
+	_, err := catalog.Create(ctx, model.CatalogBanner{ID: "5", Name: "Summer sale", Campaign: "summer"})
+	require.NoError(t, err)
+
+	name := "Summer sale 2"
+	updated, err := catalog.Update(ctx, "5", BannerUpdate{Name: &name})
+	require.NoError(t, err)
+	assert.Equal(t, "Summer sale 2", updated.Name)
+	assert.Equal(t, "summer", updated.Campaign)
+
+	_, err = catalog.Active("5")
+	require.NoError(t, err)
+
+	_, err = catalog.Retire(ctx, "5")
+	require.NoError(t, err)
+
+	_, err = catalog.Active("5")
+	assert.ErrorIs(t, err, ErrBannerInactive)
+
+	_, err = catalog.Update(ctx, "6", BannerUpdate{Name: &name})
+	assert.ErrorIs(t, err, ErrBannerNotFound)
+
+	// The catalog survives a restart
+	reloaded := setupTestCatalog(t, path, 10)
+	banner, err := reloaded.Get("5")
+	require.NoError(t, err)
+	assert.Equal(t, "Summer sale 2", banner.Name)
+	assert.Equal(t, model.BannerStatusRetired, banner.Status)
+}
+
+func TestStatisticsUseCatalogNames(t *testing.T) {
+	s := setupTestService()
+	catalog := setupTestCatalog(t, "", 10)
+	s.catalog = catalog
+	ctx := context.Background()
+
+	_, err := catalog.Create(ctx, model.CatalogBanner{ID: "1", Name: "Summer sale"})
+	require.NoError(t, err)
+
+	require.NoError(t, s.counter.RegisterClick("1"))
+	s.RegisterStatistics(ctx)
+
+	snapshots := s.GetSnapshots()
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "Summer sale", snapshots[0].Banners["1"].Name)
+
+	resp, err := s.GetStatistics(ctx, model.StatisticsRequest{BannerID: "1"})
+	require.NoError(t, err)
+	require.Len(t, resp.Stats, 1)
+	assert.Equal(t, "Summer sale", resp.Stats[0].Name)
+
+	_, err = s.GetStatistics(ctx, model.StatisticsRequest{BannerID: "2"})
+	assert.ErrorIs(t, err, ErrBannerNotFound)
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"rsclabs-test/internal/model"
+)
+
+// ErrUnsupportedWindow is returned for a leaderboard window the statistics
+// service keeps no running totals for.
+var ErrUnsupportedWindow = errors.New("unsupported leaderboard window")
+
+// leaderboardWindows are the windows the leaderboard keeps running totals
+// for, shortest first.
+var leaderboardWindows = []time.Duration{
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// LeaderboardWindows returns the windows banners can be ranked over.
+func LeaderboardWindows() []time.Duration {
+	return append([]time.Duration(nil), leaderboardWindows...)
+}
+
+// TopBanners ranks the banners over the last window by clicks or by
+// click-through rate, as of the last rotation of the counters. At most n
+// banners are returned; a banner without clicks, or without impressions for
+// the CTR ranking, is not ranked.
+func (s *StatisticsService) TopBanners(n int, window time.Duration, by model.Ranking) ([]model.LeaderboardEntry, error) {
+	top, err := s.top.top(n, window, by)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.catalog != nil {
+		for i := range top {
+			if name, ok := s.catalog.Name(top[i].ID); ok {
+				top[i].Name = name
+			}
+		}
+	}
+
+	return top, nil
+}
+
+type leaderCounts struct {
+	name        string
+	clicks      int
+	impressions int
+}
+
+type leaderboardMinute struct {
+	ts     time.Time
+	counts map[model.BannerID]leaderCounts
+}
+
+type leaderboardWindow struct {
+	size time.Duration
+	// start is the index of the oldest minute within the window
+	start  int
+	totals map[model.BannerID]*leaderCounts
+}
+
+// leaderboard keeps the counts of every banner summed over sliding windows.
+// A minute snapshot is added to the totals once and subtracted once a window
+// slides past it, so a ranking never rescans the history.
+type leaderboard struct {
+	mux sync.Mutex
+	// minutes holds the minutes of the longest window, oldest first
+	minutes []leaderboardMinute
+	windows []*leaderboardWindow
+	now     func() time.Time
+}
+
+func newLeaderboard(sizes []time.Duration) *leaderboard {
+	b := &leaderboard{now: time.Now}
+	for _, size := range sizes {
+		b.windows = append(b.windows, &leaderboardWindow{
+			size:   size,
+			totals: make(map[model.BannerID]*leaderCounts),
+		})
+	}
+
+	return b
+}
+
+// longest returns the size of the longest window.
+func (b *leaderboard) longest() time.Duration {
+	return b.windows[len(b.windows)-1].size
+}
+
+// add counts a minute snapshot. A minute may come in more than once, as it
+// can be rotated before it ends, and late: the counts are added up in place.
+func (b *leaderboard) add(snapshot model.Snapshot) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	now := b.now()
+	if snapshot.TimeStamp.Before(now.Add(-b.longest())) {
+		return
+	}
+
+	i := len(b.minutes)
+	for i > 0 && b.minutes[i-1].ts.After(snapshot.TimeStamp) {
+		i--
+	}
+
+	if i > 0 && b.minutes[i-1].ts.Equal(snapshot.TimeStamp) {
+		i--
+	} else {
+		b.minutes = append(b.minutes, leaderboardMinute{})
+		copy(b.minutes[i+1:], b.minutes[i:])
+		b.minutes[i] = leaderboardMinute{
+			ts:     snapshot.TimeStamp,
+			counts: make(map[model.BannerID]leaderCounts, len(snapshot.Banners)),
+		}
+
+		// A minute older than the start of a window has slid out of it
+		for _, w := range b.windows {
+			if i < w.start {
+				w.start++
+			}
+		}
+	}
+
+	minute := b.minutes[i]
+	for id, banner := range snapshot.Banners {
+		// Keeping empty counts would let a total drop to zero, and be
+		// removed, while the minutes it sums up are still in the window
+		if banner.IsEmpty() {
+			continue
+		}
+
+		c := minute.counts[id]
+		c.name = banner.Name
+		c.clicks += banner.Count
+		c.impressions += banner.Impressions
+		minute.counts[id] = c
+
+		for _, w := range b.windows {
+			if i < w.start {
+				continue
+			}
+
+			total, ok := w.totals[id]
+			if !ok {
+				total = &leaderCounts{}
+				w.totals[id] = total
+			}
+			total.name = banner.Name
+			total.clicks += banner.Count
+			total.impressions += banner.Impressions
+		}
+	}
+
+	b.advance(now)
+}
+
+// advance slides the windows up to now and drops the minutes that have left
+// the longest one.
+func (b *leaderboard) advance(now time.Time) {
+	for _, w := range b.windows {
+		cutoff := now.Add(-w.size)
+		for ; w.start < len(b.minutes) && b.minutes[w.start].ts.Before(cutoff); w.start++ {
+			for id, c := range b.minutes[w.start].counts {
+				total := w.totals[id]
+				total.clicks -= c.clicks
+				total.impressions -= c.impressions
+				if total.clicks == 0 && total.impressions == 0 {
+					delete(w.totals, id)
+				}
+			}
+		}
+	}
+
+	drop := b.windows[len(b.windows)-1].start
+	if drop == 0 {
+		return
+	}
+
+	clear(b.minutes[:drop])
+	b.minutes = b.minutes[drop:]
+	for _, w := range b.windows {
+		w.start -= drop
+	}
+}
+
+// top returns the n best ranked banners of the window. It selects them with
+// a heap of size n, in O(banners * log n).
+func (b *leaderboard) top(n int, window time.Duration, by model.Ranking) ([]model.LeaderboardEntry, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid leaderboard size %d", n)
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.advance(b.now())
+
+	var w *leaderboardWindow
+	for _, candidate := range b.windows {
+		if candidate.size == window {
+			w = candidate
+		}
+	}
+	if w == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedWindow, window)
+	}
+
+	ranked := &rankHeap{entries: make([]model.LeaderboardEntry, 0, min(n, len(w.totals))), by: by}
+	for id, total := range w.totals {
+		if total.clicks == 0 || (by == model.RankByCTR && total.impressions == 0) {
+			continue
+		}
+
+		entry := model.LeaderboardEntry{
+			ID:          id,
+			Name:        total.name,
+			Count:       total.clicks,
+			Impressions: total.impressions,
+		}
+		if total.impressions > 0 {
+			entry.CTR = float64(total.clicks) / float64(total.impressions)
+		}
+
+		switch {
+		case ranked.Len() < n:
+			heap.Push(ranked, entry)
+		case ranked.better(entry, ranked.entries[0]):
+			ranked.entries[0] = entry
+			heap.Fix(ranked, 0)
+		}
+	}
+
+	top := ranked.entries
+	sort.Slice(top, func(i, j int) bool {
+		return ranked.better(top[i], top[j])
+	})
+	for i := range top {
+		top[i].Rank = i + 1
+	}
+
+	return top, nil
+}
+
+// rankHeap is a min-heap of leaderboard entries: the worst ranked entry is
+// on top, ready to be replaced by a better one.
+type rankHeap struct {
+	entries []model.LeaderboardEntry
+	by      model.Ranking
+}
+
+// better reports whether a ranks above b. Ties are broken by clicks, then by
+// ID, so that the ranking is stable.
+func (h *rankHeap) better(a, b model.LeaderboardEntry) bool {
+	if h.by == model.RankByCTR && a.CTR != b.CTR {
+		return a.CTR > b.CTR
+	}
+
+	if a.Count != b.Count {
+		return a.Count > b.Count
+	}
+
+	return a.ID.Less(b.ID)
+}
+
+func (h *rankHeap) Len() int           { return len(h.entries) }
+func (h *rankHeap) Less(i, j int) bool { return h.better(h.entries[j], h.entries[i]) }
+func (h *rankHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *rankHeap) Push(x any)         { h.entries = append(h.entries, x.(model.LeaderboardEntry)) }
+
+func (h *rankHeap) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
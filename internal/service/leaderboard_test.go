@@ -0,0 +1,128 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"rsclabs-test/internal/model"
+)
+
+func setupTestLeaderboard(now time.Time) (*leaderboard, *time.Time) {
+	b := newLeaderboard(leaderboardWindows)
+	clock := now
+	b.now = func() time.Time { return clock }
+
+	return b, &clock
+}
+
+func leaderSnapshot(ts time.Time, counts map[model.BannerID][2]int) model.Snapshot {
+	banners := make(map[model.BannerID]model.Banner, len(counts))
+	for id, c := range counts {
+		banners[id] = model.Banner{BannerID: id, Name: "Banner " + string(id), Count: c[0], Impressions: c[1]}
+	}
+
+	return model.Snapshot{TimeStamp: ts, Banners: banners}
+}
+
+func TestLeaderboardSlidingWindows(t *testing.T) {
+	now := time.Date(2025, 6, 6, 12, 0, 30, 0, time.UTC)
+	b, clock := setupTestLeaderboard(now)
+
+	b.add(leaderSnapshot(now.Add(-70*time.Minute), map[model.BannerID][2]int{"1": {50, 0}}))
+	b.add(leaderSnapshot(now.Add(-30*time.Minute), map[model.BannerID][2]int{"2": {5, 100}, "3": {4, 10}}))
+	b.add(leaderSnapshot(now.Add(-2*time.Minute), map[model.BannerID][2]int{"3": {3, 0}}))
+
+	top, err := b.top(10, time.Hour, model.RankByClicks)
+	require.NoError(t, err)
+	require.Len(t, top, 2)
+	assert.Equal(t, model.BannerID("3"), top[0].ID)
+	assert.Equal(t, 7, top[0].Count)
+	assert.Equal(t, 1, top[0].Rank)
+	assert.Equal(t, model.BannerID("2"), top[1].ID)
+
+	top, err = b.top(10, 6*time.Hour, model.RankByClicks)
+	require.NoError(t, err)
+	assert.Equal(t, model.BannerID("1"), top[0].ID)
+
+	top, err = b.top(10, time.Hour, model.RankByCTR)
+	require.NoError(t, err)
+	require.Len(t, top, 2)
+	assert.Equal(t, model.BannerID("3"), top[0].ID)
+	assert.InDelta(t, 0.7, top[0].CTR, 1e-9)
+
+	top, err = b.top(1, 5*time.Minute, model.RankByClicks)
+	require.NoError(t, err)
+	require.Len(t, top, 1)
+	assert.Equal(t, 3, top[0].Count)
+
+	// A late delta of an already counted minute is added up
+	b.add(leaderSnapshot(now.Add(-30*time.Minute), map[model.BannerID][2]int{"2": {10, 0}}))
+	top, _ = b.top(1, time.Hour, model.RankByClicks)
+	assert.Equal(t, model.BannerID("2"), top[0].ID)
+	assert.Equal(t, 15, top[0].Count)
+
+	*clock = now.Add(31 * time.Minute)
+	top, _ = b.top(10, time.Hour, model.RankByClicks)
+	require.Len(t, top, 1)
+	assert.Equal(t, model.BannerID("3"), top[0].ID)
+	assert.Equal(t, 3, top[0].Count)
+
+	_, err = b.top(10, 2*time.Hour, model.RankByClicks)
+	assert.ErrorIs(t, err, ErrUnsupportedWindow)
+}
+
+func TestLeaderboardMatchesRescan(t *testing.T) {
+	start := time.Date(2025, 6, 6, 0, 0, 0, 0, time.UTC)
+	b, clock := setupTestLeaderboard(start)
+	rnd := rand.New(rand.NewSource(1))
+
+	var added []model.Snapshot
+	for step := 0; step < 3000; step++ {
+		*clock = clock.Add(time.Duration(rnd.Intn(3)) * time.Minute)
+
+		// Mostly the previous minute, sometimes a late or a repeated one
+		ts := clock.Truncate(time.Minute).Add(-time.Duration(1+rnd.Intn(3)) * time.Minute)
+		counts := make(map[model.BannerID][2]int)
+		for i := 0; i < 1+rnd.Intn(4); i++ {
+			counts[model.BannerID(fmt.Sprint(rnd.Intn(30)))] = [2]int{rnd.Intn(5), rnd.Intn(20)}
+		}
+		snapshot := leaderSnapshot(ts, counts)
+		added = append(added, snapshot)
+		b.add(snapshot)
+
+		if step%100 != 0 {
+			continue
+		}
+
+		for _, window := range leaderboardWindows {
+			want := make(map[model.BannerID]int)
+			for _, s := range added {
+				if s.TimeStamp.Before(clock.Add(-window)) {
+					continue
+				}
+				for id, banner := range s.Banners {
+					want[id] += banner.Count
+				}
+			}
+
+			top, err := b.top(100, window, model.RankByClicks)
+			require.NoError(t, err)
+
+			got := make(map[model.BannerID]int)
+			for _, entry := range top {
+				got[entry.ID] = entry.Count
+			}
+			for id, clicks := range want {
+				if clicks == 0 {
+					delete(want, id)
+				}
+			}
+			require.Equal(t, want, got, "window %s at step %d", window, step)
+		}
+	}
+}
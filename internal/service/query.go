@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/pkg/observe"
+)
+
+// QueryStatistics returns the series of every requested banner and their
+// aggregate, in one pass over the snapshots of the range.
+func (s *StatisticsService) QueryStatistics(
+	ctx context.Context,
+	query model.StatisticsQuery,
+) (_ model.StatisticsQueryResponse, err error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	ctx, span := observe.StartSpan(ctx, "StatisticsService.QueryStatistics", append(
+		requestAttributes(query.StatisticsRequest),
+		attribute.Int("banners", len(query.BannerIDs)),
+		attribute.Bool("all", query.All),
+	)...)
+	defer func() { observe.EndSpan(span, err) }()
+
+	out := model.StatisticsQueryResponse{
+		Banners:   make([]model.BannerSeries, 0),
+		Aggregate: make([]model.Banner, 0),
+	}
+
+	granularity, err := model.ParseGranularity(string(query.Granularity))
+	if err != nil {
+		return out, err
+	}
+
+	loc, err := requestLocation(query.TZ)
+	if err != nil {
+		return out, err
+	}
+
+	series := make(map[model.BannerID]*model.BannerSeries, len(query.BannerIDs))
+	for _, id := range query.BannerIDs {
+		if err := s.checkBanner(id); err != nil {
+			return out, err
+		}
+		series[id] = s.newSeries(id)
+	}
+
+	snapshots, from, to, err := s.rangeSnapshots(ctx, query.StatisticsRequest, granularity, loc)
+	if err != nil {
+		return out, err
+	}
+
+	for _, snapshot := range snapshots {
+		total := model.Banner{TimeStamp: snapshot.TimeStamp}
+		counted := false
+
+		add := func(id model.BannerID, banner model.Banner) {
+			sum(&total, banner, query.GroupBy)
+			counted = true
+
+			banner.TimeStamp = snapshot.TimeStamp
+			banner.Dimensions = groupBy(banner.Dimensions, query.GroupBy)
+			withCTR(&banner)
+
+			entry, ok := series[id]
+			if !ok {
+				entry = s.newSeries(id)
+				series[id] = entry
+			}
+			if entry.Name == "" {
+				entry.Name = banner.Name
+			}
+			banner.Name = entry.Name
+			entry.Stats = append(entry.Stats, banner)
+		}
+
+		if query.All {
+			for id, banner := range snapshot.Banners {
+				add(id, banner)
+			}
+		} else {
+			for _, id := range query.BannerIDs {
+				if banner, ok := snapshot.Banners[id]; ok {
+					add(id, banner)
+				}
+			}
+		}
+
+		if counted {
+			withCTR(&total)
+			out.Aggregate = append(out.Aggregate, total)
+		}
+	}
+
+	// Every series is filled over the same buckets as the aggregate
+	if query.FillGaps && from.IsZero() && len(out.Aggregate) > 0 {
+		from = out.Aggregate[0].TimeStamp
+	}
+
+	for id, entry := range series {
+		if entry.Name == "" {
+			entry.Name = string(id)
+		}
+
+		if query.FillGaps {
+			entry.Stats, err = fillGaps(entry.Stats, from, to, granularity, func(ts time.Time) model.Banner {
+				return zeroBucket(ts, id, entry.Name, query.GroupBy)
+			})
+			if err != nil {
+				return out, err
+			}
+		}
+
+		if query.TZ != "" {
+			inZone(entry.Stats, loc)
+		}
+
+		out.Banners = append(out.Banners, *entry)
+	}
+
+	if query.FillGaps {
+		out.Aggregate, err = fillGaps(out.Aggregate, from, to, granularity, func(ts time.Time) model.Banner {
+			return zeroBucket(ts, "", "", query.GroupBy)
+		})
+		if err != nil {
+			return out, err
+		}
+	}
+
+	if query.TZ != "" {
+		inZone(out.Aggregate, loc)
+	}
+
+	sort.Slice(out.Banners, func(i, j int) bool {
+		return out.Banners[i].ID.Less(out.Banners[j].ID)
+	})
+
+	return out, nil
+}
+
+// GetTotals sums the statistics of all banners per time bucket of the
+// request's range; the banner ID of the request is ignored.
+func (s *StatisticsService) GetTotals(
+	ctx context.Context,
+	request model.StatisticsRequest,
+) (_ model.TotalsResponse, err error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	ctx, span := observe.StartSpan(ctx, "StatisticsService.GetTotals", requestAttributes(request)...)
+	defer func() { observe.EndSpan(span, err) }()
+
+	out := model.TotalsResponse{
+		Stats: make([]model.Banner, 0),
+	}
+
+	granularity, err := model.ParseGranularity(string(request.Granularity))
+	if err != nil {
+		return out, err
+	}
+
+	loc, err := requestLocation(request.TZ)
+	if err != nil {
+		return out, err
+	}
+
+	snapshots, from, to, err := s.rangeSnapshots(ctx, request, granularity, loc)
+	if err != nil {
+		return out, err
+	}
+
+	banners := make(map[model.BannerID]struct{})
+	for _, snapshot := range snapshots {
+		total := model.Banner{TimeStamp: snapshot.TimeStamp}
+		for id, banner := range snapshot.Banners {
+			banners[id] = struct{}{}
+			sum(&total, banner, request.GroupBy)
+		}
+		withCTR(&total)
+
+		out.Count += total.Count
+		out.Impressions += total.Impressions
+		out.Stats = append(out.Stats, total)
+	}
+
+	if request.FillGaps {
+		out.Stats, err = fillGaps(out.Stats, from, to, granularity, func(ts time.Time) model.Banner {
+			return zeroBucket(ts, "", "", request.GroupBy)
+		})
+		if err != nil {
+			return out, err
+		}
+	}
+
+	if request.TZ != "" {
+		inZone(out.Stats, loc)
+	}
+
+	out.Banners = len(banners)
+	if out.Impressions > 0 {
+		out.CTR = float64(out.Count) / float64(out.Impressions)
+	}
+
+	return out, nil
+}
+
+// newSeries starts the series of a banner, named after the catalog. Without a
+// catalog entry, the series takes the name of the banner's first snapshot.
+func (s *StatisticsService) newSeries(id model.BannerID) *model.BannerSeries {
+	entry := &model.BannerSeries{
+		ID:    id,
+		Stats: make([]model.Banner, 0),
+	}
+
+	if s.catalog != nil {
+		if name, ok := s.catalog.Name(id); ok {
+			entry.Name = name
+		}
+	}
+
+	return entry
+}
+
+// sum adds the counts of the banner to the total, with the breakdown by the
+// dimension, if any.
+func sum(total *model.Banner, banner model.Banner, dimension model.Dimension) {
+	total.Count += banner.Count
+	total.Impressions += banner.Impressions
+
+	if dimension == "" {
+		return
+	}
+
+	if total.Dimensions == nil {
+		total.Dimensions = model.DimensionCounts{dimension: make(map[string]int)}
+	}
+	for value, clicks := range banner.Dimensions[dimension] {
+		total.Dimensions.Add(dimension, value, clicks)
+	}
+}
+
+// withCTR sets the click-through rate of a bucket with impressions.
+func withCTR(banner *model.Banner) {
+	if banner.Impressions > 0 {
+		banner.CTR = float64(banner.Count) / float64(banner.Impressions)
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"rsclabs-test/internal/model"
+)
+
+func TestQueryStatistics(t *testing.T) {
+	s := setupTestService()
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+	require.NoError(t, s.RestoreSnapshots(ctx, []model.Snapshot{
+		{
+			TimeStamp: base,
+			Banners: map[model.BannerID]model.Banner{
+				"1": {BannerID: "1", Name: "Banner 1", Count: 2, Impressions: 10},
+				"2": {BannerID: "2", Name: "Banner 2", Count: 3, Impressions: 10},
+				"3": {BannerID: "3", Name: "Banner 3", Count: 4},
+			},
+		},
+		{
+			TimeStamp: base.Add(time.Minute),
+			Banners: map[model.BannerID]model.Banner{
+				"2": {BannerID: "2", Name: "Banner 2", Count: 1},
+			},
+		},
+	}))
+
+	request := model.StatisticsRequest{From: "2025-06-05T00:00:00", To: "2025-06-07T00:00:00"}
+
+	got, err := s.QueryStatistics(ctx, model.StatisticsQuery{
+		StatisticsRequest: request,
+		BannerIDs:         []model.BannerID{"2", "1", "5"},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, got.Banners, 3)
+	assert.Equal(t, model.BannerID("1"), got.Banners[0].ID)
+	assert.Len(t, got.Banners[0].Stats, 1)
+	assert.Len(t, got.Banners[1].Stats, 2)
+	assert.Equal(t, 0.3, got.Banners[1].Stats[0].CTR)
+	// A banner without data keeps an empty series
+	assert.Equal(t, "5", got.Banners[2].Name)
+	assert.Empty(t, got.Banners[2].Stats)
+
+	require.Len(t, got.Aggregate, 2)
+	assert.Equal(t, 5, got.Aggregate[0].Count)
+	assert.Equal(t, 20, got.Aggregate[0].Impressions)
+	assert.Equal(t, 0.25, got.Aggregate[0].CTR)
+	assert.Equal(t, 1, got.Aggregate[1].Count)
+
+	got, err = s.QueryStatistics(ctx, model.StatisticsQuery{StatisticsRequest: request, All: true})
+	require.NoError(t, err)
+	assert.Len(t, got.Banners, 3)
+	assert.Equal(t, 9, got.Aggregate[0].Count)
+
+	_, err = s.QueryStatistics(ctx, model.StatisticsQuery{
+		StatisticsRequest: request,
+		BannerIDs:         []model.BannerID{"11"},
+	})
+	assert.ErrorIs(t, err, ErrBannerNotFound)
+}
+
+func TestGetTotals(t *testing.T) {
+	s := setupTestService(model.GranularityHour)
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 22, 30, 0, 0, time.UTC)
+	require.NoError(t, s.RestoreSnapshots(ctx, minuteSnapshots(base, 90)))
+
+	got, err := s.GetTotals(ctx, model.StatisticsRequest{Granularity: model.GranularityHour})
+	require.NoError(t, err)
+
+	assert.Equal(t, 90, got.Count)
+	assert.Equal(t, 1, got.Banners)
+	require.Len(t, got.Stats, 2)
+	assert.Equal(t, 30, got.Stats[0].Count)
+	assert.Equal(t, 60, got.Stats[1].Count)
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"rsclabs-test/internal/metrics"
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository"
+)
+
+// rollUp adds a minute snapshot to the current bucket of every rollup. The
+// caller holds the service's mux.
+func (s *StatisticsService) rollUp(ctx context.Context, snapshot model.Snapshot) {
+	for granularity, store := range s.rollups {
+		if err := mergeInto(ctx, store, granularity.Truncate(snapshot.TimeStamp), snapshot); err != nil {
+			s.l.Error(fmt.Errorf("failed to update %s rollup: %w", granularity, err))
+		}
+	}
+}
+
+// RestoreRollups rebuilds the buckets of every rollup after its newest stored
+// one from the minute history. A durable rollup logs its buckets only once
+// they are closed, so its open buckets are rebuilt this way on startup; an
+// in-memory rollup is rebuilt whole. It runs before the first flush.
+func (s *StatisticsService) RestoreRollups(ctx context.Context) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for granularity, store := range s.rollups {
+		last, err := store.GetLastSnapshotTime(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read %s rollup: %w", granularity, err)
+		}
+
+		var from time.Time
+		if !last.IsZero() {
+			from = granularity.Next(last)
+		}
+
+		minutes, err := s.history.GetSnapshots(ctx, from, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to read statistics history: %w", err)
+		}
+
+		for _, snapshot := range minutes {
+			if err := mergeInto(ctx, store, granularity.Truncate(snapshot.TimeStamp), snapshot); err != nil {
+				return fmt.Errorf("failed to rebuild %s rollup: %w", granularity, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// mergeInto adds the snapshot's counts to the bucket stored at the given time.
+// It reads and rewrites the bucket, so callers hold the service's mux.
+func mergeInto(ctx context.Context, store repository.SnapshotStore, bucket time.Time, snapshot model.Snapshot) error {
+	existing, err := store.GetSnapshots(ctx, bucket, bucket)
+	if err != nil {
+		return err
+	}
+
+	// Build a fresh snapshot: the stored one may still be read concurrently
+	merged := model.Snapshot{TimeStamp: bucket}
+	for _, e := range existing {
+		merged.Merge(e)
+	}
+	merged.Merge(snapshot)
+
+	return store.SaveSnapshots(ctx, []model.Snapshot{merged})
+}
+
+// source picks the store that answers queries at the given granularity: the
+// coarsest kept rollup that can be rolled up into it, or the minute history.
+func (s *StatisticsService) source(granularity model.Granularity) (repository.SnapshotStore, model.Granularity) {
+	store, source := s.history, model.GranularityMinute
+
+	for _, candidate := range model.Granularities {
+		rollup, ok := s.rollups[candidate]
+		if ok && candidate.Divides(granularity) {
+			store, source = rollup, candidate
+		}
+	}
+
+	return store, source
+}
+
+// store returns the store kept for the granularity, if any.
+func (s *StatisticsService) store(granularity model.Granularity) (repository.SnapshotStore, bool) {
+	if granularity == model.GranularityMinute {
+		return s.history, true
+	}
+
+	store, ok := s.rollups[granularity]
+
+	return store, ok
+}
+
+// SnapshotSizes reports the history held in memory at every kept
+// granularity; stores outside memory, such as Postgres, are left out.
+func (s *StatisticsService) SnapshotSizes() []metrics.SnapshotSize {
+	var sizes []metrics.SnapshotSize
+	for _, granularity := range model.Granularities {
+		store, ok := s.store(granularity)
+		if !ok {
+			continue
+		}
+
+		sizer, ok := store.(repository.SnapshotSizer)
+		if !ok {
+			continue
+		}
+
+		snapshots, bytes := sizer.Size()
+		sizes = append(sizes, metrics.SnapshotSize{
+			Granularity: string(granularity),
+			Snapshots:   snapshots,
+			Bytes:       bytes,
+		})
+	}
+
+	return sizes
+}
+
+// aggregate rolls time-ordered snapshots up into buckets of the granularity.
+func aggregate(snapshots []model.Snapshot, granularity model.Granularity) []model.Snapshot {
+	var out []model.Snapshot
+	for _, snapshot := range snapshots {
+		bucket := granularity.Truncate(snapshot.TimeStamp)
+		if len(out) == 0 || !out[len(out)-1].TimeStamp.Equal(bucket) {
+			out = append(out, model.Snapshot{TimeStamp: bucket})
+		}
+		out[len(out)-1].Merge(snapshot)
+	}
+
+	return out
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository"
+	"rsclabs-test/internal/repository/inmemorystorage"
+	"rsclabs-test/pkg/observe"
+)
+
+func setupTestService(rollups ...model.Granularity) *StatisticsService {
+	storage := inmemorystorage.NewInMemoryStorage(10, 100, nil)
+	bannerRepo, _ := repository.NewBannerRepository(storage)
+
+	stores := make(map[model.Granularity]repository.SnapshotStore)
+	for _, g := range rollups {
+		stores[g] = repository.NewInMemorySnapshotRepository()
+	}
+
+	return NewStatisticsService(
+		bannerRepo,
+		repository.NewInMemorySnapshotRepository(),
+		stores,
+		nil,
+		fiber.New(),
+		observe.NewZapLogger("test-app"),
+	)
+}
+
+// minuteSnapshots returns one snapshot per minute for banner 1 (internal id 0)
+// with a count of 1, starting at base.
+func minuteSnapshots(base time.Time, minutes int) []model.Snapshot {
+	out := make([]model.Snapshot, 0, minutes)
+	for i := 0; i < minutes; i++ {
+		ts := base.Add(time.Duration(i) * time.Minute)
+		out = append(out, model.Snapshot{
+			TimeStamp: ts,
+			Banners:   map[model.BannerID]model.Banner{"1": {BannerID: "1", Name: "Banner 1", Count: 1, TimeStamp: ts}},
+		})
+	}
+
+	return out
+}
+
+func TestRollupsAreMaintained(t *testing.T) {
+	s := setupTestService(model.GranularityHour, model.GranularityDay)
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 22, 30, 0, 0, time.UTC)
+	require.NoError(t, s.RestoreSnapshots(ctx, minuteSnapshots(base, 120)))
+
+	hours, err := s.rollups[model.GranularityHour].GetSnapshots(ctx, time.Time{}, base.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, hours, 3)
+	assert.Equal(t, 30, hours[0].Banners["1"].Count)
+	assert.Equal(t, 60, hours[1].Banners["1"].Count)
+	assert.Equal(t, 30, hours[2].Banners["1"].Count)
+	assert.True(t, hours[1].TimeStamp.Equal(time.Date(2025, 6, 6, 23, 0, 0, 0, time.UTC)))
+
+	days, err := s.rollups[model.GranularityDay].GetSnapshots(ctx, time.Time{}, base.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, days, 2)
+	assert.Equal(t, 90, days[0].Banners["1"].Count)
+	assert.Equal(t, 30, days[1].Banners["1"].Count)
+}
+
+func TestGetStatisticsGranularity(t *testing.T) {
+	base := time.Date(2025, 6, 6, 22, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		rollups     []model.Granularity
+		granularity model.Granularity
+		want        []int
+	}{
+		{"minute", nil, model.GranularityMinute, nil},
+		{"hour from rollup", []model.Granularity{model.GranularityHour}, model.GranularityHour, []int{30, 60, 30}},
+		{"hour derived from minutes", nil, model.GranularityHour, []int{30, 60, 30}},
+		{"day from rollup", []model.Granularity{model.GranularityHour, model.GranularityDay}, model.GranularityDay, []int{90, 30}},
+		{"week derived from days", []model.Granularity{model.GranularityDay}, model.GranularityWeek, []int{120}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := setupTestService(tt.rollups...)
+			ctx := context.Background()
+			require.NoError(t, s.RestoreSnapshots(ctx, minuteSnapshots(base, 120)))
+
+			resp, err := s.GetStatistics(ctx, model.StatisticsRequest{
+				BannerID:    "1",
+				Granularity: tt.granularity,
+			})
+			require.NoError(t, err)
+
+			if tt.want == nil {
+				assert.Len(t, resp.Stats, 120)
+				return
+			}
+
+			got := make([]int, 0, len(resp.Stats))
+			for _, b := range resp.Stats {
+				got = append(got, b.Count)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetStatisticsUnknownGranularity(t *testing.T) {
+	s := setupTestService()
+
+	_, err := s.GetStatistics(context.Background(), model.StatisticsRequest{
+		BannerID:    "1",
+		Granularity: "fortnight",
+	})
+	assert.Error(t, err)
+}
+
+func TestGetStatisticsGroupBy(t *testing.T) {
+	s := setupTestService(model.GranularityHour)
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 22, 0, 0, 0, time.UTC)
+	snapshots := minuteSnapshots(base, 2)
+	for i, country := range []string{"DE", "FR"} {
+		banner := snapshots[i].Banners["1"]
+		banner.Dimensions = model.DimensionCounts{
+			model.DimensionCountry:   {country: 1},
+			model.DimensionPlacement: {"top": 1},
+		}
+		snapshots[i].Banners["1"] = banner
+	}
+	require.NoError(t, s.RestoreSnapshots(ctx, snapshots))
+
+	resp, err := s.GetStatistics(ctx, model.StatisticsRequest{
+		BannerID:    "1",
+		Granularity: model.GranularityHour,
+		GroupBy:     model.DimensionCountry,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Stats, 1)
+	assert.Equal(t, model.DimensionCounts{model.DimensionCountry: {"DE": 1, "FR": 1}}, resp.Stats[0].Dimensions)
+
+	resp, err = s.GetStatistics(ctx, model.StatisticsRequest{BannerID: "1"})
+	require.NoError(t, err)
+	require.Len(t, resp.Stats, 2)
+	assert.Nil(t, resp.Stats[0].Dimensions)
+}
+
+func TestGetStatisticsCTR(t *testing.T) {
+	s := setupTestService(model.GranularityHour)
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 22, 0, 0, 0, time.UTC)
+	snapshots := minuteSnapshots(base, 3)
+	for i, impressions := range []int{4, 0, 6} {
+		banner := snapshots[i].Banners["1"]
+		banner.Impressions = impressions
+		snapshots[i].Banners["1"] = banner
+	}
+	require.NoError(t, s.RestoreSnapshots(ctx, snapshots))
+
+	resp, err := s.GetStatistics(ctx, model.StatisticsRequest{BannerID: "1"})
+	require.NoError(t, err)
+	require.Len(t, resp.Stats, 3)
+	assert.InDelta(t, 0.25, resp.Stats[0].CTR, 1e-9)
+	assert.Zero(t, resp.Stats[1].CTR, "no impressions means no CTR")
+
+	resp, err = s.GetStatistics(ctx, model.StatisticsRequest{BannerID: "1", Granularity: model.GranularityHour})
+	require.NoError(t, err)
+	require.Len(t, resp.Stats, 1)
+	assert.Equal(t, 3, resp.Stats[0].Count)
+	assert.Equal(t, 10, resp.Stats[0].Impressions)
+	assert.InDelta(t, 0.3, resp.Stats[0].CTR, 1e-9)
+}
+
+func TestRestoreSnapshotsDuringFlushes(t *testing.T) {
+	s := setupTestService(model.GranularityHour, model.GranularityDay)
+	ctx := context.Background()
+
+	// The restored minutes run up to the hour and day buckets the clicks are
+	// flushed into meanwhile
+	base := time.Now().UTC().Truncate(time.Minute).Add(-500 * time.Minute)
+	restored := minuteSnapshots(base, 500)
+
+	clicks := 200
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < clicks; i++ {
+			assert.NoError(t, s.counter.RegisterClick("1"))
+			s.RegisterStatistics(ctx)
+		}
+	}()
+
+	require.NoError(t, s.RestoreSnapshots(ctx, restored))
+	<-done
+
+	for _, granularity := range []model.Granularity{model.GranularityHour, model.GranularityDay} {
+		buckets, err := s.rollups[granularity].GetSnapshots(ctx, time.Time{}, time.Now())
+		require.NoError(t, err)
+
+		total := 0
+		for _, bucket := range buckets {
+			total += bucket.Banners["1"].Count
+		}
+		assert.Equal(t, len(restored)+clicks, total, granularity)
+	}
+}
+
+func TestRestoreRollupsRebuildsOpenBuckets(t *testing.T) {
+	s := setupTestService(model.GranularityHour)
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 22, 0, 0, 0, time.UTC)
+	require.NoError(t, s.history.SaveSnapshots(ctx, minuteSnapshots(base, 90)))
+
+	// The first hour was logged when it closed; the second one was open
+	hours := s.rollups[model.GranularityHour]
+	require.NoError(t, hours.SaveSnapshots(ctx, []model.Snapshot{{
+		TimeStamp: base,
+		Banners:   map[model.BannerID]model.Banner{"1": {BannerID: "1", Count: 60}},
+	}}))
+
+	require.NoError(t, s.RestoreRollups(ctx))
+
+	buckets, err := hours.GetSnapshots(ctx, time.Time{}, base.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, buckets, 2)
+	assert.Equal(t, 60, buckets[0].Banners["1"].Count)
+	assert.Equal(t, 30, buckets[1].Banners["1"].Count)
+}
@@ -2,11 +2,16 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"rsclabs-test/internal/metrics"
 	"rsclabs-test/internal/model"
 	"rsclabs-test/internal/repository"
 	"rsclabs-test/pkg/observe"
@@ -14,138 +19,529 @@ import (
 
 const (
 	defaultTimeout = 10 * time.Second
+
+	// maxPendingSnapshots caps the snapshots kept for retry to a day of
+	// minutes; past it, the oldest minutes are dropped
+	maxPendingSnapshots = 24 * 60
 )
 
+// Retention bounds the snapshot history by age and by number of snapshots.
+// Zero values disable the corresponding limit.
+type Retention struct {
+	MaxAge       time.Duration
+	MaxSnapshots int
+}
+
 type StatisticsService struct {
-	bannerRepo *repository.BannerRepositoryInMemory
-	snapshots  []model.Snapshot
-	server     *fiber.App
-	l          *observe.Logger
+	counter repository.ClickCounter
+	history repository.SnapshotStore
+	rollups map[model.Granularity]repository.SnapshotStore
+	catalog *CatalogService
+	top     *leaderboard
+	server  *fiber.App
+	l       *observe.Logger
+
+	// mux serializes the flushes and the restores, which read and rewrite
+	// stored buckets
+	mux sync.Mutex
+	// pending holds the rotated snapshots that failed to be stored, one per
+	// minute, retried by the next flush
+	pending []model.Snapshot
 }
 
+// NewStatisticsService creates the service. history keeps the per-minute
+// snapshots; rollups optionally keeps coarser buckets maintained alongside.
+// When a catalog is given, it validates and names the banners; otherwise any
+// banner within the counter capacity is valid. The leaderboard is seeded from
+// the recent history, which may have been replayed from disk.
 func NewStatisticsService(
-	repo *repository.BannerRepositoryInMemory,
+	counter repository.ClickCounter,
+	history repository.SnapshotStore,
+	rollups map[model.Granularity]repository.SnapshotStore,
+	catalog *CatalogService,
 	hs *fiber.App,
 	l *observe.Logger,
 ) *StatisticsService {
-	return &StatisticsService{
-		bannerRepo: repo,
-		snapshots:  make([]model.Snapshot, 0),
-		server:     hs,
-		l:          l,
+	s := &StatisticsService{
+		counter: counter,
+		history: history,
+		rollups: rollups,
+		catalog: catalog,
+		top:     newLeaderboard(leaderboardWindows),
+		server:  hs,
+		l:       l,
+	}
+
+	recent, err := history.GetSnapshots(context.Background(), time.Now().Add(-s.top.longest()), time.Now())
+	if err != nil {
+		l.Error(fmt.Errorf("failed to seed the leaderboard: %w", err))
+	}
+	for _, snapshot := range recent {
+		s.top.add(snapshot)
 	}
+
+	return s
 }
 
+// RegisterStatistics rotates the click counters into the history. The
+// snapshots that fail to be stored are kept and retried first by the next
+// call, up to a day of minutes; they reach the rollups and the leaderboard only
+// once stored, so that every view counts the same clicks.
 func (s *StatisticsService) RegisterStatistics(ctx context.Context) {
 	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
-	cs := s.bannerRepo.GetCountSnapshot()
-	if cs.IsEmpty() {
+	ctx, span := observe.StartSpan(ctx, "StatisticsService.RegisterStatistics")
+	defer span.End()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	snapshots := append(s.pending, s.counter.RotateCounts()...)
+	s.pending = nil
+	span.SetAttributes(attribute.Int("snapshots", len(snapshots)))
+	if len(snapshots) == 0 {
 		s.l.Debug("no new statistics data to update")
 		return
 	}
 
-	cs.TimeStamp = time.Now()
+	for _, cs := range snapshots {
+		s.nameBanners(cs)
+
+		s.l.Debug("*** registering new statistics snapshot ***", map[string]any{
+			"snapshot": cs,
+		})
+
+		// A minute may already be stored if it was rotated before it ended
+		if err := mergeInto(ctx, s.history, cs.TimeStamp, cs); err != nil {
+			span.RecordError(err)
+			s.l.WithContext(ctx).Error(fmt.Errorf("failed to save statistics snapshot: %w", err), map[string]any{
+				"snapshot": cs,
+			})
+
+			s.retry(ctx, cs)
+			continue
+		}
+
+		s.rollUp(ctx, cs)
+		s.top.add(cs)
+	}
+}
+
+// retry keeps the snapshot for the next flush, merged into the pending
+// snapshot of the same minute. Past maxPendingSnapshots, the oldest minute is
+// dropped.
+func (s *StatisticsService) retry(ctx context.Context, cs model.Snapshot) {
+	for i := range s.pending {
+		if s.pending[i].TimeStamp.Equal(cs.TimeStamp) {
+			s.pending[i].Merge(cs)
+			return
+		}
+	}
+
+	s.pending = append(s.pending, cs)
+	if len(s.pending) <= maxPendingSnapshots {
+		return
+	}
 
-	s.l.Debug("*** registering new statistics snapshot ***", map[string]any{
-		"snapshot": cs,
+	dropped := s.pending[0]
+	s.pending = s.pending[1:]
+	metrics.DroppedSnapshots.Inc()
+	s.l.WithContext(ctx).Error(errors.New("dropped a snapshot that failed to be stored for too long"), map[string]any{
+		"snapshot": dropped,
 	})
+}
+
+// EvictSnapshots drops the snapshots of the given granularity that fall
+// outside the retention.
+func (s *StatisticsService) EvictSnapshots(ctx context.Context, granularity model.Granularity, retention Retention) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	store, ok := s.store(granularity)
+	if !ok {
+		return
+	}
+
+	ctx, span := observe.StartSpan(ctx, "StatisticsService.EvictSnapshots",
+		attribute.String("granularity", string(granularity)))
+	defer span.End()
 
-	s.snapshots = append(s.snapshots, cs)
+	var before time.Time
+	if retention.MaxAge > 0 {
+		before = time.Now().Add(-retention.MaxAge)
+	}
+
+	removed, err := store.EvictSnapshots(ctx, before, retention.MaxSnapshots)
+	if err != nil {
+		span.RecordError(err)
+		s.l.WithContext(ctx).Error(fmt.Errorf("failed to evict %s statistics snapshots: %w", granularity, err))
+		return
+	}
 
-	s.bannerRepo.ZeroOutCounts()
+	if removed > 0 {
+		s.l.Debug("statistics snapshots evicted", map[string]any{
+			"granularity": granularity,
+			"removed":     removed,
+		})
+	}
 }
 
 func (s *StatisticsService) GetStatistics(
 	ctx context.Context,
 	request model.StatisticsRequest,
-) (model.StatisticsResponse, error) {
+) (_ model.StatisticsResponse, err error) {
 	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
-	if len(s.snapshots) == 0 {
-		return model.StatisticsResponse{}, nil
-	}
+	ctx, span := observe.StartSpan(ctx, "StatisticsService.GetStatistics", requestAttributes(request)...)
+	defer func() { observe.EndSpan(span, err) }()
 
-	if request.BannerID < 1 || request.BannerID > s.bannerRepo.MaxBanners {
-		return model.StatisticsResponse{}, fmt.Errorf("invalid banner id %d", request.BannerID)
+	granularity, err := model.ParseGranularity(string(request.Granularity))
+	if err != nil {
+		return model.StatisticsResponse{}, err
 	}
 
-	from, err := s.getFrom(request)
+	loc, err := requestLocation(request.TZ)
 	if err != nil {
-		return model.StatisticsResponse{}, fmt.Errorf("failed to parse 'from' time: %w", err)
+		return model.StatisticsResponse{}, err
 	}
 
-	to, err := s.getTo(request)
+	store, _ := s.source(granularity)
+
+	last, err := store.GetLastSnapshotTime(ctx)
 	if err != nil {
-		return model.StatisticsResponse{}, fmt.Errorf("failed to parse 'to' time: %w", err)
+		return model.StatisticsResponse{}, fmt.Errorf("failed to read statistics history: %w", err)
 	}
 
-	if from.After(to) {
-		return model.StatisticsResponse{}, fmt.Errorf("invalid time range: from %s is after to %s", from, to)
+	// A dense series is all zeros without history
+	if last.IsZero() && !request.FillGaps {
+		return model.StatisticsResponse{}, nil
+	}
+
+	if err := s.checkBanner(request.BannerID); err != nil {
+		return model.StatisticsResponse{}, err
+	}
+
+	snapshots, from, to, err := s.rangeSnapshots(ctx, request, granularity, loc)
+	if err != nil {
+		return model.StatisticsResponse{}, err
 	}
 
 	out := model.StatisticsResponse{
 		Stats: make([]model.Banner, 0),
 	}
-	for _, snapshot := range s.snapshots {
-		if snapshot.TimeStamp.Before(from) || snapshot.TimeStamp.After(to) {
-			continue
-		}
-
-		filtered, ok := snapshot.Banners[request.BannerID-1]
+	for _, snapshot := range snapshots {
+		filtered, ok := snapshot.Banners[request.BannerID]
 		if !ok {
 			continue
 		}
 		filtered.TimeStamp = snapshot.TimeStamp
+		if s.catalog != nil {
+			if name, ok := s.catalog.Name(request.BannerID); ok {
+				filtered.Name = name
+			}
+		}
+		filtered.Dimensions = groupBy(filtered.Dimensions, request.GroupBy)
+		withCTR(&filtered)
 
 		out.Stats = append(out.Stats, filtered)
 	}
 
+	if request.FillGaps {
+		name := s.bannerName(request.BannerID, out.Stats)
+		out.Stats, err = fillGaps(out.Stats, from, to, granularity, func(ts time.Time) model.Banner {
+			return zeroBucket(ts, request.BannerID, name, request.GroupBy)
+		})
+		if err != nil {
+			return model.StatisticsResponse{}, err
+		}
+	}
+
+	if request.TZ != "" {
+		inZone(out.Stats, loc)
+	}
+
 	return out, nil
 }
 
-func (s *StatisticsService) GetSnapshots() []model.Snapshot {
-	return s.snapshots
+// checkBanner fails with ErrBannerNotFound for a banner missing from the
+// catalog or, without one, for an ID the counters do not take.
+func (s *StatisticsService) checkBanner(id model.BannerID) error {
+	if s.catalog != nil {
+		_, err := s.catalog.Get(id)
+		return err
+	}
+
+	if err := s.counter.ValidateID(id); err != nil {
+		return fmt.Errorf("%w: %w", ErrBannerNotFound, err)
+	}
+
+	return nil
 }
 
-func (s *StatisticsService) getFrom(request model.StatisticsRequest) (time.Time, error) {
-	if request.From != "" {
-		parsedFrom, err := time.ParseInLocation("2006-01-02T15:04:05", request.From, time.Local)
-		if err != nil {
-			return time.Time{}, fmt.Errorf("invalid 'from' time format: %w", err)
+// rangeSnapshots returns the snapshots of the requested range at the given
+// granularity, aggregated from a finer store when there is no rollup for it.
+// Only the buckets starting within the range are returned. Times without an
+// offset are read in loc.
+func (s *StatisticsService) rangeSnapshots(
+	ctx context.Context,
+	request model.StatisticsRequest,
+	granularity model.Granularity,
+	loc *time.Location,
+) (snapshots []model.Snapshot, from, to time.Time, err error) {
+	now := time.Now()
+
+	from, err = s.getFrom(request, loc, now)
+	if err != nil {
+		return nil, from, to, fmt.Errorf("%w: failed to parse 'from' time: %w", ErrInvalidTime, err)
+	}
+
+	to, err = s.getTo(request, loc, now)
+	if err != nil {
+		return nil, from, to, fmt.Errorf("%w: failed to parse 'to' time: %w", ErrInvalidTime, err)
+	}
+
+	if from.After(to) {
+		return nil, from, to, fmt.Errorf("%w: from %s is after to %s", ErrInvalidTimeRange, from, to)
+	}
+
+	store, source := s.source(granularity)
+
+	snapshots, err = store.GetSnapshots(ctx, granularity.Truncate(from), to)
+	if err != nil {
+		return nil, from, to, fmt.Errorf("failed to read statistics history: %w", err)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("source", string(source)))
+
+	if source != granularity {
+		snapshots = aggregate(snapshots, granularity)
+	}
+
+	for len(snapshots) > 0 && snapshots[0].TimeStamp.Before(from) {
+		snapshots = snapshots[1:]
+	}
+
+	return snapshots, from, to, nil
+}
+
+// maxFilledBuckets caps the length of a gap-filled series.
+const maxFilledBuckets = 10000
+
+// ErrTooManyBuckets is returned for a gap-filled series longer than
+// maxFilledBuckets.
+var ErrTooManyBuckets = errors.New("too many buckets to fill")
+
+// fillGaps returns the series with a zero bucket, made by zero, for every
+// bucket of the granularity in [from, to] without data. The series is ordered
+// by time and lies within the range. Without from, the series is filled from
+// its first bucket.
+func fillGaps(
+	series []model.Banner,
+	from, to time.Time,
+	granularity model.Granularity,
+	zero func(ts time.Time) model.Banner,
+) ([]model.Banner, error) {
+	if from.IsZero() {
+		if len(series) == 0 {
+			return series, nil
 		}
+		from = series[0].TimeStamp
+	}
+
+	start := granularity.Truncate(from)
+	if start.Before(from) {
+		start = granularity.Next(start)
+	}
 
-		parsedFromUTC := parsedFrom.UTC()
+	count := 0
+	for ts := start; !ts.After(to); ts = granularity.Next(ts) {
+		if count++; count > maxFilledBuckets {
+			return nil, fmt.Errorf("%w: more than %d %s buckets from %s to %s",
+				ErrTooManyBuckets, maxFilledBuckets, granularity, start, to)
+		}
+	}
 
-		s.l.Debug(fmt.Sprintf("*** parsing 'from' time: input=%s, local=%s, UTC=%s",
-			request.From,
-			parsedFrom.Format("2006-01-02T15:04:05"),
-			parsedFromUTC.Format("2006-01-02T15:04:05")))
+	out := make([]model.Banner, 0, max(count, len(series)))
+	i := 0
+	for ts := start; !ts.After(to); ts = granularity.Next(ts) {
+		next := granularity.Next(ts)
+		if i == len(series) || !series[i].TimeStamp.Before(next) {
+			out = append(out, zero(ts))
+			continue
+		}
 
-		return parsedFromUTC, nil
+		for ; i < len(series) && series[i].TimeStamp.Before(next); i++ {
+			out = append(out, series[i])
+		}
 	}
 
-	return time.Time{}, nil
+	return append(out, series[i:]...), nil
 }
 
-func (s *StatisticsService) getTo(request model.StatisticsRequest) (time.Time, error) {
-	if request.To != "" {
-		parsedTo, err := time.ParseInLocation("2006-01-02T15:04:05", request.To, time.Local)
-		if err != nil {
-			return time.Now(), err
+func zeroBucket(ts time.Time, id model.BannerID, name string, dimension model.Dimension) model.Banner {
+	return model.Banner{
+		TimeStamp:  ts,
+		Name:       name,
+		BannerID:   id,
+		Dimensions: groupBy(nil, dimension),
+	}
+}
+
+// bannerName returns the name of the banner in the catalog or, without a
+// catalog entry, in its series.
+func (s *StatisticsService) bannerName(id model.BannerID, series []model.Banner) string {
+	if s.catalog != nil {
+		if name, ok := s.catalog.Name(id); ok {
+			return name
+		}
+	}
+
+	if len(series) > 0 {
+		return series[0].Name
+	}
+
+	return string(id)
+}
+
+// nameBanners replaces the counter's placeholder names with the catalog names.
+func (s *StatisticsService) nameBanners(snapshot model.Snapshot) {
+	if s.catalog == nil {
+		return
+	}
+
+	for id, banner := range snapshot.Banners {
+		if name, ok := s.catalog.Name(id); ok {
+			banner.Name = name
+			snapshot.Banners[id] = banner
 		}
+	}
+}
 
-		parsedToUTC := parsedTo.UTC()
+// groupBy keeps the breakdown by the requested dimension only; no dimension
+// drops the breakdown altogether.
+func groupBy(counts model.DimensionCounts, dimension model.Dimension) model.DimensionCounts {
+	if dimension == "" {
+		return nil
+	}
 
-		s.l.Debug(fmt.Sprintf("*** parsing 'to' time: input=%s, local=%s, UTC=%s",
-			request.To,
-			parsedTo.Format("2006-01-02T15:04:05"),
-			parsedToUTC.Format("2006-01-02T15:04:05")))
+	values := counts[dimension]
+	if values == nil {
+		values = make(map[string]int)
+	}
+
+	return model.DimensionCounts{dimension: values}
+}
 
-		return parsedToUTC, nil
+// GetSnapshots returns a copy of the whole minute history; the history size
+// is reported by SnapshotSizes without copying it.
+func (s *StatisticsService) GetSnapshots() []model.Snapshot {
+	snapshots, err := s.history.GetSnapshots(context.Background(), time.Time{}, time.Now())
+	if err != nil {
+		s.l.Error(fmt.Errorf("failed to read statistics history: %w", err))
 	}
 
-	return time.Now(), nil
+	return snapshots
+}
+
+// GetSnapshotsAfter returns the snapshots taken strictly after ts. Snapshots are
+// stored in time order, so callers can use the timestamp of the last snapshot
+// they processed as a cursor.
+func (s *StatisticsService) GetSnapshotsAfter(ctx context.Context, ts time.Time) ([]model.Snapshot, error) {
+	return s.history.GetSnapshots(ctx, ts.Add(time.Nanosecond), time.Now())
+}
+
+// RestoreSnapshots loads previously persisted snapshots into the history,
+// skipping the ones it already holds (e.g. replayed from the snapshot log).
+func (s *StatisticsService) RestoreSnapshots(ctx context.Context, snapshots []model.Snapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	from := snapshots[0].TimeStamp
+	to := snapshots[len(snapshots)-1].TimeStamp.Add(time.Microsecond)
+
+	existing, err := s.history.GetSnapshots(ctx, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to read statistics history: %w", err)
+	}
+
+	// Postgres keeps microsecond precision, so compare at that resolution
+	known := make(map[time.Time]struct{}, len(existing))
+	for _, snapshot := range existing {
+		known[snapshot.TimeStamp.UTC().Truncate(time.Microsecond)] = struct{}{}
+	}
+
+	missing := make([]model.Snapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if _, ok := known[snapshot.TimeStamp.UTC().Truncate(time.Microsecond)]; !ok {
+			missing = append(missing, snapshot)
+		}
+	}
+
+	if err := s.history.SaveSnapshots(ctx, missing); err != nil {
+		return err
+	}
+
+	for _, snapshot := range missing {
+		s.rollUp(ctx, snapshot)
+		s.top.add(snapshot)
+	}
+
+	return nil
+}
+
+// getFrom returns the start of the requested range, or the zero time for an
+// open range.
+func (s *StatisticsService) getFrom(request model.StatisticsRequest, loc *time.Location, now time.Time) (time.Time, error) {
+	if request.From == "" {
+		return time.Time{}, nil
+	}
+
+	from, err := parseTime(request.From, loc, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.l.Debug(fmt.Sprintf("*** parsing 'from' time: input=%s, zone=%s, UTC=%s",
+		request.From, loc, from.Format(time.RFC3339)))
+
+	return from, nil
+}
+
+// getTo returns the end of the requested range, now by default.
+func (s *StatisticsService) getTo(request model.StatisticsRequest, loc *time.Location, now time.Time) (time.Time, error) {
+	if request.To == "" {
+		return now, nil
+	}
+
+	to, err := parseTime(request.To, loc, now)
+	if err != nil {
+		return now, err
+	}
+
+	s.l.Debug(fmt.Sprintf("*** parsing 'to' time: input=%s, zone=%s, UTC=%s",
+		request.To, loc, to.Format(time.RFC3339)))
+
+	return to, nil
+}
+
+// requestAttributes describes a statistics request on its span.
+func requestAttributes(request model.StatisticsRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("banner_id", string(request.BannerID)),
+		attribute.String("granularity", string(request.Granularity)),
+		attribute.String("group_by", string(request.GroupBy)),
+		attribute.Bool("fill_gaps", request.FillGaps),
+	}
+}
+
+// inZone renders the timestamps of the series in the zone of the request.
+func inZone(series []model.Banner, loc *time.Location) {
+	for i := range series {
+		series[i].TimeStamp = series[i].TimeStamp.In(loc)
+	}
 }
@@ -1,5 +1,91 @@
 package service
 
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"rsclabs-test/internal/metrics"
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository"
+)
+
+func TestGetStatisticsFillGaps(t *testing.T) {
+	s := setupTestService()
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+	snapshots := minuteSnapshots(base, 1)
+	snapshots = append(snapshots, minuteSnapshots(base.Add(3*time.Minute), 1)...)
+	require.NoError(t, s.RestoreSnapshots(ctx, snapshots))
+
+	from := base.Add(-time.Minute).Local().Format("2006-01-02T15:04:05")
+	to := base.Add(4 * time.Minute).Local().Format("2006-01-02T15:04:05")
+
+	sparse, err := s.GetStatistics(ctx, model.StatisticsRequest{BannerID: "1", From: from, To: to})
+	require.NoError(t, err)
+	assert.Len(t, sparse.Stats, 2)
+
+	dense, err := s.GetStatistics(ctx, model.StatisticsRequest{
+		BannerID: "1", From: from, To: to, FillGaps: true, GroupBy: model.DimensionCountry,
+	})
+	require.NoError(t, err)
+	require.Len(t, dense.Stats, 6)
+
+	counts := make([]int, 0, len(dense.Stats))
+	for i, bucket := range dense.Stats {
+		assert.True(t, bucket.TimeStamp.Equal(base.Add(time.Duration(i-1)*time.Minute)), "bucket %d at %v", i, bucket.TimeStamp)
+		assert.Equal(t, "Banner 1", bucket.Name)
+		assert.NotNil(t, bucket.Dimensions[model.DimensionCountry])
+		counts = append(counts, bucket.Count)
+	}
+	assert.Equal(t, []int{0, 1, 0, 0, 1, 0}, counts)
+
+	_, err = s.GetStatistics(ctx, model.StatisticsRequest{
+		BannerID: "1", From: "2025-01-01T00:00:00", To: "2025-06-06T00:00:00", FillGaps: true,
+	})
+	assert.ErrorIs(t, err, ErrTooManyBuckets)
+
+	// A coarser granularity fits the same range
+	daily, err := s.GetStatistics(ctx, model.StatisticsRequest{
+		BannerID: "1", From: "2025-01-01T00:00:00", To: "2025-06-06T00:00:00", FillGaps: true,
+		Granularity: model.GranularityDay,
+	})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, len(daily.Stats), 150)
+}
+
+func TestQueryStatisticsFillGaps(t *testing.T) {
+	s := setupTestService()
+	ctx := context.Background()
+
+	base := time.Date(2025, 6, 6, 1, 0, 0, 0, time.UTC)
+	require.NoError(t, s.RestoreSnapshots(ctx, []model.Snapshot{
+		{TimeStamp: base, Banners: map[model.BannerID]model.Banner{"1": {BannerID: "1", Count: 1}}},
+		{TimeStamp: base.Add(2 * time.Minute), Banners: map[model.BannerID]model.Banner{"2": {BannerID: "2", Count: 2}}},
+	}))
+
+	got, err := s.QueryStatistics(ctx, model.StatisticsQuery{
+		StatisticsRequest: model.StatisticsRequest{
+			To:       base.Add(2 * time.Minute).Local().Format("2006-01-02T15:04:05"),
+			FillGaps: true,
+		},
+		BannerIDs: []model.BannerID{"1", "2"},
+	})
+	require.NoError(t, err)
+
+	// Without from, the series start at the first bucket with data
+	assert.Len(t, got.Aggregate, 3)
+	for _, series := range got.Banners {
+		assert.Len(t, series.Stats, 3, "banner %s", series.ID)
+	}
+	assert.Equal(t, 0, got.Aggregate[1].Count)
+}
+
 //
 //import (
 //	"reflect"
@@ -251,3 +337,92 @@ package service
 //		FilterByBannerIDInSnapshot(snapshot, 5)
 //	}
 //}
+
+// failingStore fails to save while fail is set.
+type failingStore struct {
+	*repository.SnapshotRepositoryInMemory
+	fail bool
+}
+
+func (s *failingStore) SaveSnapshots(ctx context.Context, snapshots []model.Snapshot) error {
+	if s.fail {
+		return repository.ErrStoreUnavailable
+	}
+
+	return s.SnapshotRepositoryInMemory.SaveSnapshots(ctx, snapshots)
+}
+
+func TestRegisterStatisticsRetriesFailedSave(t *testing.T) {
+	s := setupTestService(model.GranularityHour)
+	history := &failingStore{SnapshotRepositoryInMemory: repository.NewInMemorySnapshotRepository(), fail: true}
+	s.history = history
+	ctx := context.Background()
+
+	require.NoError(t, s.counter.RegisterClick("1"))
+	require.NoError(t, s.counter.RegisterClick("1"))
+	s.RegisterStatistics(ctx)
+
+	// The failed snapshot is counted nowhere yet
+	assert.Empty(t, s.GetSnapshots())
+	hours, err := s.rollups[model.GranularityHour].GetSnapshots(ctx, time.Time{}, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, hours)
+	top, err := s.TopBanners(10, time.Hour, model.RankByClicks)
+	require.NoError(t, err)
+	assert.Empty(t, top)
+
+	history.fail = false
+	require.NoError(t, s.counter.RegisterClick("1"))
+	s.RegisterStatistics(ctx)
+
+	clicks := 0
+	for _, snapshot := range s.GetSnapshots() {
+		clicks += snapshot.Banners["1"].Count
+	}
+	assert.Equal(t, 3, clicks)
+
+	hours, err = s.rollups[model.GranularityHour].GetSnapshots(ctx, time.Time{}, time.Now())
+	require.NoError(t, err)
+	hourly := 0
+	for _, hour := range hours {
+		hourly += hour.Banners["1"].Count
+	}
+	assert.Equal(t, 3, hourly)
+
+	top, err = s.TopBanners(10, time.Hour, model.RankByClicks)
+	require.NoError(t, err)
+	require.Len(t, top, 1)
+	assert.Equal(t, 3, top[0].Count)
+}
+
+func TestRegisterStatisticsBoundsPendingSnapshots(t *testing.T) {
+	s := setupTestService(model.GranularityHour)
+	s.history = &failingStore{SnapshotRepositoryInMemory: repository.NewInMemorySnapshotRepository(), fail: true}
+
+	base := time.Now().UTC().Truncate(time.Minute).Add(-maxPendingSnapshots * time.Minute)
+	for i := 0; i < maxPendingSnapshots; i++ {
+		ts := base.Add(time.Duration(i) * time.Minute)
+		s.pending = append(s.pending, model.Snapshot{
+			TimeStamp: ts,
+			Banners:   map[model.BannerID]model.Banner{"1": {BannerID: "1", Count: 1, TimeStamp: ts}},
+		})
+	}
+	last := s.pending[len(s.pending)-1]
+	s.pending = append(s.pending, model.Snapshot{
+		TimeStamp: last.TimeStamp,
+		Banners:   map[model.BannerID]model.Banner{"1": {BannerID: "1", Count: 1, TimeStamp: last.TimeStamp}},
+	})
+
+	dropped := testutil.ToFloat64(metrics.DroppedSnapshots)
+
+	require.NoError(t, s.counter.RegisterClick("1"))
+	s.RegisterStatistics(context.Background())
+
+	// The snapshots of the same minute are merged, and the new minute pushes
+	// out the oldest one
+	require.Len(t, s.pending, maxPendingSnapshots)
+	assert.Equal(t, base.Add(time.Minute), s.pending[0].TimeStamp)
+	assert.Equal(t, 2, s.pending[maxPendingSnapshots-2].Banners["1"].Count)
+	assert.Equal(t, 1, s.pending[maxPendingSnapshots-1].Banners["1"].Count)
+	assert.Equal(t, dropped+1, testutil.ToFloat64(metrics.DroppedSnapshots))
+}
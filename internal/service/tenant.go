@@ -0,0 +1,108 @@
+package service
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository"
+)
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+)
+
+// Tenant is one team sharing the deployment. Its banners are counted, named
+// and kept in a history of their own, so tenants never see each other's IDs
+// or statistics. The catalog is optional.
+type Tenant struct {
+	ID         string
+	Banners    repository.ClickCounter
+	Catalog    *CatalogService
+	Statistics *StatisticsService
+}
+
+// TenantService resolves the tenant of a request, by ID or by API key.
+type TenantService struct {
+	tenants map[string]*Tenant
+	// apiKeys maps the SHA-256 of a key to its tenant, so that a lookup does
+	// not leak the keys through timing
+	apiKeys map[[sha256.Size]byte]string
+}
+
+// NewTenantService registers the tenants and the API keys, which map a key to
+// the ID of its tenant.
+func NewTenantService(tenants []*Tenant, apiKeys map[string]string) (*TenantService, error) {
+	s := &TenantService{
+		tenants: make(map[string]*Tenant, len(tenants)),
+		apiKeys: make(map[[sha256.Size]byte]string, len(apiKeys)),
+	}
+
+	for _, tenant := range tenants {
+		if err := model.ValidateTenantID(tenant.ID); err != nil {
+			return nil, fmt.Errorf("invalid tenant %q: %w", tenant.ID, err)
+		}
+
+		if _, ok := s.tenants[tenant.ID]; ok {
+			return nil, fmt.Errorf("duplicate tenant %q", tenant.ID)
+		}
+
+		s.tenants[tenant.ID] = tenant
+	}
+
+	for key, id := range apiKeys {
+		if key == "" {
+			return nil, fmt.Errorf("empty API key for tenant %q", id)
+		}
+
+		if _, ok := s.tenants[id]; !ok {
+			return nil, fmt.Errorf("API key for unknown tenant %q", id)
+		}
+
+		s.apiKeys[sha256.Sum256([]byte(key))] = id
+	}
+
+	return s, nil
+}
+
+func (s *TenantService) Get(id string) (*Tenant, error) {
+	tenant, ok := s.tenants[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTenantNotFound, id)
+	}
+
+	return tenant, nil
+}
+
+// ByAPIKey returns the tenant the API key belongs to.
+func (s *TenantService) ByAPIKey(key string) (*Tenant, error) {
+	id, ok := s.apiKeys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return s.tenants[id], nil
+}
+
+// RequiresAPIKey reports whether API keys are configured. The tenants then
+// have to authenticate to read their statistics and manage their catalog.
+func (s *TenantService) RequiresAPIKey() bool {
+	return len(s.apiKeys) > 0
+}
+
+// List returns the tenants ordered by ID.
+func (s *TenantService) List() []*Tenant {
+	out := make([]*Tenant, 0, len(s.tenants))
+	for _, tenant := range s.tenants {
+		out = append(out, tenant)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+
+	return out
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantService(t *testing.T) {
+	tenants, err := NewTenantService(
+		[]*Tenant{{ID: "globex"}, {ID: "acme"}},
+		map[string]string{"acme-key": "acme"},
+	)
+	require.NoError(t, err)
+
+	tenant, err := tenants.ByAPIKey("acme-key")
+	require.NoError(t, err)
+	assert.Equal(t, "acme", tenant.ID)
+
+	_, err = tenants.ByAPIKey("globex-key")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	_, err = tenants.Get("initech")
+	assert.ErrorIs(t, err, ErrTenantNotFound)
+
+	assert.True(t, tenants.RequiresAPIKey())
+	assert.Equal(t, "acme", tenants.List()[0].ID)
+}
+
+func TestTenantServiceRejectsInvalidConfig(t *testing.T) {
+	_, err := NewTenantService([]*Tenant{{ID: "Acme Corp"}}, nil)
+	assert.Error(t, err)
+
+	_, err = NewTenantService([]*Tenant{{ID: "acme"}, {ID: "acme"}}, nil)
+	assert.Error(t, err)
+
+	_, err = NewTenantService([]*Tenant{{ID: "acme"}}, map[string]string{"key": "globex"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidTime is returned for a time of a statistics request in none
+	// of the supported formats.
+	ErrInvalidTime = errors.New("invalid time")
+	// ErrInvalidTimeRange is returned for a range that starts after it ends.
+	ErrInvalidTimeRange = errors.New("invalid time range")
+	// ErrInvalidTimeZone is returned for an unknown tz.
+	ErrInvalidTimeZone = errors.New("invalid time zone")
+)
+
+// localLayouts are the layouts of the times without an offset, read in the
+// zone of the request.
+var localLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// epochMillisThreshold tells epoch milliseconds from seconds: as seconds, it
+// would be more than 30000 years away.
+const epochMillisThreshold = 1_000_000_000_000
+
+// parseTime parses a point in time given as
+//   - RFC 3339 with an offset: 2025-06-06T01:00:00+02:00, 2025-06-06T01:00:00Z
+//   - a date and time or a date without offset, read in loc: 2025-06-06T01:00:00
+//   - Unix epoch seconds or milliseconds: 1749171600, 1749171600000
+//   - now, possibly shifted by a duration: now-1h, now+15m, now-7d
+//
+// Days (d) and weeks (w) are 24 hours and 7 days long. The result is in UTC.
+func parseTime(value string, loc *time.Location, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	if rest, ok := strings.CutPrefix(value, "now"); ok {
+		if rest == "" {
+			return now.UTC(), nil
+		}
+
+		sign := rest[0]
+		if sign != '+' && sign != '-' {
+			return time.Time{}, fmt.Errorf("invalid relative time %q", value)
+		}
+
+		d, err := parseOffset(rest[1:])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid relative time %q: %w", value, err)
+		}
+		if sign == '-' {
+			d = -d
+		}
+
+		return now.Add(d).UTC(), nil
+	}
+
+	if value != "" && strings.Trim(value, "0123456789") == "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid epoch time %q: %w", value, err)
+		}
+
+		if n >= epochMillisThreshold {
+			return time.UnixMilli(n).UTC(), nil
+		}
+
+		return time.Unix(n, 0).UTC(), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC(), nil
+	}
+
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unsupported time format %q", value)
+}
+
+// parseOffset parses a non-negative duration, also in days or weeks, of at
+// most about 292 years, the range of a time.Duration.
+func parseOffset(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.ParseUint(n, 10, 32)
+			if err != nil {
+				return 0, err
+			}
+			if count > uint64(math.MaxInt64/unit) {
+				return 0, fmt.Errorf("duration %q is out of range", s)
+			}
+
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 || strings.HasPrefix(s, "+") {
+		return 0, fmt.Errorf("duration %q must not be signed", s)
+	}
+
+	return d, nil
+}
+
+// requestLocation returns the zone of the tz field of a statistics request.
+// Without one, times without an offset are read in UTC, whatever the zone of
+// the server.
+func requestLocation(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTimeZone, err)
+	}
+
+	return loc, nil
+}
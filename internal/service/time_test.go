@@ -0,0 +1,77 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	now := time.Date(2025, 6, 6, 12, 0, 0, 0, time.UTC)
+	at := func(hour, minute int) time.Time {
+		return time.Date(2025, 6, 6, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2025-06-06T03:00:00+02:00", at(1, 0)},
+		{"2025-06-06T01:00:00Z", at(1, 0)},
+		{"2025-06-06T01:00:00.5Z", at(1, 0).Add(500 * time.Millisecond)},
+		{"2025-06-06T03:00:00", at(1, 0)},
+		{"2025-06-06T03:00", at(1, 0)},
+		{"2025-06-06 03:00:00", at(1, 0)},
+		{"2025-06-06", time.Date(2025, 6, 5, 22, 0, 0, 0, time.UTC)},
+		{"1749171600", at(1, 0)},
+		{"1749171600250", at(1, 0).Add(250 * time.Millisecond)},
+		{"now", now},
+		{"now-1h", at(11, 0)},
+		{"now+30m", at(12, 30)},
+		{"now-2d", now.AddDate(0, 0, -2)},
+		{"now-1w", now.AddDate(0, 0, -7)},
+		{"now-106751d", now.Add(-106751 * 24 * time.Hour)},
+		{" now-90s ", at(11, 58).Add(30 * time.Second)},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTime(tt.value, berlin, now)
+		require.NoError(t, err, tt.value)
+		assert.Equal(t, tt.want, got, tt.value)
+		assert.Equal(t, time.UTC, got.Location(), tt.value)
+	}
+
+	for _, value := range []string{"", "invalid-date", "now1h", "now-", "now--1h", "now-+1h", "now-1x", "now-1.5d", "now-200000d", "now-20000w", "06/06/2025"} {
+		_, err := parseTime(value, berlin, now)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRequestLocation(t *testing.T) {
+	// The default zone must not follow the server's
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	local := time.Local
+	t.Cleanup(func() { time.Local = local })
+	time.Local = tokyo
+
+	loc, err := requestLocation("")
+	require.NoError(t, err)
+	assert.Equal(t, time.UTC, loc)
+
+	got, err := parseTime("2025-06-06T03:00:00", loc, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 6, 6, 3, 0, 0, 0, time.UTC), got)
+
+	loc, err = requestLocation("America/New_York")
+	require.NoError(t, err)
+	assert.Equal(t, "America/New_York", loc.String())
+
+	_, err = requestLocation("Mars/Olympus")
+	assert.ErrorIs(t, err, ErrInvalidTimeZone)
+}
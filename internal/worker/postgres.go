@@ -1,19 +1,35 @@
 package worker
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
 	"rsclabs-test/internal/repository"
 	"rsclabs-test/internal/service"
 	"rsclabs-test/pkg/observe"
 )
 
+const (
+	postgresFlushInterval = 1 * time.Minute
+	postgresBatchSize     = 100
+	// postgresFlushOverlap re-flushes the most recent minutes, which can still
+	// receive late clicks after they were first written.
+	postgresFlushOverlap = 2 * time.Minute
+)
+
 type PostgresWorker struct {
-	bannerRepository  *repository.BannerRepositoryInMemory
+	bannerRepository  repository.SnapshotStore
 	statisticsService *service.StatisticsService
+	lastFlushed       time.Time
 	l                 *observe.Logger
+	// flushMux keeps a final Flush from overlapping a periodic one
+	flushMux sync.Mutex
 }
 
 func NewPostgresWorker(
-	bannerRepository *repository.BannerRepositoryInMemory,
+	bannerRepository repository.SnapshotStore,
 	statistics *service.StatisticsService,
 	l *observe.Logger,
 ) *PostgresWorker {
@@ -23,3 +39,66 @@ func NewPostgresWorker(
 		l:                 l,
 	}
 }
+
+func (w *PostgresWorker) Run(ctx context.Context) {
+	w.l.Info("starting postgres flush worker with poll", map[string]interface{}{"interval": postgresFlushInterval})
+
+	last, err := w.bannerRepository.GetLastSnapshotTime(ctx)
+	if err != nil {
+		w.l.Error(fmt.Errorf("failed to read last flushed snapshot time: %w", err))
+	}
+	w.lastFlushed = last
+
+	go func() {
+		timer := time.NewTimer(postgresFlushInterval)
+		for {
+			select {
+			case <-timer.C:
+				w.Flush(ctx)
+
+				timer.Reset(postgresFlushInterval)
+			case <-ctx.Done(): // exit
+				w.l.Info("stopping postgres flush worker")
+
+				return
+			}
+		}
+	}()
+}
+
+// Flush writes all snapshots registered since the last successful flush in
+// batches of postgresBatchSize. A failed batch stops the flush; it is retried
+// together with the newer snapshots on the next tick. Rows are upserted, so
+// rewriting the overlap is harmless.
+func (w *PostgresWorker) Flush(ctx context.Context) {
+	w.flushMux.Lock()
+	defer w.flushMux.Unlock()
+
+	ctx, span := observe.StartSpan(ctx, "PostgresWorker.Flush")
+	defer span.End()
+
+	snapshots, err := w.statisticsService.GetSnapshotsAfter(ctx, w.lastFlushed.Add(-postgresFlushOverlap))
+	if err != nil {
+		span.RecordError(err)
+		w.l.WithContext(ctx).Error(fmt.Errorf("failed to read snapshots to flush: %w", err))
+		return
+	}
+
+	for start := 0; start < len(snapshots); start += postgresBatchSize {
+		end := min(start+postgresBatchSize, len(snapshots))
+		batch := snapshots[start:end]
+
+		if err := w.bannerRepository.SaveSnapshots(ctx, batch); err != nil {
+			w.l.WithContext(ctx).Error(fmt.Errorf("failed to flush snapshots to postgres: %w", err), map[string]any{
+				"pending": len(snapshots) - start,
+			})
+			return
+		}
+
+		w.lastFlushed = batch[len(batch)-1].TimeStamp
+	}
+
+	if len(snapshots) > 0 {
+		w.l.Debug("snapshots flushed to postgres", map[string]any{"count": len(snapshots)})
+	}
+}
@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"rsclabs-test/internal/model"
+	"rsclabs-test/internal/repository"
+	"rsclabs-test/internal/repository/inmemorystorage"
+	"rsclabs-test/internal/service"
+	"rsclabs-test/pkg/observe"
+)
+
+var errWriteFailed = errors.New("write failed")
+
+// fakeWriter stands in for the Postgres repository: it records the batches
+// written and fails the writes numbered in failOn, counted from 1.
+type fakeWriter struct {
+	repository.SnapshotStore
+	last    time.Time
+	batches [][]model.Snapshot
+	writes  int
+	failOn  map[int]bool
+}
+
+func (w *fakeWriter) SaveSnapshots(_ context.Context, snapshots []model.Snapshot) error {
+	w.writes++
+	if w.failOn[w.writes] {
+		return errWriteFailed
+	}
+
+	w.batches = append(w.batches, snapshots)
+
+	return nil
+}
+
+func (w *fakeWriter) GetLastSnapshotTime(context.Context) (time.Time, error) {
+	return w.last, nil
+}
+
+// written returns the timestamps of the snapshots written since the last
+// call, in order.
+func (w *fakeWriter) written() []time.Time {
+	var out []time.Time
+	for _, batch := range w.batches {
+		for _, snapshot := range batch {
+			out = append(out, snapshot.TimeStamp)
+		}
+	}
+	w.batches = nil
+
+	return out
+}
+
+// setupPostgresWorker returns a worker flushing a history of the given
+// minutes, ending a minute ago, to a fake writer.
+func setupPostgresWorker(t *testing.T, minutes int) (*PostgresWorker, *fakeWriter, repository.SnapshotStore, []time.Time) {
+	storage := inmemorystorage.NewInMemoryStorage(10, 100, nil)
+	bannerRepo, _ := repository.NewBannerRepository(storage)
+	history := repository.NewInMemorySnapshotRepository()
+	logger := observe.NewZapLogger("test-app")
+	statsService := service.NewStatisticsService(bannerRepo, history, nil, nil, fiber.New(), logger)
+
+	base := time.Now().UTC().Truncate(time.Minute).Add(-time.Duration(minutes) * time.Minute)
+
+	var timestamps []time.Time
+	for i := 0; i < minutes; i++ {
+		ts := base.Add(time.Duration(i) * time.Minute)
+		timestamps = append(timestamps, ts)
+		require.NoError(t, history.SaveSnapshots(context.Background(), []model.Snapshot{{
+			TimeStamp: ts,
+			Banners:   map[model.BannerID]model.Banner{"1": {BannerID: "1", Count: 1, TimeStamp: ts}},
+		}}))
+	}
+
+	writer := &fakeWriter{failOn: make(map[int]bool)}
+
+	return NewPostgresWorker(writer, statsService, logger), writer, history, timestamps
+}
+
+func TestPostgresWorkerFlushesInBatches(t *testing.T) {
+	worker, writer, _, timestamps := setupPostgresWorker(t, 250)
+
+	worker.Flush(context.Background())
+
+	require.Len(t, writer.batches, 3)
+	assert.Len(t, writer.batches[0], postgresBatchSize)
+	assert.Len(t, writer.batches[1], postgresBatchSize)
+	assert.Len(t, writer.batches[2], 50)
+	assert.Equal(t, timestamps, writer.written())
+	assert.Equal(t, timestamps[len(timestamps)-1], worker.lastFlushed)
+}
+
+func TestPostgresWorkerRewritesTheOverlap(t *testing.T) {
+	worker, writer, history, timestamps := setupPostgresWorker(t, 10)
+	ctx := context.Background()
+
+	worker.Flush(ctx)
+	writer.written()
+
+	// Only the minutes within the overlap of the cursor are written again
+	worker.Flush(ctx)
+	assert.Equal(t, timestamps[len(timestamps)-2:], writer.written())
+
+	latest := timestamps[len(timestamps)-1].Add(time.Minute)
+	require.NoError(t, history.SaveSnapshots(ctx, []model.Snapshot{{TimeStamp: latest}}))
+
+	worker.Flush(ctx)
+	assert.Equal(t, []time.Time{timestamps[8], timestamps[9], latest}, writer.written())
+	assert.Equal(t, latest, worker.lastFlushed)
+}
+
+func TestPostgresWorkerRetriesAfterFailedWrite(t *testing.T) {
+	worker, writer, _, timestamps := setupPostgresWorker(t, 250)
+	ctx := context.Background()
+
+	// The second batch fails: the flush stops and the cursor stays at the
+	// end of the first one
+	writer.failOn[2] = true
+	worker.Flush(ctx)
+
+	assert.Equal(t, timestamps[:postgresBatchSize], writer.written())
+	assert.Equal(t, timestamps[postgresBatchSize-1], worker.lastFlushed)
+
+	// The next flush resumes from the cursor, overlap included
+	worker.Flush(ctx)
+
+	overlap := postgresFlushOverlap / time.Minute
+	assert.Equal(t, timestamps[postgresBatchSize-int(overlap):], writer.written())
+	assert.Equal(t, timestamps[len(timestamps)-1], worker.lastFlushed)
+}
+
+func TestPostgresWorkerRunStartsFromTheLastWrite(t *testing.T) {
+	worker, writer, _, timestamps := setupPostgresWorker(t, 10)
+
+	writer.last = timestamps[5]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	worker.Run(ctx)
+	worker.Flush(ctx)
+
+	assert.Equal(t, timestamps[4:], writer.written())
+}
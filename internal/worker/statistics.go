@@ -2,8 +2,14 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"rsclabs-test/internal/metrics"
+	"rsclabs-test/internal/model"
 	"rsclabs-test/internal/repository"
 	"rsclabs-test/internal/service"
 	"rsclabs-test/pkg/observe"
@@ -11,22 +17,33 @@ import (
 
 const (
 	statisticsUpdateInterval = 1 * time.Minute
+	// staleHeartbeat is how long the worker may go without a flush before
+	// it is reported unhealthy; a flush is due every interval
+	staleHeartbeat = 3 * statisticsUpdateInterval
 )
 
 type StatisticsWorker struct {
-	bannerRepository  *repository.BannerRepositoryInMemory
+	bannerRepository  repository.ClickCounter
 	statisticsService *service.StatisticsService
+	retention         map[model.Granularity]service.Retention
 	l                 *observe.Logger
+	// flushMux keeps a final Flush from overlapping a periodic one
+	flushMux sync.Mutex
+	// heartbeat is the time of the last flush, or of the start, in Unix
+	// nanoseconds
+	heartbeat atomic.Int64
 }
 
 func NewStatisticsWorker(
-	bannerRepository *repository.BannerRepositoryInMemory,
+	bannerRepository repository.ClickCounter,
 	statistics *service.StatisticsService,
+	retention map[model.Granularity]service.Retention,
 	l *observe.Logger,
 ) *StatisticsWorker {
 	return &StatisticsWorker{
 		bannerRepository:  bannerRepository,
 		statisticsService: statistics,
+		retention:         retention,
 		l:                 l,
 	}
 }
@@ -34,16 +51,18 @@ func NewStatisticsWorker(
 func (w *StatisticsWorker) Run(ctx context.Context) {
 	w.l.Info("starting statisticsService service with poll", map[string]interface{}{"interval": statisticsUpdateInterval})
 
+	w.heartbeat.Store(time.Now().UnixNano())
+
 	go func() {
-		timer := time.NewTimer(0)
+		timer := time.NewTimer(untilNextMinute(time.Now()))
 		for {
 			select {
 			case <-timer.C:
-				w.l.Debug("updating statisticsService", map[string]any{"len snapshots now": len(w.statisticsService.GetSnapshots())})
+				w.l.Debug("updating statisticsService")
 
-				w.statisticsService.RegisterStatistics(ctx)
+				w.Flush(ctx)
 
-				timer.Reset(statisticsUpdateInterval)
+				timer.Reset(untilNextMinute(time.Now()))
 			case <-ctx.Done(): // exit
 				w.l.Info("stopping statisticsService worker")
 
@@ -52,3 +71,44 @@ func (w *StatisticsWorker) Run(ctx context.Context) {
 		}
 	}()
 }
+
+// Flush stores the clicks counted since the last flush and evicts the history
+// beyond the retention. Run calls it every minute; call it once more after
+// the clicks stopped so that the last minute is not lost.
+func (w *StatisticsWorker) Flush(ctx context.Context) {
+	w.flushMux.Lock()
+	defer w.flushMux.Unlock()
+
+	start := time.Now()
+	ctx, span := observe.StartSpan(ctx, "StatisticsWorker.Flush")
+	defer span.End()
+
+	w.statisticsService.RegisterStatistics(ctx)
+	for granularity, retention := range w.retention {
+		w.statisticsService.EvictSnapshots(ctx, granularity, retention)
+	}
+
+	metrics.FlushDuration.Observe(time.Since(start).Seconds())
+	w.heartbeat.Store(time.Now().UnixNano())
+}
+
+// Check fails when the worker was not started or has not flushed for longer
+// than staleHeartbeat.
+func (w *StatisticsWorker) Check(_ context.Context) error {
+	beat := w.heartbeat.Load()
+	if beat == 0 {
+		return errors.New("statistics worker is not running")
+	}
+
+	if since := time.Since(time.Unix(0, beat)); since > staleHeartbeat {
+		return fmt.Errorf("statistics worker last flushed %s ago", since.Round(time.Second))
+	}
+
+	return nil
+}
+
+// untilNextMinute returns the time left until the next wall-clock boundary of
+// statisticsUpdateInterval, so that flushes line up across instances.
+func untilNextMinute(now time.Time) time.Duration {
+	return now.Truncate(statisticsUpdateInterval).Add(statisticsUpdateInterval).Sub(now)
+}
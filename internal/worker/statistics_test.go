@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"rsclabs-test/internal/model"
 	"rsclabs-test/internal/repository"
 	"rsclabs-test/internal/repository/inmemorystorage"
 	"rsclabs-test/internal/service"
@@ -14,12 +15,12 @@ import (
 )
 
 func setupTestWorker() (*StatisticsWorker, context.CancelFunc) {
-	storage := inmemorystorage.NewInMemoryStorage(100, nil)
+	storage := inmemorystorage.NewInMemoryStorage(100, 100, nil)
 	bannerRepo, _ := repository.NewBannerRepository(storage)
 	app := fiber.New()
 	logger := observe.NewZapLogger("test-app")
-	statsService := service.NewStatisticsService(bannerRepo, app, logger)
-	worker := NewStatisticsWorker(bannerRepo, statsService, logger)
+	statsService := service.NewStatisticsService(bannerRepo, repository.NewInMemorySnapshotRepository(), nil, nil, app, logger)
+	worker := NewStatisticsWorker(bannerRepo, statsService, nil, logger)
 	_, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	return worker, cancel
 }
@@ -32,7 +33,7 @@ func TestStatisticsWorkerRun(t *testing.T) {
 	worker.Run(context.Background())
 
 	for i := 0; i < 5; i++ {
-		worker.bannerRepository.RegisterClick(1)
+		worker.bannerRepository.RegisterClick("1")
 	}
 
 	// Wait for at least one statistics update
@@ -83,7 +84,7 @@ func TestStatisticsWorkerConcurrentClicks(t *testing.T) {
 	for i := 0; i < goroutines; i++ {
 		go func() {
 			for j := 0; j < iterations; j++ {
-				worker.bannerRepository.RegisterClick(1)
+				worker.bannerRepository.RegisterClick("1")
 			}
 			done <- true
 		}()
@@ -104,7 +105,7 @@ func TestStatisticsWorkerConcurrentClicks(t *testing.T) {
 	lastSnapshot := snapshots[len(snapshots)-1]
 	found := false
 	for _, banner := range lastSnapshot.Banners {
-		if banner.BannerID == 1 {
+		if banner.BannerID == "1" {
 			found = true
 			expectedCount := goroutines * iterations
 			assert.Equal(t, expectedCount, banner.Count,
@@ -129,3 +130,110 @@ func TestStatisticsWorkerEmptySnapshots(t *testing.T) {
 	snapshots := worker.statisticsService.GetSnapshots()
 	assert.Equal(t, 0, len(snapshots), "Expected no snapshots when there are no clicks")
 }
+
+func TestUntilNextMinute(t *testing.T) {
+	tests := []struct {
+		now  time.Time
+		want time.Duration
+	}{
+		{time.Date(2025, 6, 6, 1, 30, 17, 0, time.UTC), 43 * time.Second},
+		{time.Date(2025, 6, 6, 1, 30, 0, 0, time.UTC), time.Minute},
+		{time.Date(2025, 6, 6, 1, 30, 59, 900_000_000, time.UTC), 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, untilNextMinute(tt.now), "now=%s", tt.now)
+	}
+}
+
+func TestStatisticsWorkerCheck(t *testing.T) {
+	worker, cancel := setupTestWorker()
+	defer cancel()
+
+	assert.Error(t, worker.Check(context.Background()), "a worker that was not started is unhealthy")
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	worker.Run(ctx)
+	assert.NoError(t, worker.Check(ctx))
+
+	worker.heartbeat.Store(time.Now().Add(-staleHeartbeat - time.Second).UnixNano())
+	assert.Error(t, worker.Check(ctx), "a worker without a recent flush is unhealthy")
+}
+
+func TestStatisticsWorkerFlush(t *testing.T) {
+	worker, cancel := setupTestWorker()
+	defer cancel()
+
+	ctx, stop := context.WithCancel(context.Background())
+	worker.Run(ctx)
+
+	worker.bannerRepository.RegisterClick("1")
+	worker.bannerRepository.RegisterClick("1")
+
+	// A final flush after the worker stopped keeps the clicks of the
+	// current minute
+	stop()
+	worker.Flush(context.Background())
+
+	// The clicks may straddle a minute boundary
+	clicks := 0
+	for _, snapshot := range worker.statisticsService.GetSnapshots() {
+		clicks += snapshot.Banners["1"].Count
+	}
+	assert.Equal(t, 2, clicks)
+	assert.NoError(t, worker.Check(context.Background()))
+}
+
+func TestStatisticsWorkerFlushAppliesRetention(t *testing.T) {
+	storage := inmemorystorage.NewInMemoryStorage(100, 100, nil)
+	bannerRepo, _ := repository.NewBannerRepository(storage)
+	logger := observe.NewZapLogger("test-app")
+	hours := repository.NewInMemorySnapshotRepository()
+	statsService := service.NewStatisticsService(
+		bannerRepo,
+		repository.NewInMemorySnapshotRepository(),
+		map[model.Granularity]repository.SnapshotStore{model.GranularityHour: hours},
+		nil,
+		fiber.New(),
+		logger,
+	)
+	worker := NewStatisticsWorker(bannerRepo, statsService, map[model.Granularity]service.Retention{
+		model.GranularityMinute: {MaxAge: time.Hour},
+		model.GranularityHour:   {MaxAge: 2 * time.Hour},
+	}, logger)
+
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Minute)
+
+	var history []model.Snapshot
+	for _, age := range []time.Duration{5 * time.Hour, 3 * time.Hour, 90 * time.Minute, 10 * time.Minute} {
+		ts := now.Add(-age)
+		history = append(history, model.Snapshot{
+			TimeStamp: ts,
+			Banners:   map[model.BannerID]model.Banner{"1": {BannerID: "1", Count: 1, TimeStamp: ts}},
+		})
+	}
+	assert.NoError(t, statsService.RestoreSnapshots(ctx, history))
+
+	worker.bannerRepository.RegisterClick("1")
+	worker.Flush(ctx)
+
+	for _, snapshot := range worker.statisticsService.GetSnapshots() {
+		assert.False(t, snapshot.TimeStamp.Before(now.Add(-time.Hour)), "minute snapshot %v is past its retention", snapshot.TimeStamp)
+	}
+
+	buckets, err := hours.GetSnapshots(ctx, time.Time{}, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, buckets)
+	for _, bucket := range buckets {
+		assert.False(t, bucket.TimeStamp.Before(now.Add(-2*time.Hour)), "hour bucket %v is past its retention", bucket.TimeStamp)
+	}
+
+	clicks := 0
+	for _, snapshot := range worker.statisticsService.GetSnapshots() {
+		clicks += snapshot.Banners["1"].Count
+	}
+	assert.Equal(t, 2, clicks, "the recent snapshot and the flushed click are kept")
+}
@@ -0,0 +1,44 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DB resolves IP addresses to countries from a local MaxMind database file
+// (GeoLite2-Country, GeoIP2-Country or GeoIP2-City).
+type DB struct {
+	reader *maxminddb.Reader
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+func Open(path string) (*DB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open geoip database: %w", err)
+	}
+
+	return &DB{reader: reader}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country the address is
+// located in, or an empty string when it is unknown.
+func (db *DB) Country(ip net.IP) (string, error) {
+	var record countryRecord
+	if err := db.reader.Lookup(ip, &record); err != nil {
+		return "", fmt.Errorf("failed to look up %s: %w", ip, err)
+	}
+
+	return record.Country.ISOCode, nil
+}
+
+func (db *DB) Close() error {
+	return db.reader.Close()
+}
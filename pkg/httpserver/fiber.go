@@ -2,14 +2,24 @@ package httpserver
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/healthcheck"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+
+	"rsclabs-test/pkg/observe"
 )
 
-func InitFiberServer(appName string) *fiber.App {
+// InitFiberServer creates the server with the common middleware and the
+// probes of health, which has no checks when nil. When hook is not nil, the
+// panics of the handlers are reported to Sentry.
+func InitFiberServer(appName string, hook *observe.SentryHook, health *Health) *fiber.App {
 	s := fiber.New(fiber.Config{
 		AppName:           appName,
 		JSONEncoder:       json.Marshal,
@@ -18,14 +28,43 @@ func InitFiberServer(appName string) *fiber.App {
 		StreamRequestBody: true,
 	})
 
+	// Every request gets an ID, echoed in the X-Request-ID header and in the
+	// error answers, unless the client sent one. The ID and the trace are set
+	// before the panics are recovered, so a reported panic carries them
+	s.Use(requestid.New())
+	s.Use(traceRequests)
 	s.Use(recover.New(recover.Config{
-		EnableStackTrace: true,
+		EnableStackTrace:  true,
+		StackTraceHandler: reportPanic(hook),
 	}))
 	s.Use(cors.New())
-	s.Use(healthcheck.New(healthcheck.Config{
-		LivenessEndpoint:  "/manage/health",
-		ReadinessEndpoint: "/manage/ready",
-	}))
+	if health == nil {
+		health = NewHealth()
+	}
+	registerHealth(s, health)
+	registerMetrics(s)
 
 	return s
 }
+
+// reportPanic prints the stack of a recovered panic, as the recover middleware
+// does by default, and reports the panic to Sentry with the request.
+func reportPanic(hook *observe.SentryHook) func(c *fiber.Ctx, e any) {
+	return func(c *fiber.Ctx, e any) {
+		_, _ = os.Stderr.WriteString(fmt.Sprintf("panic: %v\n%s\n", e, debug.Stack()))
+
+		if hook == nil {
+			return
+		}
+
+		var r http.Request
+		if err := fasthttpadaptor.ConvertRequest(c.Context(), &r, true); err != nil {
+			r = http.Request{Method: c.Method(), Header: make(http.Header)}
+		}
+
+		hook.CapturePanic(c.UserContext(), &r, map[string]string{
+			"route":      c.Route().Path,
+			"request_id": c.GetRespHeader(fiber.HeaderXRequestID),
+		}, e)
+	}
+}
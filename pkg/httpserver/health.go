@@ -0,0 +1,143 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// LivenessEndpoint answers 503 when a liveness check fails: the process
+	// is stuck and should be restarted.
+	LivenessEndpoint = "/manage/health"
+	// ReadinessEndpoint answers 503 when a liveness or readiness check fails
+	// or the server is shutting down: it should get no traffic for now.
+	ReadinessEndpoint = "/manage/ready"
+)
+
+// healthCheckTimeout bounds each check of a probe.
+const healthCheckTimeout = 2 * time.Second
+
+// errShuttingDown fails the readiness of a server that is shutting down.
+var errShuttingDown = errors.New("shutting down")
+
+// HealthCheck checks a subsystem; a nil error is healthy.
+type HealthCheck func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// Health holds the checks behind the liveness and readiness endpoints.
+type Health struct {
+	mux          sync.RWMutex
+	liveness     []namedCheck
+	readiness    []namedCheck
+	shuttingDown atomic.Bool
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// AddLivenessCheck adds a check to both probes.
+func (h *Health) AddLivenessCheck(name string, check HealthCheck) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.liveness = append(h.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadinessCheck adds a check to the readiness probe.
+func (h *Health) AddReadinessCheck(name string, check HealthCheck) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.readiness = append(h.readiness, namedCheck{name: name, check: check})
+}
+
+// ShutDown fails the readiness probe from now on, so that the server is taken
+// out of rotation before it stops.
+func (h *Health) ShutDown() {
+	h.shuttingDown.Store(true)
+}
+
+// healthResponse is the body of the probes: "ok" or "unavailable", and the
+// result of every check, "ok" or its error.
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func (h *Health) handleLiveness(c *fiber.Ctx) error {
+	h.mux.RLock()
+	checks := h.liveness
+	h.mux.RUnlock()
+
+	return answerChecks(c, checks, nil)
+}
+
+func (h *Health) handleReadiness(c *fiber.Ctx) error {
+	h.mux.RLock()
+	checks := append(h.liveness[:len(h.liveness):len(h.liveness)], h.readiness...)
+	h.mux.RUnlock()
+
+	var shutdown error
+	if h.shuttingDown.Load() {
+		shutdown = errShuttingDown
+	}
+
+	return answerChecks(c, checks, shutdown)
+}
+
+// answerChecks runs the checks concurrently and answers 503 if one fails or
+// the server is shutting down.
+func answerChecks(c *fiber.Ctx, checks []namedCheck, shutdown error) error {
+	results := make([]error, len(checks))
+
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(c.UserContext(), healthCheckTimeout)
+			defer cancel()
+
+			results[i] = nc.check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	out := healthResponse{Status: "ok", Checks: make(map[string]string, len(checks)+1)}
+	status := fiber.StatusOK
+
+	for i, nc := range checks {
+		out.Checks[nc.name] = "ok"
+		if results[i] != nil {
+			out.Checks[nc.name] = results[i].Error()
+			status = fiber.StatusServiceUnavailable
+		}
+	}
+
+	if shutdown != nil {
+		out.Checks["shutdown"] = shutdown.Error()
+		status = fiber.StatusServiceUnavailable
+	}
+
+	if status != fiber.StatusOK {
+		out.Status = "unavailable"
+	}
+
+	return c.Status(status).JSON(out)
+}
+
+func registerHealth(s *fiber.App, h *Health) {
+	s.Get(LivenessEndpoint, h.handleLiveness)
+	s.Get(ReadinessEndpoint, h.handleReadiness)
+}
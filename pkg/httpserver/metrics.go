@@ -0,0 +1,63 @@
+package httpserver
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsEndpoint serves the metrics of the default Prometheus registry.
+const MetricsEndpoint = "/metrics"
+
+// unmatchedRoute labels the requests no route matched, so that scanning for
+// paths cannot blow up the cardinality of the latency histogram.
+const unmatchedRoute = "unmatched"
+
+var requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "http_request_duration_seconds",
+	Help:    "Duration of the HTTP requests per method, route and status.",
+	Buckets: prometheus.ExponentialBuckets(0.0001, 4, 9),
+}, []string{"method", "route", "status"})
+
+// observeRequests records the duration of every request under its route
+// template, e.g. /counter/:bannerID, rather than its path.
+func observeRequests(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	route, status := routeStatus(c, err)
+	requestDuration.WithLabelValues(c.Method(), route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+// routeStatus returns the route template of the handled request and the
+// status it is answered with, including for an error left to the error
+// handler of the server.
+func routeStatus(c *fiber.Ctx, err error) (string, int) {
+	route, status := c.Route().Path, c.Response().StatusCode()
+	if err != nil {
+		status = fiber.StatusInternalServerError
+
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			status = fe.Code
+		}
+		if status == fiber.StatusNotFound {
+			route = unmatchedRoute
+		}
+	}
+
+	return route, status
+}
+
+func registerMetrics(s *fiber.App) {
+	s.Use(observeRequests)
+	s.Get(MetricsEndpoint, adaptor.HTTPHandler(promhttp.Handler()))
+}
@@ -0,0 +1,74 @@
+package httpserver
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"rsclabs-test/pkg/observe"
+)
+
+// traceRequests starts the server span of every request, continuing the trace
+// of a traceparent header. The span is passed down in the user context of the
+// request, so the handlers must hand c.UserContext() to the services. It is
+// named after the route template once the route is known.
+func traceRequests(c *fiber.Ctx) error {
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), requestHeaders{c})
+	ctx, span := observe.Tracer().Start(ctx, c.Method(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Method()),
+			semconv.URLPath(c.Path()),
+			attribute.String("request_id", c.GetRespHeader(fiber.HeaderXRequestID)),
+		),
+	)
+	defer span.End()
+
+	c.SetUserContext(ctx)
+
+	err := c.Next()
+
+	route, status := routeStatus(c, err)
+	span.SetName(c.Method() + " " + route)
+	span.SetAttributes(
+		semconv.HTTPRoute(route),
+		semconv.HTTPResponseStatusCode(status),
+	)
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, "")
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+// requestHeaders carries the trace context in the request headers, looked up
+// regardless of the case fasthttp normalized them to.
+type requestHeaders struct {
+	c *fiber.Ctx
+}
+
+var _ propagation.TextMapCarrier = requestHeaders{}
+
+func (h requestHeaders) Get(key string) string {
+	return h.c.Get(key)
+}
+
+func (h requestHeaders) Set(key, value string) {
+	h.c.Request().Header.Set(key, value)
+}
+
+func (h requestHeaders) Keys() []string {
+	var keys []string
+	h.c.Request().Header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+
+	return keys
+}
@@ -1,13 +1,14 @@
 package observe
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/getsentry/sentry-go"
-	"github.com/pkg/errors"
-
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -17,44 +18,63 @@ const (
 	_sentryServerRequestTimeout time.Duration = 5 * time.Second
 )
 
+// sentryTags are the log fields reported as tags of an event, so that events
+// can be searched by them; the other fields are reported as extra data.
+var sentryTags = []string{"tenant", "request_id", "trace_id", "span_id"}
+
+// SentryOptions configures the reporting of a SentryHook.
+type SentryOptions struct {
+	DSN         string
+	Environment string
+	Release     string
+	ServerName  string
+	// SampleRate is the share of the events sent, from 0 to 1; 0 sends all.
+	SampleRate    float64
+	MaxErrorDepth int
+	Debug         bool
+	// Transport sends the events; by default they are sent over HTTP to the
+	// DSN. Without a DSN nor a transport, events are dropped.
+	Transport sentry.Transport
+}
+
+// SentryHook reports the errors of a Logger and the panics of the HTTP
+// handlers to Sentry. It is a zap core that takes the Error and Fatal
+// entries; see Logger.WithSentry.
 type SentryHook struct {
-	appZone string
-	appName string
-	l       *Logger
+	hub    *sentry.Hub
+	fields []zapcore.Field
 }
 
-func NewSentryHook(
-	appZone, appName string,
-	maxErrorDepth int,
-	isDebug bool,
-	dsn string,
-) *SentryHook {
-	if dsn == "" {
-		log.Println("Stacktracer init error: no DSN")
-	}
-	if maxErrorDepth == 0 {
-		maxErrorDepth = _sentryMaxErrorDepth
+var _ zapcore.Core = (*SentryHook)(nil)
+
+func NewSentryHook(opts SentryOptions) (*SentryHook, error) {
+	if opts.MaxErrorDepth == 0 {
+		opts.MaxErrorDepth = _sentryMaxErrorDepth
 	}
-	sentryTransport := sentry.NewHTTPTransport()
-	sentryTransport.Timeout = _sentryServerRequestTimeout
-	if err := sentry.Init(
-		sentry.ClientOptions{
-			AttachStacktrace: true,
-			Debug:            isDebug,
-			Dsn:              dsn,
-			Environment:      appZone,
-			MaxErrorDepth:    _sentryMaxErrorDepth,
-			ServerName:       appName,
-			Transport:        sentryTransport,
-		}); err != nil {
-
-		log.Println("Stacktracer init error: ", err.Error())
+
+	transport := opts.Transport
+	if transport == nil && opts.DSN != "" {
+		httpTransport := sentry.NewHTTPTransport()
+		httpTransport.Timeout = _sentryServerRequestTimeout
+		transport = httpTransport
 	}
-	log.Println("Stacktracer init success")
-	return &SentryHook{
-		appZone: appZone,
-		appName: appName,
+
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		AttachStacktrace: true,
+		Debug:            opts.Debug,
+		Dsn:              opts.DSN,
+		Environment:      opts.Environment,
+		Release:          opts.Release,
+		MaxErrorDepth:    opts.MaxErrorDepth,
+		SampleRate:       opts.SampleRate,
+		ServerName:       opts.ServerName,
+		Transport:        transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sentry client: %w", err)
 	}
+
+	return &SentryHook{hub: sentry.NewHub(client, sentry.NewScope())}, nil
 }
 
 func (*SentryHook) mapLevel(zl zapcore.Level) sentry.Level {
@@ -69,7 +89,7 @@ func (*SentryHook) mapLevel(zl zapcore.Level) sentry.Level {
 		return sentry.LevelWarning
 	case zapcore.ErrorLevel:
 		return sentry.LevelError
-	case zapcore.FatalLevel, zapcore.PanicLevel:
+	case zapcore.FatalLevel, zapcore.PanicLevel, zapcore.DPanicLevel:
 		return sentry.LevelFatal
 
 	}
@@ -77,80 +97,101 @@ func (*SentryHook) mapLevel(zl zapcore.Level) sentry.Level {
 	return sentry.LevelDebug
 }
 
-func (h *SentryHook) Write(p []byte) (n int, err error) {
-
-	if h.appZone == "prod" || h.appZone == "dev" {
-		type T struct {
-			Level      string `json:"level"`
-			AppName    string `json:"app_name"`
-			AppEnv     string `json:"app_env"`
-			CallerFile string `json:"caller_file"`
-			CallerLine int    `json:"caller_line"`
-			CallerFunc string `json:"caller_func"`
-			Stack      string `json:"stack"`
-			Message    string `json:"msg"`
-			Error      string `json:"error"`
-			Timestamp  string `json:"timestamp"`
-		}
-		t := T{}
-		if err := json.Unmarshal(p, &t); err == nil {
-			level, err := zapcore.ParseLevel(t.Level)
-			if err == nil && len(t.Message) > 0 {
-				timestamp, _ := time.ParseInLocation("2006-01-02T15-04-05.000", t.Timestamp, time.FixedZone("Europe/Moscow", 3*3600))
-
-				switch level {
-
-				case zapcore.ErrorLevel, zapcore.FatalLevel, zapcore.PanicLevel:
-					event := sentry.NewEvent()
-					event.Extra["AppName"] = h.appName
-					event.Environment = h.appZone
-					event.Level = h.mapLevel(level)
-					event.Timestamp = timestamp
-					event.Message = t.Message
-					event.Extra["Error"] = t.Error
-					event.Extra["CallerFile"] = t.CallerFile
-					event.Extra["CallerLine"] = t.CallerLine
-					event.Extra["CallerFunc"] = t.CallerFunc
-					event.Extra["Stack"] = t.Stack
-					event.Extra["TimeStamp"] = t.Timestamp
-					for k, v := range event.Tags {
-						if v == "" {
-							delete(event.Tags, k)
-						}
-					}
-					event.Exception = append(event.Exception, sentry.Exception{
-						Type:       t.Message,
-						Value:      t.Error,
-						Stacktrace: sentry.NewStacktrace(),
-					})
-					sentry.CaptureEvent(event)
-				}
-
-			} else if err != nil {
-				msg := errors.Wrap(err, "[SentryHook] parse zap level: ")
-				if h.l != nil {
-					h.l.Error(msg)
-				} else {
-					log.Println(msg.Error())
-				}
-			}
-
-		} else {
-			msg := errors.New("[SentryHook] json.Unmarshal data")
-			if h.l != nil {
-				h.l.Error(msg)
-			} else {
-				log.Println(msg.Error())
-			}
+// Enabled takes the Error entries and the more severe ones.
+func (h *SentryHook) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.ErrorLevel
+}
+
+func (h *SentryHook) With(fields []zapcore.Field) zapcore.Core {
+	return &SentryHook{
+		hub:    h.hub,
+		fields: append(h.fields[:len(h.fields):len(h.fields)], fields...),
+	}
+}
+
+func (h *SentryHook) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if h.Enabled(entry.Level) {
+		return checked.AddCore(entry, h)
+	}
+
+	return checked
+}
+
+// Write reports the entry as an event. A Fatal entry is flushed right away,
+// as the process exits after it.
+func (h *SentryHook) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range h.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	event := sentry.NewEvent()
+	event.Level = h.mapLevel(entry.Level)
+	event.Message = entry.Message
+	event.Timestamp = entry.Time
+
+	for _, key := range sentryTags {
+		if value, ok := enc.Fields[key]; ok {
+			event.Tags[key] = fmt.Sprint(value)
+			delete(enc.Fields, key)
 		}
+	}
+
+	errText, _ := enc.Fields["error"].(string)
+	for key, value := range enc.Fields {
+		event.Extra[key] = value
+	}
+
+	event.Exception = append(event.Exception, sentry.Exception{
+		Type:       entry.Message,
+		Value:      errText,
+		Stacktrace: sentry.NewStacktrace(),
+	})
+
+	h.hub.CaptureEvent(event)
+
+	if entry.Level > zapcore.ErrorLevel {
+		h.hub.Flush(_sentryFlushTimeout)
+	}
+
+	return nil
+}
+
+// Sync flushes the events not sent yet.
+func (h *SentryHook) Sync() error {
+	if !h.hub.Flush(_sentryFlushTimeout) {
+		return fmt.Errorf("failed to flush sentry events within %s", _sentryFlushTimeout)
+	}
+
+	return nil
+}
+
+// CapturePanic reports a panic recovered while serving the request, tagged
+// with the given tags and with the trace of ctx.
+func (h *SentryHook) CapturePanic(ctx context.Context, r *http.Request, tags map[string]string, recovered any) {
+	hub := h.hub.Clone()
+	hub.Scope().SetRequest(r)
+	hub.Scope().SetTags(tags)
 
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		hub.Scope().SetTag("trace_id", sc.TraceID().String())
+		hub.Scope().SetTag("span_id", sc.SpanID().String())
 	}
 
-	return len(p), nil
+	hub.RecoverWithContext(ctx, recovered)
 }
 
-func (h *SentryHook) SetLogger(logger *Logger) {
-	if logger != nil {
-		h.l = logger
+// WithSentry returns a logger that also reports its Error and Fatal entries
+// to Sentry.
+func (l *Logger) WithSentry(hook *SentryHook) *Logger {
+	return &Logger{
+		appEnv:  l.appEnv,
+		appName: l.appName,
+		l: l.l.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(core, hook)
+		})),
 	}
 }
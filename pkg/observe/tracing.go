@@ -0,0 +1,128 @@
+package observe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span exporters of InitTracing.
+const (
+	TracingNone   = "none"
+	TracingStdout = "stdout"
+	TracingFile   = "file"
+	TracingOTLP   = "otlp"
+)
+
+// tracerName is the instrumentation scope of the spans of the service.
+const tracerName = "rsclabs-test"
+
+// InitTracing installs the global tracer provider, exporting the spans to
+// stdout, to a file or over OTLP/HTTP. The OTLP exporter reads its endpoint
+// from the OTEL_EXPORTER_OTLP_* variables. With TracingNone the spans are
+// only propagated, so that trace IDs still reach the logs. The returned
+// function flushes the pending spans and closes the exporter.
+func InitTracing(ctx context.Context, appName, appVersion, exporter, file string) (func(context.Context) error, error) {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(appName),
+			semconv.ServiceVersion(appVersion),
+		)),
+	}
+
+	closeOutput := func() error { return nil }
+
+	switch exporter {
+	case "", TracingNone:
+	case TracingStdout:
+		exp, err := newWriterExporter(os.Stdout)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case TracingFile:
+		if file == "" {
+			return nil, fmt.Errorf("no file for the %s span exporter", TracingFile)
+		}
+
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open span file: %w", err)
+		}
+		closeOutput = f.Close
+
+		exp, err := newWriterExporter(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case TracingOTLP:
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create otlp span exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	default:
+		return nil, fmt.Errorf("unknown span exporter %q", exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return func(ctx context.Context) error {
+		if err := provider.Shutdown(ctx); err != nil {
+			_ = closeOutput()
+			return fmt.Errorf("failed to shut down tracer provider: %w", err)
+		}
+
+		return closeOutput()
+	}, nil
+}
+
+// newWriterExporter exports the spans to w, one JSON object per line.
+func newWriterExporter(w io.Writer) (sdktrace.SpanExporter, error) {
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create span exporter: %w", err)
+	}
+
+	return exp, nil
+}
+
+// Tracer returns the tracer of the spans of the service.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// StartSpan starts a span of the service as a child of the span in ctx.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends the span, marking it as failed with a non-nil err.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
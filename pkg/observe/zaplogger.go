@@ -1,11 +1,13 @@
 package observe
 
 import (
+	"context"
 	"io"
 	"os"
 	"runtime"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -45,6 +47,25 @@ func NewZapLogger(appName string, writers ...io.Writer) *Logger {
 	}
 }
 
+// WithContext returns a logger that adds the IDs of the trace and span in ctx
+// to every entry, so that the logs of a request can be found from its trace.
+// Without a span in ctx it returns l.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return l
+	}
+
+	return &Logger{
+		appEnv:  l.appEnv,
+		appName: l.appName,
+		l: l.l.With(
+			zap.String("trace_id", sc.TraceID().String()),
+			zap.String("span_id", sc.SpanID().String()),
+		),
+	}
+}
+
 func (l *Logger) Stop() (err error) {
 	if err = l.l.Sync(); err != nil {
 		return